@@ -0,0 +1,119 @@
+package chat
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// largest image that is attached. the providers reject bigger ones, anthropic already at 5MB
+const maxAttachmentSize = 20 << 20
+
+// image formats every provider with vision accepts
+var imageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// tokens counted for every attached image when fitting the context window. the providers count between a few hundred and a couple of thousand depending on the size
+const imageTokens = 1000
+
+// the models that can read images can be extended in the .env file, e.g. VISION_MODELS=ollama/llava-llama3:latest,my-vision-model
+func init() {
+	RegisterSetting(ConfigKey{Name: "VISION_MODELS"})
+}
+
+// models that can read images, matched by the longest prefix of the model name. false marks models whose name starts like one that can, but that can't themselves
+var visionModels = map[string]bool{
+	"gpt-4o":                true,
+	"gpt-4-turbo":           true,
+	"gpt-4-turbo-preview":   false,
+	"gpt-4-vision":          true,
+	"gpt-4-1106-vision":     true,
+	"claude-3":              true,
+	"gemini-1.5":            true,
+	"gemini-pro-vision":     true,
+	"gemini-1.0-pro-vision": true,
+	"llava":                 true,
+	"bakllava":              true,
+	"moondream":             true,
+	"pixtral":               true,
+	"minicpm-v":             true,
+}
+
+// LoadAttachment reads an image file to send along with a message. The format is found from the contents, not the extension
+func LoadAttachment(path string) (Attachment, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if info.Size() > maxAttachmentSize {
+		return Attachment{}, fmt.Errorf("%s is too large to attach (%d MB, at most %d MB)", path, info.Size()>>20, maxAttachmentSize>>20)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Attachment{}, err
+	}
+	mimeType := http.DetectContentType(data)
+	supported := false
+	for _, imageType := range imageTypes {
+		if mimeType == imageType {
+			supported = true
+		}
+	}
+	if !supported {
+		return Attachment{}, fmt.Errorf("%s is not an image that can be attached (%s). Use png, jpeg, gif or webp", path, mimeType)
+	}
+	return Attachment{Name: filepath.Base(path), MimeType: mimeType, Data: data}, nil
+}
+
+// Base64 returns the data of the attachment encoded the way the providers expect it
+func (a Attachment) Base64() string {
+	return base64.StdEncoding.EncodeToString(a.Data)
+}
+
+// DataURL returns the attachment as a data url, e.g. data:image/png;base64,...
+func (a Attachment) DataURL() string {
+	return "data:" + a.MimeType + ";base64," + a.Base64()
+}
+
+// ErrNoVision is returned when images are sent to a model that can't read them
+var ErrNoVision = errors.New("the model can't read images")
+
+// SupportsVision reports whether the model can read images. qualified is the model with its provider, e.g. ollama/llava:latest. models in VISION_MODELS win over the built in table
+func (chat Chat) SupportsVision(qualified string) bool {
+	_, bare := SplitModelName(qualified)
+	for _, name := range strings.Split(chat.ConfigValue("VISION_MODELS"), ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && (name == qualified || name == bare) {
+			return true
+		}
+	}
+	// gemini names start with models/
+	bare = strings.TrimPrefix(bare, "models/")
+	vision, longest := false, 0
+	for prefix, supported := range visionModels {
+		if strings.HasPrefix(bare, prefix) && len(prefix) > longest {
+			vision, longest = supported, len(prefix)
+		}
+	}
+	return vision
+}
+
+// checks that the model can read the attached images. images earlier in the session are left out for models that can't, so the session can go on with any model
+func (chat Chat) checkVision(qualified string) (Chat, error) {
+	if chat.SupportsVision(qualified) {
+		return chat, nil
+	}
+	if len(chat.Attachments) > 0 {
+		return chat, fmt.Errorf("%w: %s. Choose a model with vision, such as gpt-4o, claude-3-5-sonnet-20240620, gemini-1.5-pro or ollama/llava, or add it to VISION_MODELS", ErrNoVision, qualified)
+	}
+	session := make([]Message, len(chat.Session))
+	for i, message := range chat.Session {
+		message.Attachments = nil
+		session[i] = message
+	}
+	chat.Session = session
+	return chat, nil
+}
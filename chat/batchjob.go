@@ -0,0 +1,303 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xssdoctor/gofabric/utils"
+)
+
+// states of a batch job. every provider's state is mapped to one of these
+const (
+	BatchRunning   = "running"   // the provider is still working on the requests
+	BatchEnded     = "ended"     // every request has finished, successfully or not
+	BatchFailed    = "failed"    // the provider rejected the whole batch
+	BatchExpired   = "expired"   // the provider gave up before every request was done. the ones that were done have results
+	BatchCancelled = "cancelled" // the batch was cancelled. the requests done before that have results
+)
+
+// the providers charge half the price for requests sent in a batch
+const batchDiscount = 0.5
+
+// ErrNoBatchAPI is returned when the provider of the model can't run batches
+var ErrNoBatchAPI = errors.New("the provider has no batch api")
+
+// BatchRequest is one request of a batch: a message sent with a pattern
+type BatchRequest struct {
+	ID      string // names the request in the results. it can be anything, e.g. a path
+	Pattern string
+	Message string
+}
+
+// BatchStatus is the progress of a batch as the provider reports it
+type BatchStatus struct {
+	State     string `json:"state"` // one of the Batch constants
+	Total     int    `json:"total"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Error     string `json:"error,omitempty"` // why the batch failed, when it did
+}
+
+// Done reports whether the provider has stopped working on the batch, so its results can be downloaded
+func (s BatchStatus) Done() bool {
+	return s.State != BatchRunning
+}
+
+// BatchResult is the answer to one request of a batch. Err is set when the request failed
+type BatchResult struct {
+	ID       string
+	Response Response
+	Err      error
+}
+
+// BatchModel is implemented by models whose provider can run many requests asynchronously at a lower price. the model's settings and context apply to every request, and each request brings its own pattern and message. the id returned by SubmitBatch is the provider's
+type BatchModel interface {
+	SubmitBatch(ctx context.Context, requests []BatchRequest) (string, error)
+	BatchStatus(ctx context.Context, id string) (BatchStatus, error)
+	BatchResults(ctx context.Context, id string) ([]BatchResult, error)
+}
+
+// BatchJob is a batch that was submitted to a provider. jobs are kept in ~/.config/fabric/batches, so they can be followed and their results downloaded by later runs
+type BatchJob struct {
+	ID         string          `json:"id"` // id the provider gave the batch
+	Provider   string          `json:"provider"`
+	Model      string          `json:"model"`
+	Pattern    string          `json:"pattern,omitempty"` // name of the pattern, for the usage log
+	Requests   []string        `json:"requests"`          // ids of the requests
+	Output     string          `json:"output"`            // where the results are written
+	Created    time.Time       `json:"created"`
+	Status     BatchStatus     `json:"status"` // last status that was fetched
+	Downloaded bool            `json:"downloaded,omitempty"`
+	Schema     json.RawMessage `json:"schema,omitempty"` // json schema the replies are checked against when they are downloaded
+}
+
+// SubmitBatch sends the requests to the provider of the chat's model as one batch and saves the job. output is kept with the job for the caller that downloads the results
+func (chat Chat) SubmitBatch(ctx context.Context, requests []BatchRequest, output string) (BatchJob, error) {
+	provider, model, err := chat.findProvider(ctx)
+	if err != nil {
+		return BatchJob{}, err
+	}
+	chat.Model = model
+	batchModel, ok := provider.New(chat).(BatchModel)
+	if !ok {
+		return BatchJob{}, fmt.Errorf("%s: %w", provider.Name, ErrNoBatchAPI)
+	}
+	// the providers limit what the id of a request may contain, so the requests are sent numbered and their own ids are kept with the job
+	numbered := make([]BatchRequest, len(requests))
+	for i, request := range requests {
+		numbered[i] = request
+		numbered[i].ID = batchRequestID(i)
+		if len(chat.Schema) > 0 {
+			numbered[i].Pattern += schemaInstructions(chat.Schema)
+		}
+	}
+	id, err := batchModel.SubmitBatch(ctx, numbered)
+	if err != nil {
+		return BatchJob{}, err
+	}
+	job := BatchJob{
+		ID:       id,
+		Provider: provider.Name,
+		Model:    model,
+		Pattern:  chat.PatternName,
+		Output:   output,
+		Created:  time.Now(),
+		Status:   BatchStatus{State: BatchRunning, Total: len(requests)},
+		Schema:   chat.Schema,
+	}
+	for _, request := range requests {
+		job.Requests = append(job.Requests, request.ID)
+	}
+	return job, job.Save()
+}
+
+// builds the model that talks to the job's provider
+func (chat Chat) batchModel(job BatchJob) (BatchModel, error) {
+	provider, ok := GetProvider(job.Provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %s", job.Provider)
+	}
+	chat.Model = job.Model
+	batchModel, ok := provider.New(chat).(BatchModel)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", provider.Name, ErrNoBatchAPI)
+	}
+	return batchModel, nil
+}
+
+// RefreshBatch asks the provider how far the job is and saves what it says
+func (chat Chat) RefreshBatch(ctx context.Context, job *BatchJob) error {
+	batchModel, err := chat.batchModel(*job)
+	if err != nil {
+		return err
+	}
+	status, err := batchModel.BatchStatus(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	job.Status = status
+	return job.Save()
+}
+
+// BatchResults downloads the results of a job that is done. the usage is logged at the batch price the first time they are downloaded. when the job has a schema, replies that don't match it are returned as failed
+func (chat Chat) BatchResults(ctx context.Context, job *BatchJob) ([]BatchResult, error) {
+	batchModel, err := chat.batchModel(*job)
+	if err != nil {
+		return nil, err
+	}
+	results, err := batchModel.BatchResults(ctx, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]string, len(job.Requests))
+	for i, id := range job.Requests {
+		ids[batchRequestID(i)] = id
+	}
+	for i := range results {
+		if id, ok := ids[results[i].ID]; ok {
+			results[i].ID = id
+		}
+		results[i].Response.Provider = job.Provider
+		if results[i].Response.Model == "" {
+			results[i].Response.Model = job.Model
+		}
+	}
+	if !job.Downloaded {
+		job.recordUsage(results)
+		job.Downloaded = true
+		if err := job.Save(); err != nil {
+			return nil, err
+		}
+	}
+	// replies that don't match the schema count as failed, so running the batch again redoes them
+	if len(job.Schema) > 0 {
+		var schema interface{}
+		if err := json.Unmarshal(job.Schema, &schema); err != nil {
+			return nil, fmt.Errorf("could not read the json schema: %v", err)
+		}
+		for i := range results {
+			if results[i].Err != nil {
+				continue
+			}
+			value, problems := checkJSON(results[i].Response.Text, schema)
+			if len(problems) > 0 {
+				results[i].Err = fmt.Errorf("the reply does not match the json schema: %s", strings.Join(problems, "; "))
+				continue
+			}
+			results[i].Response.Text = value
+		}
+	}
+	return results, nil
+}
+
+// logs the usage of the requests that were answered, at the discount of the batch api. failed requests are not billed
+func (job BatchJob) recordUsage(results []BatchResult) {
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		qualified := result.Response.QualifiedModel()
+		cost, priced := Cost(qualified, result.Response.Usage)
+		record := UsageRecord{
+			Time:    time.Now(),
+			Model:   qualified,
+			Pattern: job.Pattern,
+			Usage:   result.Response.Usage,
+			Cost:    cost * batchDiscount,
+			Priced:  priced,
+		}
+		if err := appendUsage(record); err != nil {
+			utils.LogWarning(fmt.Errorf("could not record usage: %v", err))
+			break
+		}
+	}
+}
+
+// id a request is sent with, from its place in the batch
+func batchRequestID(i int) string {
+	return "request-" + strconv.Itoa(i)
+}
+
+// path of the file that keeps a job, ~/.config/fabric/batches/<id>.json
+func batchJobPath(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid batch id %q", id)
+	}
+	return fabricPath(filepath.Join("batches", id+".json"))
+}
+
+// Save writes the job to ~/.config/fabric/batches
+func (job BatchJob) Save() error {
+	path, err := batchJobPath(job.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	contents, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, contents, 0644)
+}
+
+// LoadBatchJob reads the job with the provider's id
+func LoadBatchJob(id string) (BatchJob, error) {
+	path, err := batchJobPath(id)
+	if err != nil {
+		return BatchJob{}, err
+	}
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return BatchJob{}, fmt.Errorf("no batch job %s. See the jobs with --batch-jobs", id)
+	}
+	if err != nil {
+		return BatchJob{}, err
+	}
+	var job BatchJob
+	if err := json.Unmarshal(contents, &job); err != nil {
+		return BatchJob{}, fmt.Errorf("could not read batch job %s: %v", id, err)
+	}
+	return job, nil
+}
+
+// ListBatchJobs returns every job that was submitted, oldest first
+func ListBatchJobs() ([]BatchJob, error) {
+	dir, err := fabricPath("batches")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []BatchJob
+	for _, entry := range entries {
+		id, found := strings.CutSuffix(entry.Name(), ".json")
+		if !found || entry.IsDir() {
+			continue
+		}
+		job, err := LoadBatchJob(id)
+		if err != nil {
+			utils.LogWarning(err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+	return jobs, nil
+}
@@ -0,0 +1,103 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// a provider that answers a batch with the messages of its requests in reverse order, the way providers return results in any order
+type fakeBatchModel struct {
+	submitted *[]BatchRequest
+}
+
+func (m fakeBatchModel) SendMessage(ctx context.Context) (Response, error)   { return Response{}, nil }
+func (m fakeBatchModel) StreamMessage(ctx context.Context) (Response, error) { return Response{}, nil }
+func (m fakeBatchModel) ListModels(ctx context.Context) ([]string, error)    { return nil, nil }
+
+func (m fakeBatchModel) SubmitBatch(ctx context.Context, requests []BatchRequest) (string, error) {
+	*m.submitted = requests
+	return "fakebatch_1", nil
+}
+
+func (m fakeBatchModel) BatchStatus(ctx context.Context, id string) (BatchStatus, error) {
+	return BatchStatus{State: BatchEnded, Total: len(*m.submitted), Succeeded: len(*m.submitted)}, nil
+}
+
+func (m fakeBatchModel) BatchResults(ctx context.Context, id string) ([]BatchResult, error) {
+	var results []BatchResult
+	for i := len(*m.submitted) - 1; i >= 0; i-- {
+		request := (*m.submitted)[i]
+		if request.Message == "fail" {
+			results = append(results, BatchResult{ID: request.ID, Err: errors.New("refused")})
+			continue
+		}
+		results = append(results, BatchResult{ID: request.ID, Response: Response{Text: request.Message, Usage: Usage{InputTokens: 10, OutputTokens: 2}}})
+	}
+	return results, nil
+}
+
+func TestBatchJob(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var submitted []BatchRequest
+	if _, ok := GetProvider("fakebatch"); !ok {
+		Register(Provider{Name: "fakebatch", New: func(Chat) Model { return fakeBatchModel{submitted: &submitted} }})
+	}
+	chat := Chat{Model: "fakebatch/model-1", PatternName: "extract", Schema: json.RawMessage(`{"type":"object","required":["title"]}`)}
+	requests := []BatchRequest{
+		{ID: "docs/a.txt", Pattern: "extract", Message: `{"title": "a"}`},
+		{ID: "docs/b.txt", Pattern: "extract", Message: `no json`},
+		{ID: "docs/c.txt", Pattern: "extract", Message: "fail"},
+	}
+	job, err := chat.SubmitBatch(context.Background(), requests, "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the provider gets numbered ids, with the schema added to the pattern
+	for i, request := range submitted {
+		if request.ID != batchRequestID(i) || !strings.HasPrefix(request.Pattern, "extract") || request.Pattern == "extract" {
+			t.Errorf("request %d was submitted as %+v", i, request)
+		}
+	}
+	loaded, err := LoadBatchJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chat.RefreshBatch(context.Background(), &loaded); err != nil || !loaded.Status.Done() {
+		t.Fatalf("the job is %+v after refreshing, %v", loaded.Status, err)
+	}
+
+	results, err := chat.BatchResults(context.Background(), &loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[string]BatchResult)
+	for _, result := range results {
+		byID[result.ID] = result
+	}
+	if result := byID["docs/a.txt"]; result.Err != nil || result.Response.Provider != "fakebatch" || result.Response.Model != "model-1" || !strings.Contains(result.Response.Text, `"title": "a"`) {
+		t.Errorf("docs/a.txt has the result %+v", result)
+	}
+	if result := byID["docs/b.txt"]; result.Err == nil || !strings.Contains(result.Err.Error(), "json schema") {
+		t.Errorf("docs/b.txt doesn't match the schema, but has the result %+v", result)
+	}
+	if result := byID["docs/c.txt"]; result.Err == nil || result.Err.Error() != "refused" {
+		t.Errorf("docs/c.txt has the result %+v, want the error refused", result)
+	}
+
+	// the usage is logged the first time the results are downloaded, for the requests that were answered
+	if _, err := chat.BatchResults(context.Background(), &loaded); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := fabricPath("usage.jsonl")
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(contents), "\n"); lines != 2 {
+		t.Errorf("the usage log has %d lines, want 2:\n%s", lines, contents)
+	}
+}
@@ -0,0 +1,140 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// default time a provider's listing is trusted before it is fetched again
+const defaultModelCacheTTL = 24 * time.Hour
+
+// time a provider that could not be listed is left alone before it is asked again. it is much shorter than the ttl, so a provider that was down for a moment doesn't keep its old listing for a day
+const failedListingBackoff = 5 * time.Minute
+
+// the model cache ttl can be changed in the .env file, e.g. MODEL_CACHE_TTL=1h. 0 turns the cache off
+func init() {
+	RegisterSetting(ConfigKey{Name: "MODEL_CACHE_TTL", Default: defaultModelCacheTTL.String()})
+}
+
+// ProviderModels is the cached listing of a single provider
+type ProviderModels struct {
+	Models    []string  `json:"models"`
+	FetchedAt time.Time `json:"fetched_at"` // last time the listing was fetched successfully
+	CheckedAt time.Time `json:"checked_at"` // last time the provider was asked, successful or not
+	Stale     bool      `json:"stale"`      // true when the last fetch failed and the models come from an older listing
+}
+
+// only one run should rewrite the cache file at a time
+var modelCacheMu sync.Mutex
+
+// path of the cache file, ~/.config/fabric/models_cache.json
+func modelCachePath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "fabric", "models_cache.json"), nil
+}
+
+// reads the cache file. a missing or broken file is treated as an empty cache
+func readModelCache() map[string]ProviderModels {
+	cache := make(map[string]ProviderModels)
+	path, err := modelCachePath()
+	if err != nil {
+		return cache
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(contents, &cache); err != nil {
+		return make(map[string]ProviderModels)
+	}
+	return cache
+}
+
+func writeModelCache(cache map[string]ProviderModels) error {
+	path, err := modelCachePath()
+	if err != nil {
+		return err
+	}
+	contents, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, contents, 0644)
+}
+
+// time after which the provider is asked again. a listing that failed last time is retried after the backoff
+func (cached ProviderModels) recheckAfter(ttl time.Duration) time.Duration {
+	if cached.Stale && ttl > failedListingBackoff {
+		return failedListingBackoff
+	}
+	return ttl
+}
+
+// returns the ttl from the MODEL_CACHE_TTL setting
+func (chat Chat) modelCacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(chat.ConfigValue("MODEL_CACHE_TTL"))
+	if err != nil || ttl < 0 {
+		return defaultModelCacheTTL
+	}
+	return ttl
+}
+
+// LoadModels returns the models of every provider, keyed by provider name, using the cache in ~/.config/fabric when it is fresh. Providers whose listing is older than MODEL_CACHE_TTL, or every provider when chat.RefreshModels is set, are fetched again concurrently. When a provider can't be reached its old listing is kept and marked as stale
+func LoadModels(ctx context.Context, chat Chat) (map[string]ProviderModels, []error) {
+	modelCacheMu.Lock()
+	defer modelCacheMu.Unlock()
+	cache := readModelCache()
+	ttl := chat.modelCacheTTL()
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	providers := Providers()
+	resultsChan := make(chan listResult, len(providers))
+	for _, provider := range providers {
+		cached, ok := cache[provider.Name]
+		if ok && !chat.RefreshModels && now.Sub(cached.CheckedAt) < cached.recheckAfter(ttl) {
+			continue
+		}
+		wg.Add(1)
+		createGoroutines(ctx, &wg, provider, chat, resultsChan)
+	}
+	wg.Wait()
+	close(resultsChan)
+
+	errs := make([]error, 0)
+	changed := false
+	for result := range resultsChan {
+		changed = true
+		if result.err != nil {
+			errs = append(errs, result.err)
+			// remembering the failure keeps a dead endpoint from slowing down every run, until the backoff runs out
+			cached := cache[result.provider]
+			cached.Stale = true
+			cached.CheckedAt = now
+			cache[result.provider] = cached
+			continue
+		}
+		cache[result.provider] = ProviderModels{Models: result.models, FetchedAt: now, CheckedAt: now}
+	}
+	if changed {
+		if err := writeModelCache(cache); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// only registered providers that were listed at least once are returned
+	modelsMap := make(map[string]ProviderModels, len(providers))
+	for _, provider := range providers {
+		if cached, ok := cache[provider.Name]; ok && !cached.FetchedAt.IsZero() {
+			modelsMap[provider.Name] = cached
+		}
+	}
+	return modelsMap, errs
+}
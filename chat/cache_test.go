@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// moves the last time the provider was asked back by d, as if d had passed
+func ageModelCache(t *testing.T, provider string, d time.Duration) {
+	t.Helper()
+	cache := readModelCache()
+	cached := cache[provider]
+	cached.CheckedAt = cached.CheckedAt.Add(-d)
+	cache[provider] = cached
+	if err := writeModelCache(cache); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadModelsRetriesFailures(t *testing.T) {
+	testHome(t)
+	calls := 0
+	listings := []func() ([]string, error){
+		func() ([]string, error) { return []string{"m1"}, nil },
+		func() ([]string, error) { return nil, errors.New("connection refused") },
+		func() ([]string, error) { return []string{"m1", "m2"}, nil },
+	}
+	stubProvider(t, "stubcache", stub{list: func() ([]string, error) {
+		calls++
+		return listings[calls-1]()
+	}})
+	load := func() ProviderModels {
+		t.Helper()
+		models, _ := LoadModels(context.Background(), Chat{})
+		return models["stubcache"]
+	}
+
+	if got := load(); !reflect.DeepEqual(got.Models, []string{"m1"}) || got.Stale {
+		t.Fatalf("the first listing is %+v", got)
+	}
+	// the listing is kept for the ttl
+	ageModelCache(t, "stubcache", time.Hour)
+	load()
+	if calls != 1 {
+		t.Fatalf("the provider was asked %d times within the ttl, want once", calls)
+	}
+
+	// once the ttl runs out the provider is asked again. it fails, so the old listing is kept
+	ageModelCache(t, "stubcache", defaultModelCacheTTL)
+	if got := load(); calls != 2 || !reflect.DeepEqual(got.Models, []string{"m1"}) || !got.Stale {
+		t.Fatalf("after a failed fetch the listing is %+v and the provider was asked %d times", got, calls)
+	}
+	load()
+	if calls != 2 {
+		t.Fatalf("the provider that failed was asked again right away")
+	}
+
+	// the failure is only remembered for the backoff, not the whole ttl
+	ageModelCache(t, "stubcache", failedListingBackoff)
+	if got := load(); calls != 3 || !reflect.DeepEqual(got.Models, []string{"m1", "m2"}) || got.Stale {
+		t.Errorf("after the backoff the listing is %+v and the provider was asked %d times, want the new listing", got, calls)
+	}
+}
@@ -1,171 +1,271 @@
 package chat
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/xssdoctor/gofabric/models"
 	"github.com/xssdoctor/gofabric/utils"
 )
 
 // chat struct. This is passed to the SendMessageToModel function to interact with the models
 type Chat struct {
 	Message          string
+	Attachments      []Attachment // images sent along with the message
 	Pattern          string
-	OpenAIApiKey     string
-	AnthropicApiKey  string
-	GroqApiKey       string
-	GoogleApiKey     string
-	Session          []map[string]string
+	PatternName      string            // name of the pattern, recorded in the usage log
+	SessionName      string            // name of the session the chat belongs to, recorded in the usage log
+	Config           map[string]string // provider configuration from ~/.config/fabric/.env, keyed by the names in ConfigKeys
+	Session          []Message
 	Context          string
 	Model            string
-	OllamaUrl        string
+	Fallbacks        []string // models tried in order when Model fails. when empty, the FALLBACK_MODELS setting is used
+	NoFallback       bool     // only try Model, e.g. when comparing models
 	Temperature      float64
 	TopP             float64
 	PresencePenalty  float64
 	FrequencyPenalty float64
 	Stream           bool
-	ResponseChan     chan string
+	RefreshModels    bool             // ignore the cached model listings and fetch them again
+	Timeout          time.Duration    // overrides the provider's timeout when set
+	Tools            []Tool           // tools the model may call, from the pattern's tools.json
+	ConfirmTool      ToolConfirmer    // asked before every tool call. no tool runs when it is nil
+	Schema           json.RawMessage  // json schema the reply must match, from the pattern's schema.json or --json-schema
+	ResponseChan     chan StreamEvent // receives the response when streaming. the last event carries the complete response, then the channel is closed
 }
 
-// the following functions are meant to be used with the Model interface in order to interact with any of the three models
-func SendMessage(model Model) (string, error) {
-	return model.SendMessage()
+// listing models should be quick, so it never waits longer than this even if the provider's timeout is longer
+const listTimeout = 30 * time.Second
+
+// the following functions are meant to be used with the Model interface in order to interact with any of the registered models
+func SendMessage(ctx context.Context, model Model) (Response, error) {
+	return model.SendMessage(ctx)
+}
+
+func StreamMessage(ctx context.Context, model Model) (Response, error) {
+	return model.StreamMessage(ctx)
 }
 
-func StreamMessage(model Model) error {
-	return model.StreamMessage()
+func ListModels(ctx context.Context, model Model) ([]string, error) {
+	return model.ListModels(ctx)
 }
 
-func ListModels(model Model) ([]string, error) {
-	return model.ListModels()
+// result of listing the models of a single provider
+type listResult struct {
+	provider string
+	models   []string
+	err      error
 }
 
-// ListAllModels returns a map of all models and any errors that occurred Uses concurrence to make it faster
-func ListAllModels(chat Chat) (map[string][]string, []error) {
+// ListAllModels returns a map of all models keyed by provider name and any errors that occurred. Uses concurrence to make it faster
+func ListAllModels(ctx context.Context, chat Chat) (map[string][]string, []error) {
 	var wg sync.WaitGroup
-	// make the channels
-	openaiModelsChan := make(chan []string, 1)
-	claudeModelsChan := make(chan []string, 1)
-	ollamaModelsChan := make(chan []string, 1)
-	groqModelsChan := make(chan []string, 1)
-	googleModelsChan := make(chan []string, 1)
-	errorsChan := make(chan error, 5)
-
-	wg.Add(5) // We have five concurrent operations.
+	providers := Providers()
+	resultsChan := make(chan listResult, len(providers))
 	errs := make([]error, 0)
 	// create a map to store the models. this is used to check if the model is in the list of available models
-	modelsMap := make(map[string][]string, 4)
-	// add the api keys to the structs, these values come from the chat struct
-	openai := &models.Openai{}
-	openai.ApiKey = chat.OpenAIApiKey
-
-	claude := &models.Anthropic{}
-	claude.ApiKey = chat.AnthropicApiKey
-
-	ollama := &models.Ollama{}
-	ollama.Url = chat.OllamaUrl
-
-	groq := &models.Groq{}
-	groq.ApiKey = chat.GroqApiKey
-
-	google := &models.Gemini{}
-	google.ApiKey = chat.GoogleApiKey
-
-	// create goroutines to list the models for each of the services. function is defined below
-	createGoroutines(&wg, openai, errorsChan, openaiModelsChan)
-
-	createGoroutines(&wg, claude, errorsChan, claudeModelsChan)
-
-	createGoroutines(&wg, ollama, errorsChan, ollamaModelsChan)
-
-	createGoroutines(&wg, groq, errorsChan, groqModelsChan)
+	modelsMap := make(map[string][]string, len(providers))
 
-	createGoroutines(&wg, google, errorsChan, googleModelsChan)
+	// create goroutines to list the models for each of the providers. function is defined below
+	for _, provider := range providers {
+		wg.Add(1)
+		createGoroutines(ctx, &wg, provider, chat, resultsChan)
+	}
 
 	wg.Wait() // Wait for all goroutines to finish
-	close(errorsChan)
+	close(resultsChan)
 
-	for err := range errorsChan {
-		errs = append(errs, err)
-	}
-	// get the models from the channels
-	openaiModels := <-openaiModelsChan
-	if openaiModels != nil {
-		modelsMap["openai"] = openaiModels
+	for result := range resultsChan {
+		if result.err != nil {
+			errs = append(errs, result.err)
+			continue
+		}
+		if result.models != nil {
+			modelsMap[result.provider] = result.models
+		}
 	}
+	return modelsMap, errs
+}
 
-	claudeModels := <-claudeModelsChan
-	if claudeModels != nil {
-		modelsMap["claude"] = claudeModels
+// SplitModelName splits a qualified name such as ollama/llama3 into the provider and the model. The provider is empty when the part before the first slash is not a registered provider, which keeps names like models/gemini-pro intact
+func SplitModelName(name string) (string, string) {
+	provider, model, found := strings.Cut(name, "/")
+	if !found {
+		return "", name
+	}
+	if _, ok := GetProvider(provider); !ok {
+		return "", name
 	}
+	return provider, model
+}
 
-	ollamaModels := <-ollamaModelsChan
-	if ollamaModels != nil {
-		modelsMap["ollama"] = ollamaModels
+// finds the provider that serves the model in the chat struct and returns it with the model name the provider expects. qualified names go straight to their provider. bare names are looked up in the listings first, then in the providers' matching rules
+func (chat Chat) findProvider(ctx context.Context) (Provider, string, error) {
+	if providerName, model := SplitModelName(chat.Model); providerName != "" {
+		provider, _ := GetProvider(providerName)
+		return provider, model, nil
+	}
+	modelsMap, _ := LoadModels(ctx, chat)
+	providers := Providers()
+	var found []Provider
+	for _, provider := range providers {
+		if utils.ExistsInArray(chat.Model, modelsMap[provider.Name].Models) {
+			found = append(found, provider)
+		}
 	}
+	if len(found) > 1 {
+		names := make([]string, 0, len(found))
+		for _, provider := range found {
+			names = append(names, provider.Name+"/"+chat.Model)
+		}
+		utils.LogWarning(fmt.Errorf("%s is offered by more than one provider, using %s. Choose one with %s", chat.Model, found[0].Name, strings.Join(names, " or ")))
+	}
+	if len(found) > 0 {
+		return found[0], chat.Model, nil
+	}
+	for _, provider := range providers {
+		if provider.Matches != nil && provider.Matches(chat.Model) {
+			return provider, chat.Model, nil
+		}
+	}
+	return Provider{}, "", ErrModelNotFound
+}
 
-	groqModels := <-groqModelsChan
-	if groqModels != nil {
-		modelsMap["groq"] = groqModels
+// this is the main function of the app. it takes a chat struct and sends the message to the model with the correct parameters, and returns the response with the provider and model that answered, the tokens it used and how long it took. when the model fails, the models in the fallback chain are tried in turn. when the chat has tools, the ones the model calls are run and their results sent back until it answers. when it has a schema, the reply is checked against it and the model asked again when it doesn't match. cancelling ctx aborts the request. when streaming, the response channel is closed before this returns
+func (chat Chat) SendMessageToModel(ctx context.Context) (Response, error) {
+	if len(chat.Schema) > 0 {
+		return chat.sendWithSchema(ctx)
 	}
+	return chat.sendMessage(ctx)
+}
 
-	googleModels := <-googleModelsChan
-	if googleModels != nil {
-		modelsMap["google"] = googleModels
+// sends the message, running the tools the model calls
+func (chat Chat) sendMessage(ctx context.Context) (Response, error) {
+	if len(chat.Tools) > 0 {
+		return chat.sendWithTools(ctx)
 	}
-	return modelsMap, errs
+	return chat.sendChain(ctx)
 }
 
-// this is the main function of the app. it takes a chat struct and sends the message to the model with the correct parameters
-func (chat Chat) SendMessageToModel() (string, error) {
-	stream := chat.Stream
-	var activeModel Model
-	modelsMap, _ := ListAllModels(chat)
-	openAiModels := modelsMap["openai"]
-	claudeModels := modelsMap["claude"]
-	ollamaModels := modelsMap["ollama"]
-	groqModels := modelsMap["groq"]
-	googleModels := modelsMap["google"]
-
-	// check if the model is in the list of available models, if so, create a new instance of the model. thi is how the app knows which api to use based on the users choice of model
-	if utils.ExistsInArray(chat.Model, openAiModels) {
-		activeModel = models.NewOpenai(chat.OpenAIApiKey, chat.Message, chat.Pattern, chat.Context, chat.Model, chat.Temperature, chat.TopP, chat.PresencePenalty, chat.FrequencyPenalty, chat.Session, chat.ResponseChan)
-	} else if utils.ExistsInArray(chat.Model, claudeModels) {
-		activeModel = models.NewClaude(chat.AnthropicApiKey, chat.Message, chat.Pattern, chat.Context, chat.Model, chat.Temperature, chat.TopP, chat.Session, chat.ResponseChan)
-	} else if utils.ExistsInArray(chat.Model, ollamaModels) {
-		activeModel = models.NewOllama(chat.OllamaUrl, chat.Message, chat.Pattern, chat.Context, chat.Model, chat.Temperature, chat.TopP, chat.PresencePenalty, chat.FrequencyPenalty, chat.Session, chat.ResponseChan)
-	} else if utils.ExistsInArray(chat.Model, groqModels) {
-		activeModel = models.NewGroq(chat.GroqApiKey, chat.Message, chat.Pattern, chat.Context, chat.Model, chat.Temperature, chat.TopP, chat.PresencePenalty, chat.FrequencyPenalty, chat.Session, chat.ResponseChan)
-	} else if utils.ExistsInArray(chat.Model, googleModels) {
-		activeModel = models.NewGemini(chat.GoogleApiKey, chat.Message, chat.Pattern, chat.Context, chat.Model, chat.Temperature, chat.TopP, chat.Session, chat.ResponseChan)
-	} else {
-		return "", errors.New("Model not found")
+// sends the message to the first model of the fallback chain that answers
+func (chat Chat) sendChain(ctx context.Context) (Response, error) {
+	if chat.Stream {
+		defer close(chat.ResponseChan)
 	}
-	if stream {
-		err := StreamMessage(activeModel)
-		if err != nil {
-			chat.ResponseChan <- err.Error()
+	models := chat.modelChain()
+	var failed []string
+	emitted := false
+	for i, name := range models {
+		attempt := chat
+		attempt.Model = name
+		response, err := attempt.sendToModel(ctx, &emitted)
+		if err == nil {
+			response.FallbackFrom = failed
+			if chat.Stream {
+				chat.ResponseChan <- StreamEvent{Done: true, Response: &response}
+			}
+			return response, nil
+		}
+		// a stream that already showed part of its answer can't be handed to another model
+		if i == len(models)-1 || emitted || !shouldFallback(ctx, err) {
+			return response, err
 		}
-		return "", nil
+		utils.LogWarning(fmt.Errorf("%s failed (%v), falling back to %s", name, err, models[i+1]))
+		failed = append(failed, name)
+	}
+	return Response{}, ErrModelNotFound
+}
 
+// sends the message to chat.Model. emitted is set once any text of a stream was sent to the response channel
+func (chat Chat) sendToModel(ctx context.Context, emitted *bool) (Response, error) {
+	// this is how the app knows which api to use based on the users choice of model
+	provider, model, err := chat.findProvider(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	chat.Model = model
+	if len(chat.Tools) > 0 && !provider.Tools {
+		utils.LogWarning(fmt.Errorf("%s can't call tools, so it is sent the message without them", provider.Name))
+		chat.Tools = nil
+	}
+	chat, err = chat.checkVision(provider.Name + "/" + model)
+	if err != nil {
+		return Response{}, err
+	}
+	// long sessions are trimmed or summarized so they fit in the model's context window
+	chat, err = chat.fitContextWindow(ctx, provider, model)
+	if err != nil {
+		return Response{}, err
+	}
+	start := time.Now()
+	var response Response
+	if chat.Stream {
+		response, err = chat.stream(ctx, provider, emitted)
 	} else {
-		return SendMessage(activeModel)
+		response, err = chat.withRetries(ctx, provider, func() (Response, error) {
+			ctx, cancel := context.WithTimeout(ctx, chat.timeout(provider))
+			defer cancel()
+			return SendMessage(ctx, provider.New(chat))
+		}, func() bool { return true })
+	}
+	response.Provider = provider.Name
+	if response.Model == "" {
+		response.Model = model
+	}
+	response.Latency = time.Since(start)
+	if err != nil {
+		return response, err
 	}
+	if response.Usage.IsZero() {
+		response.Usage = chat.estimateUsage(response.Text)
+	}
+	chat.recordUsage(response.QualifiedModel(), response.Usage)
+	return response, nil
+}
 
+// streams the response of the model to chat.ResponseChan, keeping a copy of the text for the response that is returned. a failed stream is only retried when none of its text was sent yet
+func (chat Chat) stream(ctx context.Context, provider Provider, emitted *bool) (Response, error) {
+	out := chat.ResponseChan
+	var text strings.Builder
+	return chat.withRetries(ctx, provider, func() (Response, error) {
+		ctx, cancel := context.WithTimeout(ctx, chat.timeout(provider))
+		defer cancel()
+		in := make(chan StreamEvent)
+		chat.ResponseChan = in
+		activeModel := provider.New(chat)
+		done := make(chan struct{})
+		go func() {
+			for event := range in {
+				if event.Text != "" {
+					*emitted = true
+				}
+				text.WriteString(event.Text)
+				out <- event
+			}
+			close(done)
+		}()
+		response, err := StreamMessage(ctx, activeModel)
+		close(in)
+		<-done
+		response.Text = text.String()
+		return response, err
+	}, func() bool { return !*emitted })
 }
 
-// helper fnction which creates goroutines to list the models for each of the services
-func createGoroutines(wg *sync.WaitGroup, model Model, errorsChan chan error, modelChan chan []string) {
+// helper fnction which creates goroutines to list the models for each of the providers
+func createGoroutines(ctx context.Context, wg *sync.WaitGroup, provider Provider, chat Chat, resultsChan chan listResult) {
 	go func() {
 		defer wg.Done()
-		models, err := ListModels(model)
-		if err != nil {
-			errorsChan <- err
-			//fmt.Println("Error listing models", reflect.TypeOf(model).Elem().Name())
-			modelChan <- nil
-		} else {
-			modelChan <- models
+		timeout := chat.timeout(provider)
+		if timeout > listTimeout {
+			timeout = listTimeout
 		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		models, err := ListModels(ctx, provider.New(chat))
+		resultsChan <- listResult{provider: provider.Name, models: models, err: err}
 	}()
 }
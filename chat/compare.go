@@ -0,0 +1,34 @@
+package chat
+
+import (
+	"context"
+	"sync"
+)
+
+// Comparison is the answer of one of the models a message was sent to with CompareModels
+type Comparison struct {
+	Model    string // model as it was asked for
+	Response Response
+	Err      error
+}
+
+// CompareModels sends the same chat to every model at once and returns their answers in the order of models. Streaming and fallbacks are turned off, so every answer comes from the model it is labelled with
+func CompareModels(ctx context.Context, chat Chat, models []string) []Comparison {
+	var wg sync.WaitGroup
+	results := make([]Comparison, len(models))
+	chat.Stream = false
+	chat.ResponseChan = nil
+	chat.NoFallback = true
+	for i, model := range models {
+		wg.Add(1)
+		go func(i int, model string) {
+			defer wg.Done()
+			modelChat := chat
+			modelChat.Model = model
+			response, err := modelChat.SendMessageToModel(ctx)
+			results[i] = Comparison{Model: model, Response: response, Err: err}
+		}(i, model)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xssdoctor/gofabric/utils"
+)
+
+// the model that makes embeddings can be changed in the .env file
+func init() {
+	RegisterSetting(ConfigKey{Name: "EMBEDDING_MODEL", Default: "openai/text-embedding-3-small"}) // model used by --embed, e.g. google/text-embedding-004 or ollama/nomic-embed-text:latest
+}
+
+// texts sent to the provider in one request. gemini takes at most 100 at once
+const embedBatchSize = 100
+
+// ErrNoEmbeddings is returned for a model whose provider can't make embeddings
+var ErrNoEmbeddings = errors.New("the provider can't make embeddings")
+
+// Embeddings are the vectors of a list of texts
+type Embeddings struct {
+	Vectors [][]float32
+	Model   string // provider and model that made them
+	Usage   Usage  // zero when the provider does not report it
+}
+
+// Embed returns the vectors of the texts from chat.Model, up to embedBatchSize texts per request. failed requests are retried like messages, and the usage is logged with them
+func (chat Chat) Embed(ctx context.Context, texts []string) (Embeddings, error) {
+	provider, model, err := chat.findProvider(ctx)
+	if err != nil {
+		return Embeddings{}, err
+	}
+	chat.Model = model
+	embedder, ok := provider.New(chat).(Embedder)
+	if !ok {
+		return Embeddings{}, fmt.Errorf("%s: %w", provider.Name, ErrNoEmbeddings)
+	}
+	result := Embeddings{Model: provider.Name + "/" + model}
+	for start := 0; start < len(texts); start += embedBatchSize {
+		group := texts[start:min(start+embedBatchSize, len(texts))]
+		var embeddings Embeddings
+		_, err := chat.withRetries(ctx, provider, func() (Response, error) {
+			ctx, cancel := context.WithTimeout(ctx, chat.timeout(provider))
+			defer cancel()
+			var err error
+			embeddings, err = embedder.Embed(ctx, group)
+			return Response{}, err
+		}, func() bool { return true })
+		if err != nil {
+			return result, err
+		}
+		if len(embeddings.Vectors) != len(group) {
+			return result, fmt.Errorf("%s returned %d embeddings for %d texts", result.Model, len(embeddings.Vectors), len(group))
+		}
+		usage := embeddings.Usage
+		if usage.IsZero() {
+			for _, text := range group {
+				usage.InputTokens += EstimateTokens(text)
+			}
+			usage.Estimated = true
+		}
+		addUsage(&result.Usage, usage)
+		result.Vectors = append(result.Vectors, embeddings.Vectors...)
+		if len(texts) > embedBatchSize {
+			utils.LogProgress(fmt.Sprintf("[%d/%d] embedded", len(result.Vectors), len(texts)))
+		}
+	}
+	chat.recordUsage(result.Model, result.Usage)
+	return result, nil
+}
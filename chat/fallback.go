@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/xssdoctor/gofabric/utils"
+)
+
+// ErrModelNotFound is returned when no provider serves the model
+var ErrModelNotFound = errors.New("Model not found")
+
+// the global fallback chain can be set in the .env file, e.g. FALLBACK_MODELS=openai/gpt-4o -> ollama/llama3:latest
+func init() {
+	RegisterSetting(ConfigKey{Name: "FALLBACK_MODELS"})
+}
+
+// ParseModelChain splits a chain of models such as "groq/llama3-70b -> openai/gpt-4o -> ollama/llama3". The models can also be separated by commas or new lines
+func ParseModelChain(chain string) []string {
+	fields := strings.FieldsFunc(strings.ReplaceAll(chain, "->", ","), func(r rune) bool {
+		return r == ',' || r == '\n'
+	})
+	models := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if model := strings.TrimSpace(field); model != "" {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// returns the chat's model followed by its fallbacks, each model once
+func (chat Chat) modelChain() []string {
+	if chat.NoFallback {
+		return []string{chat.Model}
+	}
+	fallbacks := chat.Fallbacks
+	if len(fallbacks) == 0 {
+		fallbacks = ParseModelChain(chat.ConfigValue("FALLBACK_MODELS"))
+	}
+	chain := []string{chat.Model}
+	for _, model := range fallbacks {
+		if !utils.ExistsInArray(model, chain) {
+			chain = append(chain, model)
+		}
+	}
+	return chain
+}
+
+// reports whether a failed request should be handed to the next model of the chain. that is the case for errors that were worth retrying, providers that can't be reached or took too long, models no provider serves and models that can't read the attached images. a request the user cancelled is never handed on
+func shouldFallback(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if retryable, _ := IsRetryable(err); retryable {
+		return true
+	}
+	if errors.Is(err, ErrModelNotFound) || errors.Is(err, ErrNoVision) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
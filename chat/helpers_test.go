@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// points HOME at an empty directory with a ~/.config/fabric, so the usage log and caches of a test are its own
+func testHome(t *testing.T) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".config/fabric"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// what the models of a stub provider do. a stub without reply answers with nothing, and one without list has no models
+type stub struct {
+	reply  func(chat Chat) (Response, error)
+	list   func() ([]string, error)
+	priced bool // the provider is priced by the price table. it is set when the provider is registered
+}
+
+// a model of a stub provider, so tests can script a conversation
+type stubModel struct {
+	chat Chat
+	stub stub
+}
+
+func (m stubModel) SendMessage(ctx context.Context) (Response, error) {
+	if m.stub.reply == nil {
+		return Response{}, nil
+	}
+	return m.stub.reply(m.chat)
+}
+
+func (m stubModel) StreamMessage(ctx context.Context) (Response, error) {
+	response, err := m.SendMessage(ctx)
+	if err == nil {
+		m.chat.ResponseChan <- StreamEvent{Text: response.Text}
+	}
+	return response, err
+}
+
+func (m stubModel) ListModels(ctx context.Context) ([]string, error) {
+	if m.stub.list == nil {
+		return nil, nil
+	}
+	return m.stub.list()
+}
+
+// the stubs of the stub providers, keyed by the name of the provider
+var stubs sync.Map
+
+// registers a provider whose models do what the stub says. a provider can only be registered once, so running the test again only swaps its stub
+func stubProvider(t *testing.T, name string, s stub) {
+	t.Helper()
+	stubs.Store(name, s)
+	if _, ok := GetProvider(name); ok {
+		return
+	}
+	Register(Provider{Name: name, Priced: s.priced, New: func(chat Chat) Model {
+		s, _ := stubs.Load(name)
+		return stubModel{chat: chat, stub: s.(stub)}
+	}})
+}
@@ -1,8 +1,15 @@
 package chat
 
-// this interfact allows any of the models to be passed to the chat instance
+import "context"
+
+// this interfact allows any of the models to be passed to the chat instance. the context cancels the request, e.g. when the user presses Ctrl+C or the timeout runs out. the usage in the response is zero when the provider does not report it. StreamMessage sends the text to the response channel as it arrives, so the text of the response it returns may be empty
 type Model interface {
-	SendMessage() (string, error)
-	StreamMessage() (error)
-	ListModels() ([]string, error)
-}
\ No newline at end of file
+	SendMessage(ctx context.Context) (Response, error)
+	StreamMessage(ctx context.Context) (Response, error)
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// Embedder is implemented by the models of providers that turn text into vectors, using the model they were created with. The vectors are in the order of the texts
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) (Embeddings, error)
+}
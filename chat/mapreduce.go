@@ -0,0 +1,302 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xssdoctor/gofabric/utils"
+)
+
+// the chunking settings can be changed in the .env file
+func init() {
+	RegisterSetting(ConfigKey{Name: "CHUNK_OVERLAP", Default: "200"})   // tokens repeated from the end of one chunk at the start of the next
+	RegisterSetting(ConfigKey{Name: "CHUNK_CONCURRENCY", Default: "4"}) // chunks sent at once
+	RegisterSetting(ConfigKey{Name: "REDUCE_PATTERN"})                  // pattern that merges the results of the chunks. blank uses a built in prompt
+}
+
+// tokens kept free in every chunk for the line that tells the model which part it is reading
+const chunkHeaderReserve = 32
+
+// prompt that merges the results of the chunks when no reduce pattern is given. the pattern of the chat is added after it
+const reducePrompt = `The results below were produced by running the same instructions on consecutive parts of one long input. The parts overlap a little, so some things appear in more than one result. Merge them into a single result for the whole input that follows the instructions and their output format exactly, without repeating anything and without mentioning the parts.`
+
+// SendChunked sends the message like SendMessageToModel, unless it is too large for the context window of the model. Then it is split into chunks on paragraph and sentence boundaries, the pattern is run on every chunk at once, and the results are merged with reducePattern, or with a built in prompt when that is empty. Progress is reported on stderr. When streaming, only the merged result is streamed
+func (chat Chat) SendChunked(ctx context.Context, reducePattern string) (Response, error) {
+	budget, err := chat.chunkBudget(ctx)
+	if err != nil || EstimateTokens(chat.Message) <= budget {
+		// let SendMessageToModel report a model that can't be found
+		return chat.SendMessageToModel(ctx)
+	}
+	overlap := chat.intSetting("CHUNK_OVERLAP", 200)
+	if overlap > budget/4 {
+		overlap = budget / 4
+	}
+	chunks := SplitText(chat.Message, budget, overlap)
+	utils.LogProgress(fmt.Sprintf("the input has about %d tokens, more than the %d that fit in %s, so it is sent in %d chunks", EstimateTokens(chat.Message), budget, chat.Model, len(chunks)))
+
+	start := time.Now()
+	var usage Usage
+	messages := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		messages[i] = fmt.Sprintf("This is part %d of %d of a longer input.\n\n%s", i+1, len(chunks), chunk)
+	}
+	part := chat
+	part.Session = nil
+	part.Attachments = nil
+	// only the merged result has to match the schema
+	part.Schema = nil
+	results, err := part.sendAll(ctx, messages, "chunk", &usage)
+	for err == nil && len(results) > 1 && EstimateTokens(joinResults(results)) > budget {
+		// the results don't fit in one request either, so they are merged in groups first
+		groups := SplitText(joinResults(results), budget, 0)
+		if len(groups) >= len(results) {
+			break
+		}
+		utils.LogProgress(fmt.Sprintf("merging the %d results in %d groups", len(results), len(groups)))
+		results, err = chat.mergeGroups(ctx, groups, reducePattern, &usage)
+	}
+	if err != nil {
+		if chat.Stream {
+			close(chat.ResponseChan)
+		}
+		return Response{}, err
+	}
+
+	utils.LogProgress(fmt.Sprintf("merging the results of %d chunks", len(chunks)))
+	reduce := chat.reduceChat(joinResults(results), reducePattern)
+	response, err := reduce.SendMessageToModel(ctx)
+	if err != nil {
+		return response, err
+	}
+	// the response covers every request that went into it
+	addUsage(&usage, response.Usage)
+	response.Usage = usage
+	response.Latency = time.Since(start)
+	return response, nil
+}
+
+// tokens of the message that fit in a request to the chat's model, after the pattern, the context and the reply
+func (chat Chat) chunkBudget(ctx context.Context) (int, error) {
+	provider, model, err := chat.findProvider(ctx)
+	if err != nil {
+		return 0, err
+	}
+	budget := inputBudget(chat.ContextWindow(provider.Name+"/"+model)) - EstimateTokens(chat.Context+chat.Pattern) - chunkHeaderReserve
+	// a pattern that fills the window on its own leaves no room for chunks. the provider will say so
+	if budget < 256 {
+		budget = 256
+	}
+	return budget, nil
+}
+
+// returns a setting that is a number of at least 0, or def when it isn't
+func (chat Chat) intSetting(name string, def int) int {
+	value, err := strconv.Atoi(chat.ConfigValue(name))
+	if err != nil || value < 0 {
+		return def
+	}
+	return value
+}
+
+// sends every message with the chat's pattern, CHUNK_CONCURRENCY at a time, and returns the answers in the order of the messages. what names the messages in the progress. the usage of every request is added to usage
+func (chat Chat) sendAll(ctx context.Context, messages []string, what string, usage *Usage) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	concurrency := chat.intSetting("CHUNK_CONCURRENCY", 4)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		done     int
+	)
+	results := make([]string, len(messages))
+	slots := make(chan struct{}, concurrency)
+	for i, message := range messages {
+		wg.Add(1)
+		go func(i int, message string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			if ctx.Err() != nil {
+				return
+			}
+			request := chat
+			request.Message = message
+			request.Stream = false
+			request.ResponseChan = nil
+			response, err := request.SendMessageToModel(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s %d of %d failed: %w", what, i+1, len(messages), err)
+					// the other answers are of no use without this one
+					cancel()
+				}
+				return
+			}
+			results[i] = response.Text
+			addUsage(usage, response.Usage)
+			done++
+			utils.LogProgress(fmt.Sprintf("%s %d/%d done", what, done, len(messages)))
+		}(i, message)
+	}
+	wg.Wait()
+	return results, firstErr
+}
+
+// merges every group of results into one, so fewer results are left to merge
+func (chat Chat) mergeGroups(ctx context.Context, groups []string, reducePattern string, usage *Usage) ([]string, error) {
+	merger := chat.reduceChat("", reducePattern)
+	merger.Session = nil
+	merger.Attachments = nil
+	merger.Schema = nil
+	return merger.sendAll(ctx, groups, "group", usage)
+}
+
+// builds the chat that merges the results. a reduce pattern replaces the chat's pattern. the built in prompt keeps it, so the merged result follows it
+func (chat Chat) reduceChat(results string, reducePattern string) Chat {
+	reduce := chat
+	reduce.Message = results
+	if reducePattern != "" {
+		reduce.Pattern = reducePattern
+	} else if chat.Pattern != "" {
+		reduce.Pattern = reducePrompt + "\n\nINSTRUCTIONS:\n" + chat.Pattern
+	} else {
+		reduce.Pattern = reducePrompt
+	}
+	return reduce
+}
+
+func addUsage(total *Usage, usage Usage) {
+	total.InputTokens += usage.InputTokens
+	total.OutputTokens += usage.OutputTokens
+	total.Estimated = total.Estimated || usage.Estimated
+}
+
+func joinResults(results []string) string {
+	var builder strings.Builder
+	for i, result := range results {
+		fmt.Fprintf(&builder, "RESULT %d:\n%s\n\n", i+1, strings.TrimSpace(result))
+	}
+	return builder.String()
+}
+
+// SplitText splits text into chunks of at most maxTokens estimated tokens. It splits between paragraphs, then between sentences, and only cuts through a sentence that is too long on its own. Every chunk after the first starts with about overlapTokens tokens from the end of the one before it, so nothing is lost at the seams
+func SplitText(text string, maxTokens int, overlapTokens int) []string {
+	if maxTokens < 1 {
+		maxTokens = 1
+	}
+	var units []string
+	for _, paragraph := range splitKeep(text, "\n\n") {
+		if EstimateTokens(paragraph) <= maxTokens {
+			units = append(units, paragraph)
+			continue
+		}
+		for _, sentence := range splitSentences(paragraph) {
+			if EstimateTokens(sentence) <= maxTokens {
+				units = append(units, sentence)
+				continue
+			}
+			units = append(units, splitHard(sentence, maxTokens)...)
+		}
+	}
+
+	var chunks []string
+	var current []string
+	tokens := 0
+	for _, unit := range units {
+		unitTokens := EstimateTokens(unit)
+		if tokens+unitTokens > maxTokens && len(current) > 0 {
+			chunks = append(chunks, strings.Join(current, ""))
+			// start the next chunk with the last units of this one
+			var carried []string
+			carriedTokens := 0
+			for i := len(current) - 1; i >= 0; i-- {
+				t := EstimateTokens(current[i])
+				if carriedTokens+t > overlapTokens || carriedTokens+t+unitTokens > maxTokens {
+					break
+				}
+				carried = append([]string{current[i]}, carried...)
+				carriedTokens += t
+			}
+			current, tokens = carried, carriedTokens
+		}
+		current = append(current, unit)
+		tokens += unitTokens
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, strings.Join(current, ""))
+	}
+	return chunks
+}
+
+// splits text after every separator, keeping the separators so the pieces join back into the text
+func splitKeep(text string, separator string) []string {
+	var pieces []string
+	for {
+		i := strings.Index(text, separator)
+		if i < 0 {
+			break
+		}
+		pieces = append(pieces, text[:i+len(separator)])
+		text = text[i+len(separator):]
+	}
+	if text != "" {
+		pieces = append(pieces, text)
+	}
+	return pieces
+}
+
+// splits text after the end of every sentence, keeping the whitespace that follows it
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	for i := 0; i < len(text)-1; i++ {
+		if !strings.ContainsRune(".!?\n", rune(text[i])) || !strings.ContainsRune(" \t\n", rune(text[i+1])) {
+			continue
+		}
+		end := i + 1
+		for end < len(text) && strings.ContainsRune(" \t\n", rune(text[end])) {
+			end++
+		}
+		sentences = append(sentences, text[start:end])
+		start, i = end, end-1
+	}
+	if start < len(text) {
+		sentences = append(sentences, text[start:])
+	}
+	return sentences
+}
+
+// cuts text that has no sentence boundaries into pieces of maxTokens, at a space when there is one
+func splitHard(text string, maxTokens int) []string {
+	size := maxTokens * 4
+	var pieces []string
+	for len(text) > size {
+		cut := strings.LastIndexAny(text[:size], " \t\n")
+		if cut <= 0 {
+			cut = size
+			// don't cut through a multi-byte character
+			for cut > 0 && !utf8RuneStart(text[cut]) {
+				cut--
+			}
+		} else {
+			cut++
+		}
+		pieces = append(pieces, text[:cut])
+		text = text[cut:]
+	}
+	return append(pieces, text)
+}
+
+func utf8RuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
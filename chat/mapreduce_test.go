@@ -0,0 +1,219 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+// a paragraph of about tokens tokens, made of short sentences
+func testParagraph(name string, tokens int) string {
+	var paragraph strings.Builder
+	for i := 0; ; i++ {
+		sentence := fmt.Sprintf("%s says %d. ", name, i)
+		if paragraph.Len()+len(sentence) > tokens*4 {
+			break
+		}
+		paragraph.WriteString(sentence)
+	}
+	return strings.TrimSpace(paragraph.String())
+}
+
+func TestSplitTextParagraphs(t *testing.T) {
+	paragraphs := []string{testParagraph("one", 10), testParagraph("two", 10), testParagraph("three", 10)}
+	text := strings.Join(paragraphs, "\n\n")
+	chunks := SplitText(text, 25, 0)
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks, want 2: %q", len(chunks), chunks)
+	}
+	if chunks[0] != paragraphs[0]+"\n\n"+paragraphs[1]+"\n\n" || chunks[1] != paragraphs[2] {
+		t.Errorf("the chunks are %q, want two paragraphs and then one", chunks)
+	}
+	if strings.Join(chunks, "") != text {
+		t.Error("without overlap the chunks don't join back into the text")
+	}
+	if chunks := SplitText(text, 1000, 200); len(chunks) != 1 || chunks[0] != text {
+		t.Errorf("text that fits is split into %q", chunks)
+	}
+}
+
+func TestSplitTextSentences(t *testing.T) {
+	paragraph := testParagraph("one", 100)
+	chunks := SplitText(paragraph, 20, 0)
+	if len(chunks) < 5 {
+		t.Fatalf("got %d chunks, want the paragraph split into at least 5", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if EstimateTokens(chunk) > 20 {
+			t.Errorf("chunk %d has %d tokens, more than 20", i, EstimateTokens(chunk))
+		}
+		if i < len(chunks)-1 && !strings.HasSuffix(chunk, ". ") {
+			t.Errorf("chunk %d %q doesn't end with a sentence", i, chunk)
+		}
+	}
+	if strings.Join(chunks, "") != paragraph {
+		t.Error("the chunks don't join back into the paragraph")
+	}
+}
+
+func TestSplitTextHardCuts(t *testing.T) {
+	// no sentence ends, so it is cut at spaces
+	words := strings.Repeat("word ", 40)
+	chunks := SplitText(words, 10, 0)
+	for i, chunk := range chunks {
+		if EstimateTokens(chunk) > 10 || !strings.HasSuffix(chunk, " ") {
+			t.Errorf("chunk %d is %q, want at most 10 tokens that end at a space", i, chunk)
+		}
+	}
+	if strings.Join(chunks, "") != words {
+		t.Error("the chunks of the words don't join back into them")
+	}
+
+	// no spaces either, so it is cut anywhere but through a character
+	accents := strings.Repeat("é", 100)
+	chunks = SplitText(accents, 10, 0)
+	if len(chunks) < 5 {
+		t.Fatalf("got %d chunks, want the text cut into at least 5", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if !utf8.ValidString(chunk) || EstimateTokens(chunk) > 10 {
+			t.Errorf("chunk %d is %q, want at most 10 tokens of whole characters", i, chunk)
+		}
+	}
+	if strings.Join(chunks, "") != accents {
+		t.Error("the chunks of the accents don't join back into them")
+	}
+}
+
+func TestSplitTextOverlap(t *testing.T) {
+	paragraphs := []string{testParagraph("one", 10), testParagraph("two", 10), testParagraph("three", 10), testParagraph("four", 10)}
+	chunks := SplitText(strings.Join(paragraphs, "\n\n"), 25, 12)
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3: %q", len(chunks), chunks)
+	}
+	// every chunk after the first starts with the last paragraph of the one before it
+	for i, first := range []string{paragraphs[1], paragraphs[2]} {
+		if !strings.HasPrefix(chunks[i+1], first) {
+			t.Errorf("chunk %d is %q, want it to start with %q", i+1, chunks[i+1], first)
+		}
+	}
+	for i, chunk := range chunks {
+		if EstimateTokens(chunk) > 25 {
+			t.Errorf("chunk %d has %d tokens, more than 25", i, EstimateTokens(chunk))
+		}
+	}
+}
+
+// the requests a chunked chat made, and what the stub answers them with. the chunks are sent at the same time, so they are kept by their number
+type chunkedRequests struct {
+	mu     sync.Mutex
+	parts  map[int]Chat
+	reduce []Chat
+}
+
+func (r *chunkedRequests) reply(chat Chat) (Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	usage := Usage{InputTokens: 100, OutputTokens: 10}
+	var part int
+	if _, err := fmt.Sscanf(chat.Message, "This is part %d ", &part); err == nil {
+		r.parts[part] = chat
+		return Response{Text: fmt.Sprintf("result of part %d", part), Usage: usage}, nil
+	}
+	r.reduce = append(r.reduce, chat)
+	return Response{Text: "merged", Usage: usage}, nil
+}
+
+func chunkedChat(message string) Chat {
+	return Chat{
+		Model:   "stubchunk/m",
+		Pattern: "summarize",
+		Message: message,
+		// an input budget of 500 tokens, of which the pattern and the header of a chunk leave 465 for the chunk
+		Config: map[string]string{"CONTEXT_BUDGETS": "stubchunk/m=1000"},
+	}
+}
+
+func TestSendChunked(t *testing.T) {
+	testHome(t)
+	var paragraphs []string
+	for i := 0; i < 4; i++ {
+		paragraphs = append(paragraphs, testParagraph(fmt.Sprintf("p%d", i), 300))
+	}
+	message := strings.Join(paragraphs, "\n\n")
+
+	for _, test := range []struct {
+		reducePattern string
+		pattern       string
+	}{
+		{"", reducePrompt + "\n\nINSTRUCTIONS:\nsummarize"},
+		{"merge these", "merge these"},
+	} {
+		requests := &chunkedRequests{parts: make(map[int]Chat)}
+		stubProvider(t, "stubchunk", stub{reply: requests.reply})
+		response, err := chunkedChat(message).SendChunked(context.Background(), test.reducePattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// each paragraph fits in a chunk, two don't
+		if len(requests.parts) != 4 {
+			t.Fatalf("the input was sent in %d chunks, want 4", len(requests.parts))
+		}
+		for i := range paragraphs {
+			part := requests.parts[i+1]
+			header := fmt.Sprintf("This is part %d of 4 of a longer input.\n\n", i+1)
+			if !strings.HasPrefix(part.Message, header) || !strings.Contains(part.Message, paragraphs[i]) || part.Pattern != "summarize" {
+				t.Errorf("chunk %d was sent %.60q with the pattern %q", i+1, part.Message, part.Pattern)
+			}
+			if tokens := EstimateTokens(strings.TrimPrefix(part.Message, header)); tokens > 465 {
+				t.Errorf("chunk %d has %d tokens, more than the 465 that fit", i+1, tokens)
+			}
+		}
+		if len(requests.reduce) != 1 {
+			t.Fatalf("the results were merged in %d requests, want 1", len(requests.reduce))
+		}
+		reduce := requests.reduce[0]
+		if reduce.Pattern != test.pattern {
+			t.Errorf("the results were merged with the pattern %q, want %q", reduce.Pattern, test.pattern)
+		}
+		if want := "RESULT 1:\nresult of part 1\n\nRESULT 2:\nresult of part 2\n\nRESULT 3:\nresult of part 3\n\nRESULT 4:\nresult of part 4\n\n"; reduce.Message != want {
+			t.Errorf("the results were merged from %q, want %q", reduce.Message, want)
+		}
+		if response.Text != "merged" || response.Usage != (Usage{InputTokens: 500, OutputTokens: 50}) {
+			t.Errorf("the response is %+v, want the merged result with the usage of all 5 requests", response)
+		}
+	}
+}
+
+func TestSendChunkedSmallInput(t *testing.T) {
+	testHome(t)
+	requests := &chunkedRequests{parts: make(map[int]Chat)}
+	stubProvider(t, "stubchunk", stub{reply: requests.reply})
+	response, err := chunkedChat("a short input").SendChunked(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests.parts) != 0 || len(requests.reduce) != 1 || requests.reduce[0].Message != "a short input" || requests.reduce[0].Pattern != "summarize" {
+		t.Errorf("an input that fits was sent as %+v and %+v, want it sent once as it is", requests.parts, requests.reduce)
+	}
+	if response.Text != "merged" {
+		t.Errorf("the response is %+v", response)
+	}
+}
+
+func TestSendChunkedFailure(t *testing.T) {
+	testHome(t)
+	stubProvider(t, "stubchunk", stub{reply: func(chat Chat) (Response, error) {
+		if strings.HasPrefix(chat.Message, "This is part 2 ") {
+			return Response{}, fmt.Errorf("the chunk is too long")
+		}
+		return Response{Text: "ok"}, nil
+	}})
+	_, err := chunkedChat(testParagraph("p", 300)+"\n\n"+testParagraph("q", 300)).SendChunked(context.Background(), "")
+	if err == nil || err.Error() != "chunk 2 of 2 failed: the chunk is too long" {
+		t.Errorf("the error is %v, want the failure of chunk 2", err)
+	}
+}
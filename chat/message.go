@@ -0,0 +1,48 @@
+package chat
+
+import "time"
+
+// roles used in a conversation
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool" // the results of the tool calls of the assistant message before it
+)
+
+// Message is one turn of a conversation. A session is a list of these, and every model replays it before the new message
+type Message struct {
+	Role        string       `json:"role"`
+	Content     string       `json:"content"`
+	Timestamp   time.Time    `json:"timestamp"`         // zero for messages saved before timestamps were recorded
+	Model       string       `json:"model,omitempty"`   // model that wrote the reply
+	Pattern     string       `json:"pattern,omitempty"` // name of the pattern the turn was sent with
+	Usage       *Usage       `json:"usage,omitempty"`   // tokens used by the turn, when the provider reports them
+	Attachments []Attachment `json:"attachments,omitempty"`
+	ToolCalls   []ToolCall   `json:"tool_calls,omitempty"`   // tools the assistant asked to call
+	ToolResults []ToolResult `json:"tool_results,omitempty"` // results of the calls, in a message with the tool role
+}
+
+// Usage is the number of tokens a request used
+type Usage struct {
+	InputTokens  int  `json:"input_tokens"`
+	OutputTokens int  `json:"output_tokens"`
+	Estimated    bool `json:"estimated,omitempty"` // the provider did not report the usage, so it was estimated from the text
+}
+
+// IsZero reports whether no tokens were counted
+func (u Usage) IsZero() bool {
+	return u.InputTokens == 0 && u.OutputTokens == 0
+}
+
+// TotalTokens returns the input and output tokens together
+func (u Usage) TotalTokens() int {
+	return u.InputTokens + u.OutputTokens
+}
+
+// Attachment is a file sent along with a message, such as an image
+type Attachment struct {
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Data     []byte `json:"data"` // base64 encoded in the session file
+}
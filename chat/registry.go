@@ -0,0 +1,140 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeout used for a provider that does not set its own
+const DefaultTimeout = 5 * time.Minute
+
+// Provider describes a vendor that can serve models. Each vendor in the models package registers one of these in an init function, so adding a vendor only needs a new file in models
+type Provider struct {
+	Name    string                  // name of the vendor. this is the key used in the list of models
+	Keys    []ConfigKey             // configuration keys the vendor needs from ~/.config/fabric/.env
+	Matches func(model string) bool // optional rule used to claim a model that does not show up in any of the listings
+	New     func(chat Chat) Model   // builds a model from the chat struct
+	Timeout time.Duration           // default time a request may take. it can be changed with <NAME>_TIMEOUT in the .env file
+	Tools   bool                    // the vendor's models can call tools
+	Priced  bool                    // the vendor bills by the token at the prices of its own models, so its models are priced by the names in the price table. models of other vendors only have the prices prices.json gives them by their qualified name
+}
+
+// KeyPrefix returns the prefix of the .env keys of a provider, e.g. OFFICE_VLLM for office-vllm. Names can hold a -, which can't be part of a .env key, so it becomes a _
+func KeyPrefix(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// name of the .env key that overrides the provider's timeout, e.g. OLLAMA_TIMEOUT
+func (p Provider) timeoutKey() ConfigKey {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return ConfigKey{Name: KeyPrefix(p.Name) + "_TIMEOUT", Default: timeout.String()}
+}
+
+// ConfigKey is a single value in ~/.config/fabric/.env that a provider needs, such as an api key or a url
+type ConfigKey struct {
+	Name    string // name of the key in the .env file
+	Prompt  string // shown to the user by the setup. keys without a prompt are only read from the .env file
+	Default string // used when the user leaves the value blank
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   []Provider
+	settings    []ConfigKey // keys that are not tied to a provider, such as MODEL_CACHE_TTL
+)
+
+// Register adds a provider to the registry. It panics if a provider with the same name is already registered
+func Register(provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if provider.New == nil {
+		panic("chat: Register provider " + provider.Name + " has no factory")
+	}
+	for _, p := range providers {
+		if p.Name == provider.Name {
+			panic(fmt.Sprintf("chat: Register called twice for provider %s", provider.Name))
+		}
+	}
+	providers = append(providers, provider)
+}
+
+// Providers returns every registered provider in the order they were registered
+func Providers() []Provider {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	list := make([]Provider, len(providers))
+	copy(list, providers)
+	return list
+}
+
+// GetProvider returns the provider with the given name
+func GetProvider(name string) (Provider, bool) {
+	for _, p := range Providers() {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Provider{}, false
+}
+
+// RegisterSetting adds a configuration key that is not tied to a provider
+func RegisterSetting(key ConfigKey) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	settings = append(settings, key)
+}
+
+// ConfigKeys returns the configuration keys of every registered provider followed by the settings. this is what the setup asks for and what the .env file contains
+func ConfigKeys() []ConfigKey {
+	var keys []ConfigKey
+	seen := make(map[string]bool)
+	for _, p := range Providers() {
+		for _, key := range append(p.Keys, p.timeoutKey(), p.retriesKey()) {
+			if seen[key.Name] {
+				continue
+			}
+			seen[key.Name] = true
+			keys = append(keys, key)
+		}
+	}
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	for _, key := range settings {
+		if seen[key.Name] {
+			continue
+		}
+		seen[key.Name] = true
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// returns how long a request to the provider may take. the --timeout flag wins over the provider's setting
+func (chat Chat) timeout(provider Provider) time.Duration {
+	if chat.Timeout > 0 {
+		return chat.Timeout
+	}
+	timeout, err := time.ParseDuration(chat.ConfigValue(provider.timeoutKey().Name))
+	if err != nil || timeout <= 0 {
+		return DefaultTimeout
+	}
+	return timeout
+}
+
+// ConfigValue returns the value of a configuration key, falling back to the key's default when it is blank
+func (chat Chat) ConfigValue(name string) string {
+	if value := chat.Config[name]; value != "" {
+		return value
+	}
+	for _, key := range ConfigKeys() {
+		if key.Name == name {
+			return key.Default
+		}
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package chat
+
+import "time"
+
+// reasons a model stops generating. every provider's reason is mapped to one of these
+const (
+	FinishStop          = "stop"           // the model finished its answer
+	FinishLength        = "length"         // the answer was cut off at the token limit
+	FinishContentFilter = "content_filter" // the provider blocked the prompt or the answer
+	FinishToolCalls     = "tool_calls"     // the model wants to call a tool
+)
+
+// Response is what a model sends back for a message
+type Response struct {
+	Text         string
+	FinishReason string // one of the Finish constants, or the provider's own reason when it has no equivalent
+	Refusal      string // why the provider refused to answer, when it did
+	Usage        Usage
+	Provider     string        // set by the chat package
+	Model        string        // model that answered, as reported by the provider. this can be more specific than the requested model
+	RequestID    string        // id the provider gave the request, useful when reporting a problem to them
+	Latency      time.Duration // time from sending the request to the end of the response. set by the chat package
+	FallbackFrom []string      // models of the fallback chain that failed before this one answered
+	ToolCalls    []ToolCall    // tools the model asked to call. the chat package runs them, so a response that is returned has none
+}
+
+// Truncated reports whether the answer was cut off before the model finished it
+func (r Response) Truncated() bool {
+	return r.FinishReason == FinishLength
+}
+
+// QualifiedModel returns the model that answered with its provider, e.g. openai/gpt-4o-2024-05-13
+func (r Response) QualifiedModel() string {
+	if r.Provider == "" {
+		return r.Model
+	}
+	return r.Provider + "/" + r.Model
+}
+
+// StreamEvent is one piece of a streamed response. The last event has Done set and carries the complete response
+type StreamEvent struct {
+	Text     string
+	Done     bool
+	Response *Response
+}
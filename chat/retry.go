@@ -0,0 +1,113 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/xssdoctor/gofabric/utils"
+)
+
+// number of times a failed request is retried for a provider that does not set its own
+const DefaultMaxRetries = 3
+
+// first wait between attempts. it doubles with every attempt
+const retryBaseDelay = time.Second
+
+// longest wait between attempts, unless the provider asks for more with Retry-After
+const retryMaxDelay = 30 * time.Second
+
+// a provider that wants us to wait longer than this is not retried, the error is returned instead
+const retryAfterLimit = 2 * time.Minute
+
+// StatusError is an error from a provider's api together with the http status of the response. The models wrap their errors in it so the chat package can tell which ones are worth retrying
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // how long the provider asked us to wait, from Retry-After or its rate limit headers. 0 when it didn't say
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// name of the .env key that sets how many times a provider's requests are retried, e.g. OPENAI_MAX_RETRIES
+func (p Provider) retriesKey() ConfigKey {
+	return ConfigKey{Name: KeyPrefix(p.Name) + "_MAX_RETRIES", Default: strconv.Itoa(DefaultMaxRetries)}
+}
+
+// returns how many times a request to the provider may be retried
+func (chat Chat) maxRetries(provider Provider) int {
+	retries, err := strconv.Atoi(chat.ConfigValue(provider.retriesKey().Name))
+	if err != nil || retries < 0 {
+		return DefaultMaxRetries
+	}
+	return retries
+}
+
+// IsRetryable reports whether the request that failed with err may succeed if it is sent again, and how long the provider asked us to wait first. Rate limits, server errors and dropped connections are retried. Cancelled requests, timeouts and errors in the request itself are not
+func IsRetryable(err error) (bool, time.Duration) {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case 408, 409, 429, 500, 502, 503, 504, 529: // 529 is anthropic's overloaded status
+			return true, statusErr.RetryAfter
+		}
+		return false, 0
+	}
+	// the connection dropped in the middle of the request. a refused connection means the server is down, which retrying won't fix
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true, 0
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true, 0
+	}
+	return false, 0
+}
+
+// returns how long to wait before the given retry, counting from 1. the wait doubles with every retry and is randomized so many clients don't retry at once. the provider's Retry-After wins when it is longer
+func retryDelay(retry int, retryAfter time.Duration) time.Duration {
+	backoff := retryBaseDelay << (retry - 1)
+	if backoff > retryMaxDelay || backoff <= 0 {
+		backoff = retryMaxDelay
+	}
+	// full jitter: anywhere between half the backoff and all of it
+	delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// calls attempt until it succeeds, fails with an error that is not retryable, or the provider's retries run out. canRetry is asked before every retry, so a stream that already sent text is not started again
+func (chat Chat) withRetries(ctx context.Context, provider Provider, attempt func() (Response, error), canRetry func() bool) (Response, error) {
+	maxRetries := chat.maxRetries(provider)
+	for retry := 1; ; retry++ {
+		response, err := attempt()
+		retryable, retryAfter := IsRetryable(err)
+		if !retryable || retry > maxRetries || !canRetry() || retryAfter > retryAfterLimit {
+			return response, err
+		}
+		delay := retryDelay(retry, retryAfter)
+		utils.LogWarning(fmt.Errorf("%s failed (%v), retrying in %s (%d/%d)", provider.Name, err, delay.Round(100*time.Millisecond), retry, maxRetries))
+		select {
+		case <-ctx.Done():
+			return response, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	for _, test := range []struct {
+		retry      int
+		retryAfter time.Duration
+		min        time.Duration
+		max        time.Duration
+	}{
+		{1, 0, retryBaseDelay / 2, retryBaseDelay},
+		{3, 0, 2 * retryBaseDelay, 4 * retryBaseDelay},
+		// the backoff stops doubling at the cap, even when the shift overflows
+		{10, 0, retryMaxDelay / 2, retryMaxDelay},
+		{100, 0, retryMaxDelay / 2, retryMaxDelay},
+		// a longer Retry-After wins, even over the cap
+		{1, 45 * time.Second, 45 * time.Second, 45 * time.Second},
+		{2, time.Millisecond, retryBaseDelay, 2 * retryBaseDelay},
+	} {
+		for i := 0; i < 20; i++ {
+			if got := retryDelay(test.retry, test.retryAfter); got < test.min || got > test.max {
+				t.Errorf("retryDelay(%d, %v) = %v, want between %v and %v", test.retry, test.retryAfter, got, test.min, test.max)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// times the model is asked for json matching the schema before giving up
+const maxSchemaAttempts = 3
+
+// problems with a reply beyond this many are left out of the request to fix it
+const maxSchemaProblems = 10
+
+// ParseSchema reads a json schema, from a pattern's schema.json or the file given with --json-schema, and returns it compacted
+func ParseSchema(contents []byte) (json.RawMessage, error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(contents, &schema); err != nil {
+		return nil, fmt.Errorf("could not read the json schema: %v", err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, contents); err != nil {
+		return nil, err
+	}
+	return compact.Bytes(), nil
+}
+
+// the instructions added to the pattern so that every model, including those without a json mode, knows what to answer with
+func schemaInstructions(schema json.RawMessage) string {
+	return "\n\nOUTPUT FORMAT:\nReply with only a JSON value that matches this JSON schema, with no other text and no code fences:\n" + string(schema)
+}
+
+// sends the message and checks that the reply is json matching chat.Schema. a reply that doesn't match is sent back to the model with what is wrong with it, up to maxSchemaAttempts times. the reply is returned indented. like the tool rounds, the attempts are not streamed, and every attempt goes to the model that answered the first
+func (chat Chat) sendWithSchema(ctx context.Context) (Response, error) {
+	out := chat.ResponseChan
+	if chat.Stream {
+		defer close(out)
+	}
+	var schema interface{}
+	if err := json.Unmarshal(chat.Schema, &schema); err != nil {
+		return Response{}, fmt.Errorf("could not read the json schema: %v", err)
+	}
+	request := chat
+	request.Stream = false
+	request.ResponseChan = nil
+	request.Pattern += schemaInstructions(chat.Schema)
+	start := time.Now()
+	var usage Usage
+	var fallbackFrom []string
+	for attempt := 1; ; attempt++ {
+		response, err := request.sendMessage(ctx)
+		addUsage(&usage, response.Usage)
+		if err != nil {
+			return response, err
+		}
+		if attempt == 1 {
+			fallbackFrom = response.FallbackFrom
+		}
+		value, problems := checkJSON(response.Text, schema)
+		if len(problems) == 0 {
+			response.Text = value
+			response.Usage = usage
+			response.Latency = time.Since(start)
+			response.FallbackFrom = fallbackFrom
+			if chat.Stream {
+				// like the streams of the models, the text ends with a newline
+				out <- StreamEvent{Text: response.Text + "\n"}
+				out <- StreamEvent{Done: true, Response: &response}
+			}
+			return response, nil
+		}
+		if attempt == maxSchemaAttempts {
+			return response, fmt.Errorf("the model did not reply with json matching the schema after %d tries: %s", maxSchemaAttempts, strings.Join(problems, "; "))
+		}
+		if attempt == 1 {
+			request.Model = request.modelChain()[len(fallbackFrom)]
+			request.NoFallback = true
+			request.Session = append([]Message{}, chat.Session...)
+		}
+		request.Session = append(request.Session,
+			Message{Role: RoleUser, Content: request.Message, Attachments: request.Attachments, Timestamp: time.Now()},
+			Message{Role: RoleAssistant, Content: response.Text, Model: response.QualifiedModel(), Timestamp: time.Now()},
+		)
+		request.Message = "That reply does not match the JSON schema:\n- " + strings.Join(problems, "\n- ") + "\n\nReply again with only the corrected JSON."
+		request.Attachments = nil
+	}
+}
+
+// reads the json in a reply and checks it against the schema. models often wrap json in a code fence or a sentence, so the json is looked for inside the text. it returns the json indented, or what is wrong with it
+func checkJSON(text string, schema interface{}) (string, []string) {
+	raw := extractJSON(text)
+	if raw == "" {
+		return "", []string{"the reply is not JSON"}
+	}
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", []string{"the reply is not valid JSON: " + err.Error()}
+	}
+	validator := schemaValidator{root: schema}
+	validator.check(schema, value, "$")
+	if len(validator.problems) > maxSchemaProblems {
+		validator.problems = append(validator.problems[:maxSchemaProblems], fmt.Sprintf("and %d more", len(validator.problems)-maxSchemaProblems))
+	}
+	if len(validator.problems) > 0 {
+		return "", validator.problems
+	}
+	var indented bytes.Buffer
+	json.Indent(&indented, []byte(raw), "", "  ")
+	return indented.String(), nil
+}
+
+var codeFence = regexp.MustCompile("(?s)```[a-zA-Z]*\\s*\n(.*?)\n\\s*```")
+
+// returns the json value in the text, or "" when there is none
+func extractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	if json.Valid([]byte(text)) {
+		return text
+	}
+	if match := codeFence.FindStringSubmatch(text); match != nil && json.Valid([]byte(strings.TrimSpace(match[1]))) {
+		return strings.TrimSpace(match[1])
+	}
+	// the outermost object or array, between a sentence before it and one after it
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return ""
+	}
+	closing := "}"
+	if text[start] == '[' {
+		closing = "]"
+	}
+	end := strings.LastIndex(text, closing)
+	if end < start || !json.Valid([]byte(text[start:end+1])) {
+		return ""
+	}
+	return text[start : end+1]
+}
+
+// checks json values against a json schema. it covers the keywords used to describe the output of a pattern: type, enum, const, properties, required, additionalProperties, items, the length and range limits, pattern, anyOf, oneOf, allOf, not and local $refs. other keywords are ignored
+type schemaValidator struct {
+	root     interface{}
+	problems []string
+}
+
+func (v *schemaValidator) fail(path string, format string, args ...interface{}) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+// whether the value matches the schema, without keeping the problems
+func (v *schemaValidator) matches(schema interface{}, value interface{}) bool {
+	nested := schemaValidator{root: v.root}
+	nested.check(schema, value, "$")
+	return len(nested.problems) == 0
+}
+
+func (v *schemaValidator) check(schema interface{}, value interface{}, path string) {
+	rules, ok := schema.(map[string]interface{})
+	if !ok {
+		// true allows anything and false nothing
+		if allowed, isBool := schema.(bool); isBool && !allowed {
+			v.fail(path, "no value is allowed here")
+		}
+		return
+	}
+	if ref, ok := rules["$ref"].(string); ok {
+		target, err := v.resolve(ref)
+		if err != nil {
+			v.fail(path, "%v", err)
+			return
+		}
+		v.check(target, value, path)
+	}
+	if types, ok := rules["type"]; ok && !matchesType(types, value) {
+		v.fail(path, "expected %s, got %s", describeTypes(types), jsonType(value))
+		return
+	}
+	if enum, ok := rules["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			if jsonEqual(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options, _ := json.Marshal(enum)
+			v.fail(path, "must be one of %s", options)
+		}
+	}
+	if constant, ok := rules["const"]; ok && !jsonEqual(constant, value) {
+		expected, _ := json.Marshal(constant)
+		v.fail(path, "must be %s", expected)
+	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		v.checkObject(rules, value, path)
+	case []interface{}:
+		v.checkArray(rules, value, path)
+	case string:
+		length := len([]rune(value))
+		if limit, ok := schemaNumber(rules["minLength"]); ok && float64(length) < limit {
+			v.fail(path, "must be at least %v characters long", limit)
+		}
+		if limit, ok := schemaNumber(rules["maxLength"]); ok && float64(length) > limit {
+			v.fail(path, "must be at most %v characters long", limit)
+		}
+		if pattern, ok := rules["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(value) {
+				v.fail(path, "must match the pattern %s", pattern)
+			}
+		}
+	case json.Number:
+		number, _ := value.Float64()
+		if limit, ok := schemaNumber(rules["minimum"]); ok && number < limit {
+			v.fail(path, "must be at least %v", limit)
+		}
+		if limit, ok := schemaNumber(rules["maximum"]); ok && number > limit {
+			v.fail(path, "must be at most %v", limit)
+		}
+		if limit, ok := schemaNumber(rules["exclusiveMinimum"]); ok && number <= limit {
+			v.fail(path, "must be more than %v", limit)
+		}
+		if limit, ok := schemaNumber(rules["exclusiveMaximum"]); ok && number >= limit {
+			v.fail(path, "must be less than %v", limit)
+		}
+	}
+	if all, ok := rules["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			v.check(sub, value, path)
+		}
+	}
+	if anyOf, ok := rules["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if v.matches(sub, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "does not match any of the allowed schemas")
+		}
+	}
+	if oneOf, ok := rules["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, sub := range oneOf {
+			if v.matches(sub, value) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			v.fail(path, "must match exactly one of the allowed schemas, matches %d", matched)
+		}
+	}
+	if not, ok := rules["not"]; ok && v.matches(not, value) {
+		v.fail(path, "matches a schema it must not match")
+	}
+}
+
+func (v *schemaValidator) checkObject(rules map[string]interface{}, object map[string]interface{}, path string) {
+	if required, ok := rules["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := object[name]; !present {
+					v.fail(path, "missing the required property %q", name)
+				}
+			}
+		}
+	}
+	properties, _ := rules["properties"].(map[string]interface{})
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	// the problems come out in the same order every time
+	sort.Strings(names)
+	for _, name := range names {
+		if property, ok := properties[name]; ok {
+			v.check(property, object[name], path+"."+name)
+			continue
+		}
+		switch additional := rules["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(path, "has the property %q, which the schema doesn't allow", name)
+			}
+		case map[string]interface{}:
+			v.check(additional, object[name], path+"."+name)
+		}
+	}
+	if limit, ok := schemaNumber(rules["minProperties"]); ok && float64(len(object)) < limit {
+		v.fail(path, "must have at least %v properties", limit)
+	}
+	if limit, ok := schemaNumber(rules["maxProperties"]); ok && float64(len(object)) > limit {
+		v.fail(path, "must have at most %v properties", limit)
+	}
+}
+
+func (v *schemaValidator) checkArray(rules map[string]interface{}, array []interface{}, path string) {
+	if limit, ok := schemaNumber(rules["minItems"]); ok && float64(len(array)) < limit {
+		v.fail(path, "must have at least %v items", limit)
+	}
+	if limit, ok := schemaNumber(rules["maxItems"]); ok && float64(len(array)) > limit {
+		v.fail(path, "must have at most %v items", limit)
+	}
+	if items, ok := rules["items"]; ok {
+		for i, item := range array {
+			v.check(items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+	if unique, _ := rules["uniqueItems"].(bool); unique {
+		for i := range array {
+			for j := i + 1; j < len(array); j++ {
+				if jsonEqual(array[i], array[j]) {
+					v.fail(path, "items %d and %d are the same", i, j)
+				}
+			}
+		}
+	}
+}
+
+// finds the schema a $ref such as #/$defs/item points to. only references within the schema are followed
+func (v *schemaValidator) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("the schema refers to %s, only references within the schema are supported", ref)
+	}
+	target := v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		object, ok := target.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("the schema has nothing at %s", ref)
+		}
+		if target, ok = object[part]; !ok {
+			return nil, fmt.Errorf("the schema has nothing at %s", ref)
+		}
+	}
+	return target, nil
+}
+
+// the type keyword is a type name or a list of them
+func matchesType(types interface{}, value interface{}) bool {
+	switch types := types.(type) {
+	case string:
+		return isType(types, value)
+	case []interface{}:
+		for _, name := range types {
+			if name, ok := name.(string); ok && isType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func isType(name string, value interface{}) bool {
+	switch name {
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		f, err := number.Float64()
+		return err == nil && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	}
+	return jsonType(value) == name
+}
+
+func describeTypes(types interface{}) string {
+	if list, ok := types.([]interface{}); ok {
+		names := make([]string, 0, len(list))
+		for _, name := range list {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// numbers in the schema are float64 and those in the reply json.Number, so values are compared by their encoding
+func jsonEqual(a interface{}, b interface{}) bool {
+	return bytes.Equal(canonicalJSON(a), canonicalJSON(b))
+}
+
+func canonicalJSON(value interface{}) []byte {
+	if number, ok := value.(json.Number); ok {
+		if f, err := number.Float64(); err == nil {
+			value = f
+		}
+	}
+	switch value := value.(type) {
+	case []interface{}:
+		parts := make([][]byte, len(value))
+		for i, item := range value {
+			parts[i] = canonicalJSON(item)
+		}
+		return append(append([]byte("["), bytes.Join(parts, []byte(","))...), ']')
+	case map[string]interface{}:
+		canonical := make(map[string]json.RawMessage, len(value))
+		for name, item := range value {
+			canonical[name] = canonicalJSON(item)
+		}
+		encoded, _ := json.Marshal(canonical)
+		return encoded
+	}
+	encoded, _ := json.Marshal(value)
+	return encoded
+}
+
+func schemaNumber(value interface{}) (float64, bool) {
+	number, ok := value.(float64)
+	return number, ok
+}
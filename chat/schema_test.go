@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["title", "tags"],
+	"additionalProperties": false,
+	"properties": {
+		"title": {"type": "string", "minLength": 1},
+		"rating": {"enum": ["good", "bad"]},
+		"stars": {"type": "integer", "minimum": 1, "maximum": 5},
+		"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true},
+		"author": {"$ref": "#/$defs/person"}
+	},
+	"$defs": {
+		"person": {"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}}
+	}
+}`
+
+func parseTestSchema(t *testing.T) interface{} {
+	t.Helper()
+	var schema interface{}
+	if err := json.Unmarshal([]byte(testSchema), &schema); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestCheckJSON(t *testing.T) {
+	schema := parseTestSchema(t)
+	for _, test := range []struct {
+		reply    string
+		problems []string
+	}{
+		{`{"title": "a", "tags": ["x"], "rating": "good", "stars": 4, "author": {"name": "b"}}`, nil},
+		{`{"tags": []}`, []string{`$: missing the required property "title"`}},
+		{`{"title": 3, "tags": "x"}`, []string{"$.tags: expected array, got string", "$.title: expected string, got number"}},
+		{`[{"title": "a"}]`, []string{"$: expected object, got array"}},
+		{`{"title": "", "tags": []}`, []string{"$.title: must be at least 1 characters long"}},
+		{`{"title": "a", "tags": [], "rating": "ok"}`, []string{`$.rating: must be one of ["good","bad"]`}},
+		{`{"title": "a", "tags": [], "stars": 4.5}`, []string{"$.stars: expected integer, got number"}},
+		{`{"title": "a", "tags": [], "stars": 9}`, []string{"$.stars: must be at most 5"}},
+		{`{"title": "a", "tags": ["x", 2, "x"]}`, []string{"$.tags[1]: expected string, got number", "$.tags: items 0 and 2 are the same"}},
+		{`{"title": "a", "tags": [], "author": {}}`, []string{`$.author: missing the required property "name"`}},
+		{`{"title": "a", "tags": [], "year": 2024}`, []string{`$: has the property "year", which the schema doesn't allow`}},
+		{`the title is a`, []string{"the reply is not JSON"}},
+	} {
+		value, problems := checkJSON(test.reply, schema)
+		if !reflect.DeepEqual(problems, test.problems) {
+			t.Errorf("%s has the problems %q, want %q", test.reply, problems, test.problems)
+		}
+		if test.problems == nil && value == "" {
+			t.Errorf("%s matches the schema, but no json was returned", test.reply)
+		}
+	}
+}
+
+func TestCheckJSONLimitsProblems(t *testing.T) {
+	var tags []string
+	for i := 0; i < maxSchemaProblems+3; i++ {
+		tags = append(tags, strconv.Itoa(i))
+	}
+	_, problems := checkJSON(`{"title": "a", "tags": [`+strings.Join(tags, ", ")+`]}`, parseTestSchema(t))
+	if len(problems) != maxSchemaProblems+1 || problems[maxSchemaProblems] != "and 3 more" {
+		t.Errorf("the problems are %q, want %d of them and a note of the rest", problems, maxSchemaProblems)
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		json string
+	}{
+		{`  {"a": 1}  `, `{"a": 1}`},
+		{"```json\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"Here you go:\n```\n[1, 2]\n```\nAnything else?", `[1, 2]`},
+		{`Sure! {"a": {"b": [1]}} Hope that helps.`, `{"a": {"b": [1]}}`},
+		{`The list is [1, 2] as asked.`, `[1, 2]`},
+		{`"just a string"`, `"just a string"`},
+		{`{"a": 1`, ``},
+		{`no json at all`, ``},
+		{`} backwards {`, ``},
+	} {
+		if got := extractJSON(test.text); got != test.json {
+			t.Errorf("extractJSON(%q) = %q, want %q", test.text, got, test.json)
+		}
+	}
+}
+
+func TestSendWithSchema(t *testing.T) {
+	testHome(t)
+	var asked []Chat
+	replies := []string{
+		`{"title": 3, "tags": []}`,
+		"Here is the corrected JSON:\n```json\n{\"title\": \"a\", \"tags\": [\"x\"]}\n```",
+	}
+	stubProvider(t, "stubschema", stub{reply: func(chat Chat) (Response, error) {
+		asked = append(asked, chat)
+		return Response{Text: replies[len(asked)-1], Usage: Usage{InputTokens: 10, OutputTokens: 5}}, nil
+	}})
+	chat := Chat{Model: "stubschema/m", Pattern: "extract", Message: "the post", Schema: json.RawMessage(testSchema)}
+	response, err := chat.SendMessageToModel(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asked) != 2 {
+		t.Fatalf("the model was asked %d times, want 2", len(asked))
+	}
+	if want := "{\n  \"title\": \"a\",\n  \"tags\": [\n    \"x\"\n  ]\n}"; response.Text != want {
+		t.Errorf("the reply is %q, want the json indented %q", response.Text, want)
+	}
+	if response.Usage != (Usage{InputTokens: 20, OutputTokens: 10}) {
+		t.Errorf("the usage is %+v, want that of both attempts", response.Usage)
+	}
+	if !strings.HasPrefix(asked[0].Pattern, "extract\n\nOUTPUT FORMAT:") || asked[0].Message != "the post" {
+		t.Errorf("the first attempt was sent %q with the pattern %q", asked[0].Message, asked[0].Pattern)
+	}
+
+	// the second attempt replays the first and says what is wrong with it
+	retry := asked[1]
+	if want := "That reply does not match the JSON schema:\n- $.title: expected string, got number\n\nReply again with only the corrected JSON."; retry.Message != want {
+		t.Errorf("the corrective message is %q, want %q", retry.Message, want)
+	}
+	if len(retry.Session) != 2 || retry.Session[0].Content != "the post" || retry.Session[1].Role != RoleAssistant || retry.Session[1].Content != replies[0] {
+		t.Errorf("the second attempt has the session %+v, want the first message and its reply", retry.Session)
+	}
+	if retry.Pattern != asked[0].Pattern {
+		t.Errorf("the second attempt has the pattern %q, want the one with the schema", retry.Pattern)
+	}
+}
+
+func TestSendWithSchemaGivesUp(t *testing.T) {
+	testHome(t)
+	attempts := 0
+	stubProvider(t, "stubschema", stub{reply: func(chat Chat) (Response, error) {
+		attempts++
+		return Response{Text: "I can't do that"}, nil
+	}})
+	chat := Chat{Model: "stubschema/m", Message: "the post", Schema: json.RawMessage(testSchema)}
+	_, err := chat.SendMessageToModel(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "after 3 tries: the reply is not JSON") {
+		t.Errorf("the error is %v, want one saying the reply is not json after 3 tries", err)
+	}
+	if attempts != maxSchemaAttempts {
+		t.Errorf("the model was asked %d times, want %d", attempts, maxSchemaAttempts)
+	}
+}
@@ -0,0 +1,281 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xssdoctor/gofabric/utils"
+)
+
+// how long a tool may run can be changed in the .env file
+func init() {
+	RegisterSetting(ConfigKey{Name: "TOOL_TIMEOUT", Default: "1m0s"}) // time a tool may run before it is stopped
+}
+
+// rounds of tool calls a single message may take before the model is stopped
+const maxToolRounds = 10
+
+// output of a tool beyond this many bytes is cut off before it goes back to the model
+const maxToolOutput = 50000
+
+// Tool is a function the model may ask to call. A pattern declares its tools in tools.json next to its system.md. A tool runs its shell command, or the handler registered under its name when it has none
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // json schema of the arguments. it must describe an object
+	Command     string          `json:"command,omitempty"`    // run with sh -c. the arguments are passed as json on stdin and in FABRIC_TOOL_ARGS, and every top level argument also as FABRIC_ARG_<NAME>
+}
+
+// ToolCall is a request of the model to call one of its tools
+type ToolCall struct {
+	ID        string          `json:"id"` // given by the provider. the result of the call is sent back with it
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"` // a json object
+}
+
+// ToolResult is what a tool call returned, sent back to the model
+type ToolResult struct {
+	CallID  string `json:"call_id"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	IsError bool   `json:"is_error,omitempty"` // the content is why the call failed
+}
+
+// ToolConfirmer asks the user whether the model may make a call. the call is only run when it returns true
+type ToolConfirmer func(tool Tool, call ToolCall) bool
+
+// ToolHandler runs a tool in Go. it is given the arguments of the call as a json object and returns what the model is told
+type ToolHandler func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+type registeredTool struct {
+	tool    Tool
+	handler ToolHandler
+}
+
+var (
+	toolsMu sync.RWMutex
+	tools   = map[string]registeredTool{}
+)
+
+// RegisterTool makes a tool that runs in Go available to patterns. tools.json then only needs its name, though it can still change the description and parameters. It panics if a tool with the same name is already registered
+func RegisterTool(tool Tool, handler ToolHandler) {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	if _, ok := tools[tool.Name]; ok {
+		panic("chat: RegisterTool called twice for tool " + tool.Name)
+	}
+	tools[tool.Name] = registeredTool{tool: tool, handler: handler}
+}
+
+// RegisteredTools returns the names of the tools that run in Go
+func RegisteredTools() []string {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	names := make([]string, 0, len(tools))
+	for name := range tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func registeredHandler(name string) (registeredTool, bool) {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	registered, ok := tools[name]
+	return registered, ok
+}
+
+// the providers only accept tool names made of these
+var toolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ParseTools reads the tools of a pattern from the contents of its tools.json, which is a list of tools. The description and parameters of registered tools are filled in when they are left out
+func ParseTools(contents []byte) ([]Tool, error) {
+	var list []Tool
+	if err := json.Unmarshal(contents, &list); err != nil {
+		return nil, fmt.Errorf("could not read tools.json: %v", err)
+	}
+	seen := map[string]bool{}
+	for i, tool := range list {
+		if !toolName.MatchString(tool.Name) {
+			return nil, fmt.Errorf("tools.json: %q is not a valid tool name. Use up to 64 letters, digits, _ and -", tool.Name)
+		}
+		if seen[tool.Name] {
+			return nil, fmt.Errorf("tools.json: tool %s is declared twice", tool.Name)
+		}
+		seen[tool.Name] = true
+		if registered, ok := registeredHandler(tool.Name); ok && tool.Command == "" {
+			if tool.Description == "" {
+				tool.Description = registered.tool.Description
+			}
+			if len(tool.Parameters) == 0 {
+				tool.Parameters = registered.tool.Parameters
+			}
+		} else if tool.Command == "" {
+			return nil, fmt.Errorf("tools.json: tool %s has no command, and there is no built in tool with that name. The built in tools are %s", tool.Name, strings.Join(RegisteredTools(), ", "))
+		}
+		if len(tool.Parameters) == 0 {
+			tool.Parameters = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		var schema map[string]interface{}
+		if err := json.Unmarshal(tool.Parameters, &schema); err != nil || schema["type"] != "object" {
+			return nil, fmt.Errorf("tools.json: the parameters of tool %s must be a json schema of an object", tool.Name)
+		}
+		list[i] = tool
+	}
+	return list, nil
+}
+
+// ParseArguments returns the arguments of the call as a map. arguments that are not a json object give an empty map
+func (call ToolCall) ParseArguments() map[string]interface{} {
+	arguments := map[string]interface{}{}
+	json.Unmarshal(call.Arguments, &arguments)
+	return arguments
+}
+
+// sends the message and runs the tools the model asks for, sending their results back until it answers without calling any. the rounds are not streamed, so when streaming the final answer is sent in one piece. every round is sent to the model that answered the first, so a fallback doesn't change models halfway
+func (chat Chat) sendWithTools(ctx context.Context) (Response, error) {
+	out := chat.ResponseChan
+	if chat.Stream {
+		defer close(out)
+	}
+	request := chat
+	request.Stream = false
+	request.ResponseChan = nil
+	start := time.Now()
+	var usage Usage
+	var fallbackFrom []string
+	for round := 1; ; round++ {
+		response, err := request.sendChain(ctx)
+		addUsage(&usage, response.Usage)
+		if err != nil {
+			return response, err
+		}
+		if round == 1 {
+			fallbackFrom = response.FallbackFrom
+		}
+		if len(response.ToolCalls) == 0 {
+			response.Usage = usage
+			response.Latency = time.Since(start)
+			response.FallbackFrom = fallbackFrom
+			if chat.Stream {
+				// like the streams of the models, the text ends with a newline
+				out <- StreamEvent{Text: response.Text + "\n"}
+				out <- StreamEvent{Done: true, Response: &response}
+			}
+			return response, nil
+		}
+		if round == maxToolRounds {
+			return response, fmt.Errorf("the model was still calling tools after %d rounds", maxToolRounds)
+		}
+		if round == 1 {
+			request.Model = request.modelChain()[len(fallbackFrom)]
+			request.NoFallback = true
+			request.Session = append(append([]Message{}, chat.Session...), Message{Role: RoleUser, Content: chat.Message, Attachments: chat.Attachments, Timestamp: time.Now()})
+			request.Message = ""
+			request.Attachments = nil
+		}
+		request.Session = append(request.Session,
+			Message{Role: RoleAssistant, Content: response.Text, ToolCalls: response.ToolCalls, Model: response.QualifiedModel(), Timestamp: time.Now()},
+			Message{Role: RoleTool, ToolResults: chat.runTools(ctx, response.ToolCalls), Timestamp: time.Now()},
+		)
+		if ctx.Err() != nil {
+			return response, ctx.Err()
+		}
+	}
+}
+
+// runs the calls one after the other. failures are sent back to the model, which can often work around them
+func (chat Chat) runTools(ctx context.Context, calls []ToolCall) []ToolResult {
+	results := make([]ToolResult, 0, len(calls))
+	for _, call := range calls {
+		result := ToolResult{CallID: call.ID, Name: call.Name}
+		output, err := chat.runTool(ctx, call)
+		if err != nil {
+			result.Content = err.Error()
+			result.IsError = true
+		} else {
+			result.Content = output
+		}
+		if len(result.Content) > maxToolOutput {
+			result.Content = result.Content[:maxToolOutput] + "\n[output cut off]"
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (chat Chat) runTool(ctx context.Context, call ToolCall) (string, error) {
+	var tool Tool
+	found := false
+	for _, t := range chat.Tools {
+		if t.Name == call.Name {
+			tool, found = t, true
+			break
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("there is no tool named %s", call.Name)
+	}
+	if chat.ConfirmTool == nil || !chat.ConfirmTool(tool, call) {
+		return "", errors.New("the user did not allow this call")
+	}
+	utils.LogProgress(fmt.Sprintf("running %s", call.Name))
+	timeout, err := time.ParseDuration(chat.ConfigValue("TOOL_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if tool.Command != "" {
+		return runCommand(ctx, tool.Command, call)
+	}
+	registered, ok := registeredHandler(tool.Name)
+	if !ok {
+		return "", fmt.Errorf("tool %s has no command", tool.Name)
+	}
+	return registered.handler(ctx, call.Arguments)
+}
+
+// runs the command of a tool with the arguments on stdin and in the environment. what it prints is the result, and a failure includes what it printed
+func runCommand(ctx context.Context, command string, call ToolCall) (string, error) {
+	arguments := call.Arguments
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(arguments)
+	cmd.Env = append(os.Environ(), "FABRIC_TOOL_ARGS="+string(arguments))
+	for name, value := range call.ParseArguments() {
+		text, ok := value.(string)
+		if !ok {
+			encoded, _ := json.Marshal(value)
+			text = string(encoded)
+		}
+		cmd.Env = append(cmd.Env, "FABRIC_ARG_"+strings.ToUpper(name)+"="+text)
+	}
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("%s took too long and was stopped", call.Name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %v\n%s", call.Name, err, output)
+	}
+	return string(output), nil
+}
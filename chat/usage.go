@@ -0,0 +1,201 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xssdoctor/gofabric/utils"
+)
+
+// UsageRecord is one request in the usage log, ~/.config/fabric/usage.jsonl
+type UsageRecord struct {
+	Time    time.Time `json:"time"`
+	Model   string    `json:"model"` // provider/model
+	Pattern string    `json:"pattern,omitempty"`
+	Session string    `json:"session,omitempty"`
+	Usage   Usage     `json:"usage"`
+	Cost    float64   `json:"cost"`             // in dollars, using the prices at the time of the request
+	Priced  bool      `json:"priced,omitempty"` // false when the model has no price, so the cost is unknown
+}
+
+// Price is what a model charges in dollars per million tokens
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// prices of well known models, matched by the longest prefix of the model name. they can be changed or extended in ~/.config/fabric/prices.json
+var defaultPrices = map[string]Price{
+	"gpt-4o":             {Input: 5, Output: 15},
+	"gpt-4-turbo":        {Input: 10, Output: 30},
+	"gpt-4-1106":         {Input: 10, Output: 30},
+	"gpt-4-0125":         {Input: 10, Output: 30},
+	"gpt-4-32k":          {Input: 60, Output: 120},
+	"gpt-4":              {Input: 30, Output: 60},
+	"gpt-3.5-turbo":      {Input: 0.5, Output: 1.5},
+	"claude-3-opus":      {Input: 15, Output: 75},
+	"claude-3-5-sonnet":  {Input: 3, Output: 15},
+	"claude-3-sonnet":    {Input: 3, Output: 15},
+	"claude-3-haiku":     {Input: 0.25, Output: 1.25},
+	"claude-2":           {Input: 8, Output: 24},
+	"claude-instant":     {Input: 0.8, Output: 2.4},
+	"gemini-1.5-pro":     {Input: 3.5, Output: 10.5},
+	"gemini-1.5-flash":   {Input: 0.35, Output: 1.05},
+	"gemini-1.0-pro":     {Input: 0.5, Output: 1.5},
+	"gemini-pro":         {Input: 0.5, Output: 1.5},
+	"llama3-70b-8192":    {Input: 0.59, Output: 0.79},
+	"llama3-8b-8192":     {Input: 0.05, Output: 0.08},
+	"mixtral-8x7b-32768": {Input: 0.24, Output: 0.24},
+	"gemma-7b-it":        {Input: 0.07, Output: 0.07},
+	"mistral-large":      {Input: 3, Output: 9},
+	"mistral-medium":     {Input: 2.7, Output: 8.1},
+	"mistral-small":      {Input: 1, Output: 3},
+	"codestral":          {Input: 1, Output: 3},
+	"open-mistral-nemo":  {Input: 0.3, Output: 0.3},
+	"open-mistral-7b":    {Input: 0.25, Output: 0.25},
+	"open-mixtral-8x7b":  {Input: 0.7, Output: 0.7},
+	"open-mixtral-8x22b": {Input: 2, Output: 6},
+	"command-r-plus":     {Input: 3, Output: 15},
+	"command-r":          {Input: 0.5, Output: 1.5},
+	"command-light":      {Input: 0.3, Output: 0.6},
+	"command":            {Input: 1, Output: 2},
+}
+
+// only one request should append to the usage log at a time
+var usageMu sync.Mutex
+
+func fabricPath(name string) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "fabric", name), nil
+}
+
+// reads ~/.config/fabric/prices.json on top of the default prices. the keys are model names or provider/model
+func loadPrices() map[string]Price {
+	prices := make(map[string]Price, len(defaultPrices))
+	for name, price := range defaultPrices {
+		prices[name] = price
+	}
+	path, err := fabricPath("prices.json")
+	if err != nil {
+		return prices
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return prices
+	}
+	var custom map[string]Price
+	if err := json.Unmarshal(contents, &custom); err != nil {
+		utils.LogWarning(fmt.Errorf("could not read prices.json: %v", err))
+		return prices
+	}
+	for name, price := range custom {
+		prices[name] = price
+	}
+	return prices
+}
+
+// Cost returns what the usage cost in dollars on the qualified model, e.g. openai/gpt-4o. ok is false when the model has no price. models served by ollama are local and cost nothing
+func Cost(qualified string, usage Usage) (float64, bool) {
+	provider, bare := SplitModelName(qualified)
+	prices := loadPrices()
+	price, ok := prices[qualified]
+	if registered, found := GetProvider(provider); !ok && found && !registered.Priced {
+		// the names in the price table are those of the vendors' own models, which ollama, azure and the endpoints also serve, e.g. ollama/command-r:35b or office-vllm/gpt-4o. what those cost is not known, unless prices.json says
+		return 0, provider == "ollama"
+	}
+	if !ok {
+		bare = strings.TrimPrefix(bare, "models/")
+		longest := 0
+		for prefix, p := range prices {
+			if strings.HasPrefix(bare, prefix) && len(prefix) > longest {
+				price, ok, longest = p, true, len(prefix)
+			}
+		}
+	}
+	if !ok {
+		return 0, false
+	}
+	return (float64(usage.InputTokens)*price.Input + float64(usage.OutputTokens)*price.Output) / 1e6, true
+}
+
+// estimates the usage of a request from its text, for providers that don't report it
+func (chat Chat) estimateUsage(response string) Usage {
+	input := EstimateTokens(chat.Context+chat.Pattern) + EstimateTokens(chat.Message) + len(chat.Attachments)*imageTokens
+	for _, message := range chat.Session {
+		input += estimateMessageTokens(message)
+	}
+	return Usage{InputTokens: input, OutputTokens: EstimateTokens(response), Estimated: true}
+}
+
+// appends the request to the usage log. failing to record is not worth failing the request over, so it only warns
+func (chat Chat) recordUsage(qualified string, usage Usage) {
+	cost, priced := Cost(qualified, usage)
+	record := UsageRecord{
+		Time:    time.Now(),
+		Model:   qualified,
+		Pattern: chat.PatternName,
+		Session: chat.SessionName,
+		Usage:   usage,
+		Cost:    cost,
+		Priced:  priced,
+	}
+	if err := appendUsage(record); err != nil {
+		utils.LogWarning(fmt.Errorf("could not record usage: %v", err))
+	}
+}
+
+func appendUsage(record UsageRecord) error {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	path, err := fabricPath("usage.jsonl")
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// ReadUsage returns every request in the usage log. lines that can't be read are skipped
+func ReadUsage() ([]UsageRecord, error) {
+	path, err := fabricPath("usage.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []UsageRecord{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	var records []UsageRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCostOfLocalModels(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // no prices.json
+	// the providers register themselves from the models package, which this package can't import
+	stubProvider(t, "ollama", stub{})
+	stubProvider(t, "cohere", stub{priced: true})
+	usage := Usage{InputTokens: 1e6, OutputTokens: 1e6}
+	for _, qualified := range []string{"ollama/command-r:35b", "ollama/codestral:22b", "ollama/mistral-small:latest"} {
+		if cost, ok := Cost(qualified, usage); cost != 0 || !ok {
+			t.Errorf("Cost(%s) = %v, %v, want 0, true", qualified, cost, ok)
+		}
+	}
+	if cost, ok := Cost("cohere/command-r", usage); cost == 0 || !ok {
+		t.Errorf("Cost(cohere/command-r) = %v, %v, want the price of command-r", cost, ok)
+	}
+}
+
+func TestCostOfEndpointModels(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	// an endpoint from endpoints.json and an azure deployment, which serve models with the names of openai's
+	stubProvider(t, "office-vllm", stub{})
+	stubProvider(t, "azure", stub{})
+	stubProvider(t, "openai", stub{priced: true})
+	usage := Usage{InputTokens: 1e6, OutputTokens: 1e6}
+	for _, qualified := range []string{"office-vllm/gpt-4o", "azure/gpt-4o-mini"} {
+		if cost, ok := Cost(qualified, usage); cost != 0 || ok {
+			t.Errorf("Cost(%s) = %v, %v, want no price", qualified, cost, ok)
+		}
+	}
+	if cost, ok := Cost("openai/gpt-4o", usage); cost == 0 || !ok {
+		t.Errorf("Cost(openai/gpt-4o) = %v, %v, want the price of gpt-4o", cost, ok)
+	}
+
+	// prices.json can still price them by their qualified name
+	os.MkdirAll(filepath.Join(home, ".config", "fabric"), 0755)
+	if err := os.WriteFile(filepath.Join(home, ".config", "fabric", "prices.json"), []byte(`{"office-vllm/gpt-4o": {"input": 1, "output": 2}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cost, ok := Cost("office-vllm/gpt-4o", usage); cost != 3 || !ok {
+		t.Errorf("Cost(office-vllm/gpt-4o) = %v, %v, want the price of prices.json, 3", cost, ok)
+	}
+}
@@ -0,0 +1,214 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xssdoctor/gofabric/utils"
+)
+
+// strategies for sessions that don't fit in the model's context window
+const (
+	StrategyNone          = "none"           // send the whole session and let the provider reject it
+	StrategyDropOldest    = "drop_oldest"    // drop the oldest turns until the rest fits
+	StrategySlidingWindow = "sliding_window" // keep the last CONTEXT_WINDOW_MESSAGES messages, then drop more if they still don't fit
+	StrategySummarize     = "summarize"      // replace the turns that don't fit with a summary written by SUMMARY_MODEL
+)
+
+// context window used for models that are not in the table below and have no budget in CONTEXT_BUDGETS
+const defaultContextWindow = 8192
+
+// tokens kept free for the reply. the models ask for up to 4096
+const replyReserve = 4096
+
+// tokens kept free for the summary when the summarize strategy is used
+const summaryReserve = 1024
+
+// context windows of well known models, matched by the longest prefix of the model name
+var contextWindows = map[string]int{
+	"gpt-4o":          128000,
+	"gpt-4-turbo":     128000,
+	"gpt-4-1106":      128000,
+	"gpt-4-0125":      128000,
+	"gpt-4-32k":       32768,
+	"gpt-4":           8192,
+	"gpt-3.5-turbo":   16385,
+	"claude-3":        200000,
+	"claude-2":        100000,
+	"gemini-1.5":      1000000,
+	"gemini-pro":      30720,
+	"gemini-1.0":      30720,
+	"llama3-70b-8192": 8192,
+	"llama3":          8192,
+	"llama2":          4096,
+	"mixtral":         32768,
+	"mistral":         32768,
+	"gemma":           8192,
+	"phi3":            4096,
+	"mistral-large":   128000,
+	"open-mistral":    32768,
+	"open-mixtral":    32768,
+	"codestral":       32768,
+	"command-r":       128000,
+	"command":         4096,
+}
+
+// the context settings can be changed in the .env file
+func init() {
+	RegisterSetting(ConfigKey{Name: "CONTEXT_STRATEGY", Default: StrategyDropOldest})
+	RegisterSetting(ConfigKey{Name: "CONTEXT_BUDGETS"}) // context window per model, e.g. gpt-4o=128000,ollama/llama3:latest=8192
+	RegisterSetting(ConfigKey{Name: "CONTEXT_WINDOW_MESSAGES", Default: "20"})
+	RegisterSetting(ConfigKey{Name: "SUMMARY_MODEL"}) // blank uses the model of the chat
+}
+
+// EstimateTokens guesses the number of tokens in a text. It is about four characters per token for english, which is close enough to keep a request under its limit
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// every message costs a few tokens for the role and the separators, and more for its images
+func estimateMessageTokens(message Message) int {
+	tokens := EstimateTokens(message.Content) + 4 + len(message.Attachments)*imageTokens
+	for _, call := range message.ToolCalls {
+		tokens += EstimateTokens(call.Name+string(call.Arguments)) + 4
+	}
+	for _, result := range message.ToolResults {
+		tokens += EstimateTokens(result.Content) + 4
+	}
+	return tokens
+}
+
+// ContextWindow returns the context window of the model in tokens. qualified is the model with its provider, e.g. ollama/llama3:latest. a budget in CONTEXT_BUDGETS wins over the built in table
+func (chat Chat) ContextWindow(qualified string) int {
+	_, bare := SplitModelName(qualified)
+	for _, entry := range strings.Split(chat.ConfigValue("CONTEXT_BUDGETS"), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || (name != qualified && name != bare) {
+			continue
+		}
+		if tokens, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && tokens > 0 {
+			return tokens
+		}
+	}
+	// gemini names start with models/
+	bare = strings.TrimPrefix(bare, "models/")
+	window, longest := defaultContextWindow, 0
+	for prefix, tokens := range contextWindows {
+		if strings.HasPrefix(bare, prefix) && len(prefix) > longest {
+			window, longest = tokens, len(prefix)
+		}
+	}
+	return window
+}
+
+// tokens the prompt may use, which is the context window less the room kept for the reply
+func inputBudget(window int) int {
+	if window <= 2*replyReserve {
+		return window / 2
+	}
+	return window - replyReserve
+}
+
+// returns the index of the first message to keep so that the messages from there on fit in available tokens. a kept session always starts with a user message, which every provider requires
+func keepFrom(session []Message, available int) int {
+	used := 0
+	start := len(session)
+	for i := len(session) - 1; i >= 0; i-- {
+		used += estimateMessageTokens(session[i])
+		if used > available {
+			break
+		}
+		start = i
+	}
+	return alignToUser(session, start)
+}
+
+// moves start forward to the next user message
+func alignToUser(session []Message, start int) int {
+	for start < len(session) && session[start].Role != RoleUser {
+		start++
+	}
+	return start
+}
+
+// fitContextWindow trims the session so the request fits in the context window of the model, using the strategy in CONTEXT_STRATEGY. provider and model are the resolved provider and the name it expects. the session file is not changed, only what is sent
+func (chat Chat) fitContextWindow(ctx context.Context, provider Provider, model string) (Chat, error) {
+	strategy := strings.ToLower(chat.ConfigValue("CONTEXT_STRATEGY"))
+	if strategy == StrategyNone || len(chat.Session) == 0 {
+		return chat, nil
+	}
+	qualified := provider.Name + "/" + model
+	budget := inputBudget(chat.ContextWindow(qualified))
+	available := budget - EstimateTokens(chat.Context+chat.Pattern) - EstimateTokens(chat.Message) - len(chat.Attachments)*imageTokens
+
+	start := 0
+	switch strategy {
+	case StrategySlidingWindow:
+		if limit, err := strconv.Atoi(chat.ConfigValue("CONTEXT_WINDOW_MESSAGES")); err == nil && limit >= 0 && len(chat.Session) > limit {
+			start = alignToUser(chat.Session, len(chat.Session)-limit)
+		}
+		start += keepFrom(chat.Session[start:], available)
+	case StrategySummarize:
+		start = keepFrom(chat.Session, available)
+		if start > 0 {
+			// keep room for the summary itself, but never more than a quarter of what is left
+			reserve := summaryReserve
+			if reserve > available/4 {
+				reserve = available / 4
+			}
+			start = keepFrom(chat.Session, available-reserve)
+		}
+	case StrategyDropOldest:
+		start = keepFrom(chat.Session, available)
+	default:
+		return chat, fmt.Errorf("unknown CONTEXT_STRATEGY %s. Use %s, %s, %s or %s", strategy, StrategyDropOldest, StrategySlidingWindow, StrategySummarize, StrategyNone)
+	}
+	if start == 0 {
+		return chat, nil
+	}
+
+	dropped := chat.Session[:start]
+	chat.Session = chat.Session[start:]
+	if strategy != StrategySummarize {
+		utils.LogWarning(fmt.Errorf("left out the %d oldest messages of the session to fit the context window of %s", len(dropped), qualified))
+		return chat, nil
+	}
+	summary, err := chat.summarize(ctx, dropped, qualified)
+	if err != nil {
+		return chat, fmt.Errorf("could not summarize the session: %v", err)
+	}
+	// the summary goes into the system prompt, which every provider accepts
+	chat.Context = "Summary of the earlier part of this conversation:\n" + summary + "\n\n" + chat.Context
+	return chat, nil
+}
+
+// prompt used to summarize the turns that don't fit
+const summaryPrompt = `You summarize conversations. Write a short summary of the conversation below, keeping the facts, decisions, names and open questions that later messages may refer to. Reply with the summary only.`
+
+// asks SUMMARY_MODEL, or the chat's own model, for a summary of the messages
+func (chat Chat) summarize(ctx context.Context, messages []Message, qualified string) (string, error) {
+	var transcript strings.Builder
+	for _, message := range messages {
+		transcript.WriteString(message.Role + ": " + message.Content + "\n\n")
+	}
+	summaryModel := chat.ConfigValue("SUMMARY_MODEL")
+	if summaryModel == "" {
+		summaryModel = qualified
+	}
+	summaryChat := Chat{
+		Message:       transcript.String(),
+		Pattern:       summaryPrompt,
+		PatternName:   "session summary",
+		SessionName:   chat.SessionName,
+		Config:        chat.Config,
+		Model:         summaryModel,
+		Temperature:   0.2,
+		TopP:          chat.TopP,
+		RefreshModels: chat.RefreshModels,
+		Timeout:       chat.Timeout,
+	}
+	summary, err := summaryChat.SendMessageToModel(ctx)
+	return summary.Text, err
+}
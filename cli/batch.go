@@ -0,0 +1,541 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xssdoctor/gofabric/chat"
+	"github.com/xssdoctor/gofabric/db"
+	"github.com/xssdoctor/gofabric/flags"
+	"github.com/xssdoctor/gofabric/loaders"
+	"github.com/xssdoctor/gofabric/utils"
+)
+
+// one input of a batch
+type batchItem struct {
+	id   string // names the input in the results. for files this is the path
+	path string // file the input is read from, empty when the text is given
+	text string
+}
+
+// one line of a .jsonl results file
+type batchResult struct {
+	ID        string     `json:"id"`
+	Time      time.Time  `json:"time"`
+	Model     string     `json:"model,omitempty"` // provider/model that answered
+	Text      string     `json:"text,omitempty"`
+	Usage     chat.Usage `json:"usage"`
+	LatencyMs int64      `json:"latency_ms"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// runs the pattern over every input of the batch, flags.BatchWorkers at a time. inputs that already have a result in the output are skipped, so a batch that was stopped can be run again to finish it
+func runBatch(flags flags.Flags) error {
+	if flags.Output == "" {
+		return errors.New("--batch needs -o: a directory for one output per input, or a .jsonl file for all results")
+	}
+	if flags.Session != "" {
+		return errors.New("--session can't be used with --batch")
+	}
+	items, err := batchItems(flags.Batch)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("no inputs found in %s", flags.Batch)
+	}
+	out, err := newBatchOutput(flags.Output)
+	if err != nil {
+		return err
+	}
+	defer out.close()
+
+	var pending []batchItem
+	for _, item := range items {
+		if !out.done(item.id) {
+			pending = append(pending, item)
+		}
+	}
+	if skipped := len(items) - len(pending); skipped > 0 {
+		utils.LogProgress(fmt.Sprintf("skipping %d of %d inputs that were finished before", skipped, len(items)))
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	// the configuration, the pattern and the model are looked up once for the whole batch
+	activeChat, err := newChat(ctx, flags)
+	if err != nil {
+		return err
+	}
+	activeChat.Stream = false
+	activeChat.ResponseChan = nil
+	if flags.BatchAPI {
+		return submitBatch(activeChat, pending, flags)
+	}
+	reduce, err := reducePattern(flags.ReducePattern, activeChat)
+	if err != nil {
+		return err
+	}
+
+	workers := flags.BatchWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		wg             sync.WaitGroup
+		mu             sync.Mutex
+		finished, fail int
+	)
+	slots := make(chan struct{}, workers)
+	for _, item := range pending {
+		wg.Add(1)
+		go func(item batchItem) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			if ctx.Err() != nil {
+				return
+			}
+			response, err := sendBatchItem(ctx, activeChat, item, flags, reduce)
+			if ctx.Err() != nil {
+				// stopped by the user. the input is done again on the next run
+				return
+			}
+			if err == nil {
+				err = out.write(item.id, response)
+			} else {
+				out.writeError(item.id, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			finished++
+			if err != nil {
+				fail++
+				utils.LogWarning(fmt.Errorf("[%d/%d] %s failed: %v", finished, len(pending), item.id, err))
+				return
+			}
+			utils.LogProgress(fmt.Sprintf("[%d/%d] %s done", finished, len(pending), item.id))
+		}(item)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return fmt.Errorf("stopped after %d of %d inputs. Run the same command again to finish the rest", finished, len(pending))
+	}
+	if fail > 0 {
+		return fmt.Errorf("%d of %d inputs failed. Run the same command again to retry them", fail, len(pending))
+	}
+	return nil
+}
+
+// reads the input and sends it. inputs too large for the model are sent in chunks unless that is turned off
+func sendBatchItem(ctx context.Context, activeChat chat.Chat, item batchItem, flags flags.Flags, reduce string) (chat.Response, error) {
+	message, err := batchMessage(item, flags.Message)
+	if err != nil {
+		return chat.Response{}, err
+	}
+	activeChat.Message = message
+	if flags.NoChunk {
+		return activeChat.SendMessageToModel(ctx)
+	}
+	return activeChat.SendChunked(ctx, reduce)
+}
+
+// the message sent for an input. files are read with the loaders, so they can be documents too. a message given on the command line follows every input
+func batchMessage(item batchItem, message string) (string, error) {
+	if item.path != "" {
+		document, err := loaders.Load(item.path)
+		if err != nil {
+			return "", err
+		}
+		return loaders.Compose([]loaders.Document{document}, message), nil
+	}
+	if message != "" {
+		return item.text + "\n\n" + message, nil
+	}
+	return item.text, nil
+}
+
+// finds the inputs of a batch: the files of a directory and its subdirectories, the files matching a glob, or the rows of a .jsonl or .csv file
+func batchItems(source string) ([]batchItem, error) {
+	info, statErr := os.Stat(source)
+	switch {
+	case statErr == nil && info.IsDir():
+		return directoryItems(source)
+	case statErr == nil && strings.EqualFold(filepath.Ext(source), ".jsonl"):
+		return jsonlItems(source)
+	case statErr == nil && strings.EqualFold(filepath.Ext(source), ".csv"):
+		return csvItems(source)
+	case strings.ContainsAny(source, "*?["):
+		matches, err := filepath.Glob(source)
+		if err != nil {
+			return nil, err
+		}
+		var items []batchItem
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				items = append(items, batchItem{id: filepath.ToSlash(match), path: match})
+			}
+		}
+		return items, nil
+	case statErr != nil:
+		return nil, statErr
+	}
+	return nil, fmt.Errorf("%s is not a directory, a glob, or a .jsonl or .csv file", source)
+}
+
+// every file of the directory and its subdirectories, leaving out hidden ones. the ids are the paths within the directory
+func directoryItems(dir string) ([]batchItem, error) {
+	var items []batchItem
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		items = append(items, batchItem{id: filepath.ToSlash(rel), path: path})
+		return nil
+	})
+	return items, err
+}
+
+// every line is an object with the input under "input", "text" or "message" and an optional "id", or just a string. lines without an id are numbered from 1
+func jsonlItems(path string) ([]batchItem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var items []batchItem
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+		item := batchItem{id: strconv.Itoa(line)}
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &item.text); err != nil {
+			if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+				return nil, fmt.Errorf("line %d of %s is not json: %v", line, path, err)
+			}
+			for _, key := range []string{"input", "text", "message"} {
+				if text, ok := fields[key].(string); ok {
+					item.text = text
+					break
+				}
+			}
+			if id, ok := fields["id"]; ok && id != nil {
+				item.id = fmt.Sprint(id)
+			}
+		}
+		if item.text == "" {
+			return nil, fmt.Errorf("line %d of %s has no input. Give it as \"input\"", line, path)
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+// the input is the column named input, text or message, or the first column when there is no header. the id is the column named id, or the row number
+func csvItems(path string) ([]batchItem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	inputColumn, idColumn := -1, -1
+	for i, name := range rows[0] {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "input", "text", "message":
+			if inputColumn < 0 {
+				inputColumn = i
+			}
+		case "id":
+			idColumn = i
+		}
+	}
+	first := 1
+	if inputColumn < 0 {
+		// no header, every row is an input
+		inputColumn, idColumn, first = 0, -1, 0
+	}
+	var items []batchItem
+	for i := first; i < len(rows); i++ {
+		row := rows[i]
+		if inputColumn >= len(row) || strings.TrimSpace(row[inputColumn]) == "" {
+			continue
+		}
+		item := batchItem{id: strconv.Itoa(i + 1 - first), text: row[inputColumn]}
+		if idColumn >= 0 && idColumn < len(row) && row[idColumn] != "" {
+			item.id = row[idColumn]
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// where the results of a batch go: a directory with a markdown file per input, or a .jsonl file with a line per input
+type batchOutput struct {
+	dir      string
+	file     *os.File
+	mu       sync.Mutex
+	finished map[string]bool // ids that already have a result in the .jsonl file
+}
+
+func newBatchOutput(path string) (*batchOutput, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+		return &batchOutput{dir: path}, nil
+	}
+	out := &batchOutput{finished: map[string]bool{}}
+	// results of an earlier run. only the inputs that succeeded count as finished
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+		for scanner.Scan() {
+			var result batchResult
+			if json.Unmarshal(scanner.Bytes(), &result) == nil && result.Error == "" {
+				out.finished[result.ID] = true
+			}
+		}
+		existing.Close()
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	out.file = file
+	return out, nil
+}
+
+// output file of an input in the output directory. the id's directories are kept, so inputs with the same name in different directories don't collide
+func (o *batchOutput) outputPath(id string) string {
+	id = strings.ReplaceAll(id, "..", "_")
+	return filepath.Join(o.dir, filepath.FromSlash(id)+".md")
+}
+
+func (o *batchOutput) done(id string) bool {
+	if o.file != nil {
+		return o.finished[id]
+	}
+	_, err := os.Stat(o.outputPath(id))
+	return err == nil
+}
+
+func (o *batchOutput) write(id string, response chat.Response) error {
+	if o.file != nil {
+		return o.appendResult(batchResult{
+			ID:        id,
+			Time:      time.Now(),
+			Model:     response.QualifiedModel(),
+			Text:      response.Text,
+			Usage:     response.Usage,
+			LatencyMs: response.Latency.Milliseconds(),
+		})
+	}
+	path := o.outputPath(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// written under another name first, so a crash never leaves a partial output that would be taken as finished
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(response.Text), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// failures are recorded in a .jsonl file so they can be found. they are tried again on the next run
+func (o *batchOutput) writeError(id string, err error) {
+	if o.file == nil {
+		return
+	}
+	if writeErr := o.appendResult(batchResult{ID: id, Time: time.Now(), Error: err.Error()}); writeErr != nil {
+		utils.LogWarning(fmt.Errorf("could not record the failure of %s: %v", id, writeErr))
+	}
+}
+
+func (o *batchOutput) appendResult(result batchResult) error {
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err = o.file.Write(append(line, '\n'))
+	return err
+}
+
+func (o *batchOutput) close() {
+	if o.file != nil {
+		o.file.Close()
+	}
+}
+
+// sends the inputs to the provider's batch api as one job. the results are written to the output by --batch-download once the provider is done
+func submitBatch(activeChat chat.Chat, items []batchItem, flags flags.Flags) error {
+	requests := make([]chat.BatchRequest, 0, len(items))
+	for _, item := range items {
+		message, err := batchMessage(item, flags.Message)
+		if err != nil {
+			return fmt.Errorf("%s: %v", item.id, err)
+		}
+		requests = append(requests, chat.BatchRequest{ID: item.id, Pattern: activeChat.Pattern, Message: message})
+	}
+	// the job can be downloaded from any directory
+	output, err := filepath.Abs(flags.Output)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	job, err := activeChat.SubmitBatch(ctx, requests, output)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Submitted batch %s with %d inputs to %s/%s\n", job.ID, len(requests), job.Provider, job.Model)
+	fmt.Printf("See how far it is with --batch-jobs and write the results to %s with --batch-download %s\n", output, job.ID)
+	return nil
+}
+
+// lists the jobs sent with --batch-api. the provider is asked about the ones that are still running
+func listBatchJobs() error {
+	jobs, err := chat.ListBatchJobs()
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		fmt.Println("No batch jobs. Send one with --batch and --batch-api")
+		return nil
+	}
+	activeChat, err := configuredChat()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tMODEL\tSUBMITTED\tSTATE\tDONE\tFAILED\tOUTPUT")
+	for i := range jobs {
+		job := &jobs[i]
+		if !job.Status.Done() {
+			if err := activeChat.RefreshBatch(ctx, job); err != nil {
+				utils.LogWarning(fmt.Errorf("could not get the status of %s: %v", job.ID, err))
+			}
+		}
+		state := job.Status.State
+		if job.Downloaded {
+			state += ", downloaded"
+		}
+		fmt.Fprintf(w, "%s\t%s/%s\t%s\t%s\t%d/%d\t%d\t%s\n", job.ID, job.Provider, job.Model, job.Created.Local().Format("2006-01-02 15:04"), state, job.Status.Succeeded, job.Status.Total, job.Status.Failed, job.Output)
+	}
+	return w.Flush()
+}
+
+// writes the results of a job that the provider is done with to the output it was submitted with. inputs that already have an output are left alone
+func downloadBatch(id string) error {
+	job, err := chat.LoadBatchJob(id)
+	if err != nil {
+		return err
+	}
+	activeChat, err := configuredChat()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := activeChat.RefreshBatch(ctx, &job); err != nil {
+		return err
+	}
+	switch {
+	case job.Status.State == chat.BatchFailed:
+		return fmt.Errorf("batch %s failed: %s", job.ID, job.Status.Error)
+	case !job.Status.Done():
+		return fmt.Errorf("batch %s is still running, %d of %d inputs are done. Try again later", job.ID, job.Status.Succeeded+job.Status.Failed, job.Status.Total)
+	}
+	results, err := activeChat.BatchResults(ctx, &job)
+	if err != nil {
+		return err
+	}
+	out, err := newBatchOutput(job.Output)
+	if err != nil {
+		return err
+	}
+	defer out.close()
+	written, failed := 0, 0
+	for _, result := range results {
+		if out.done(result.ID) {
+			continue
+		}
+		err := result.Err
+		if err == nil {
+			err = out.write(result.ID, result.Response)
+		} else {
+			out.writeError(result.ID, err)
+		}
+		if err != nil {
+			failed++
+			utils.LogWarning(fmt.Errorf("%s failed: %v", result.ID, err))
+			continue
+		}
+		written++
+	}
+	fmt.Printf("Wrote %d results of batch %s to %s\n", written, job.ID, job.Output)
+	if missing := len(job.Requests) - len(results); missing > 0 {
+		failed += missing
+		utils.LogWarning(fmt.Errorf("%d inputs have no result because the batch was %s", missing, job.Status.State))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d inputs failed. Run the same --batch again to do them", failed, len(job.Requests))
+	}
+	return nil
+}
+
+// a chat that only has the configuration, for talking to the providers about jobs
+func configuredChat() (chat.Chat, error) {
+	config, err := db.GetConfiguration()
+	if err != nil {
+		return chat.Chat{}, err
+	}
+	return chat.Chat{Config: config.Config}, nil
+}
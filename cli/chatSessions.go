@@ -3,60 +3,200 @@ package cli
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/xssdoctor/gofabric/chat"
 	"github.com/xssdoctor/gofabric/db"
 )
 
-// updates the session with the user input and the response from the LLM
-func UpdateSession(name string, userInput string, llmResponse string) error {
-	messageList := []map[string]string{{
-		"Role":    "user",
-		"Content": userInput,
+// appends the messages to the session, creating the session if it does not exist yet
+func UpdateSession(name string, messages ...chat.Message) error {
+	e := db.Entry{
+		Name: name,
+	}
+	session, err := e.GetSession()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	session = append(session, messages...)
+	if err := e.SaveSession(session); err != nil {
+		return errors.New("could not save session")
+	}
+	return nil
+}
+
+// builds the user and assistant messages of a single turn. the attached images are kept with the user message so later turns can still refer to them
+func sessionTurn(userInput string, attachments []chat.Attachment, pattern string, response chat.Response) []chat.Message {
+	now := time.Now()
+	var turnUsage *chat.Usage
+	if !response.Usage.IsZero() {
+		turnUsage = &response.Usage
+	}
+	return []chat.Message{{
+		Role:        chat.RoleUser,
+		Content:     userInput,
+		Timestamp:   now,
+		Pattern:     pattern,
+		Attachments: attachments,
 	}, {
-		"Role":    "system",
-		"Content": llmResponse,
+		Role:      chat.RoleAssistant,
+		Content:   response.Text,
+		Timestamp: now,
+		Model:     response.QualifiedModel(),
+		Pattern:   pattern,
+		Usage:     turnUsage,
 	},
+	}
 }
+
+// reads a session. sessions saved by older versions are migrated to the current format
+func getSession(name string) ([]chat.Message, error) {
 	e := db.Entry{
-		Name:    name,
+		Name: name,
 	}
-	sessionEntry, err := e.GetSessionByName()
+	session, err := e.GetSession()
 	if err != nil {
-		jsonString, err := json.Marshal(messageList)
-		if err != nil {
-			return errors.New("could not marshal message list")
+		if errors.Is(err, os.ErrNotExist) {
+			return []chat.Message{}, errors.New("could not get session by name")
 		}
-		e.Session = string(jsonString)
-		e.InsertSession()
-		return nil
+		return nil, errors.New("could not read session: " + err.Error())
 	}
-	var session []map[string]string
-	err = json.Unmarshal([]byte(sessionEntry.Session), &session)
+	return session, nil
+}
+
+// prints a session as a readable transcript
+func showSession(name string) error {
+	session, err := getSession(name)
 	if err != nil {
-		return errors.New("could not unmarshal session")
+		return err
 	}
-	session = append(session, messageList...)
-	jsonString, err := json.Marshal(session)
-	if err != nil {
-		return errors.New("could not marshal session")
+	fmt.Print(formatSessionText(session))
+	return nil
+}
+
+func deleteSession(name string) error {
+	e := db.Entry{
+		Name: name,
 	}
-	e.Session = string(jsonString)
-	e.InsertSession()
+	if err := e.DeleteSession(); err != nil {
+		return fmt.Errorf("could not delete session %s: %v", name, err)
+	}
+	fmt.Println("Session deleted")
 	return nil
 }
 
-func getSession(name string) ([]map[string]string, error) {
+func renameSession(name string, newName string) error {
+	if newName == "" {
+		return errors.New("give the new name with --to")
+	}
 	e := db.Entry{
 		Name: name,
 	}
-	sessionEntry, err := e.GetSessionByName()
-	if err != nil {
-		return []map[string]string{}, errors.New("could not get session by name")
+	if err := e.RenameSession(newName); err != nil {
+		return fmt.Errorf("could not rename session %s: %v", name, err)
 	}
-	var session []map[string]string
-	err = json.Unmarshal([]byte(sessionEntry.Session), &session)
+	fmt.Println("Session renamed to", newName)
+	return nil
+}
+
+// copies the first n messages of a session into a new session
+func forkSession(name string, n int, newName string) error {
+	if newName == "" {
+		return errors.New("give the name of the new session with --to")
+	}
+	if n == 0 {
+		return errors.New("give the number of messages to keep with --at")
+	}
+	e := db.Entry{
+		Name: name,
+	}
+	if err := e.ForkSession(n, newName); err != nil {
+		return fmt.Errorf("could not fork session %s: %v", name, err)
+	}
+	fmt.Printf("Forked the first %d messages of %s into %s\n", n, name, newName)
+	return nil
+}
+
+// exports a session as markdown, json or text. it is printed unless an output file is given
+func exportSession(name string, format string, output string) error {
+	session, err := getSession(name)
 	if err != nil {
-		return nil, errors.New("could not unmarshal session")
+		return err
 	}
-	return session, nil
-}
\ No newline at end of file
+	var exported string
+	switch strings.ToLower(format) {
+	case "markdown", "md":
+		exported = formatSessionMarkdown(name, session)
+	case "json":
+		contents, err := json.MarshalIndent(session, "", "  ")
+		if err != nil {
+			return errors.New("could not marshal session")
+		}
+		exported = string(contents) + "\n"
+	case "text", "txt":
+		exported = formatSessionText(session)
+	default:
+		return fmt.Errorf("unknown format %s. Use markdown, json or text", format)
+	}
+	if output != "" {
+		return os.WriteFile(output, []byte(exported), 0644)
+	}
+	fmt.Print(exported)
+	return nil
+}
+
+// heading shown above each message, e.g. "assistant (ollama/llama3) 2024-05-01 10:30"
+func messageHeading(message chat.Message) string {
+	heading := message.Role
+	if message.Model != "" {
+		heading += " (" + message.Model + ")"
+	}
+	if !message.Timestamp.IsZero() {
+		heading += " " + message.Timestamp.Local().Format("2006-01-02 15:04")
+	}
+	return heading
+}
+
+func formatSessionText(session []chat.Message) string {
+	var builder strings.Builder
+	for _, message := range session {
+		heading := messageHeading(message)
+		// a hand edited session can have messages without a role
+		if heading != "" {
+			heading = strings.ToUpper(heading[:1]) + heading[1:]
+		}
+		builder.WriteString(heading + ":\n")
+		if len(message.Attachments) > 0 {
+			builder.WriteString("[attached: " + attachmentNames(message) + "]\n")
+		}
+		builder.WriteString(strings.TrimSpace(message.Content) + "\n\n")
+	}
+	return builder.String()
+}
+
+func formatSessionMarkdown(name string, session []chat.Message) string {
+	var builder strings.Builder
+	builder.WriteString("# " + name + "\n\n")
+	for _, message := range session {
+		builder.WriteString("## " + messageHeading(message) + "\n\n")
+		if message.Pattern != "" {
+			builder.WriteString("_Pattern: " + message.Pattern + "_\n\n")
+		}
+		if len(message.Attachments) > 0 {
+			builder.WriteString("_Attached: " + attachmentNames(message) + "_\n\n")
+		}
+		builder.WriteString(strings.TrimSpace(message.Content) + "\n\n")
+	}
+	return builder.String()
+}
+
+func attachmentNames(message chat.Message) string {
+	names := make([]string, 0, len(message.Attachments))
+	for _, attachment := range message.Attachments {
+		names = append(names, attachment.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,19 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+func TestFormatSessionText(t *testing.T) {
+	session := []chat.Message{
+		{Role: chat.RoleUser, Content: "hi "},
+		{Role: chat.RoleAssistant, Model: "openai/gpt-4o", Content: "hello"},
+		{Content: "a message without a role"},
+	}
+	want := "User:\nhi\n\nAssistant (openai/gpt-4o):\nhello\n\n:\na message without a role\n\n"
+	if got := formatSessionText(session); got != want {
+		t.Errorf("the session is\n%q\nwant\n%q", got, want)
+	}
+}
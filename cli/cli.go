@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/xssdoctor/gofabric/db"
 	"github.com/xssdoctor/gofabric/flags"
@@ -59,7 +60,7 @@ func Cli() (string, error){
 		return "", nil
 	}
 	if Flags.ListAllModels { // if the list all models flag is set, run the list all models function
-		err = listAllModels()
+		err = listAllModels(Flags.RefreshModels)
 		if err != nil {
 			return "", err
 		}
@@ -80,6 +81,83 @@ func Cli() (string, error){
 		}
 		return "", nil
 	}
+	if Flags.Usage { // if the usage flag is set, print the usage report
+		err = usageReport()
+		if err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	if Flags.ShowSession != "" { // if the show session flag is set, print the session as a transcript
+		err = showSession(Flags.ShowSession)
+		if err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	if Flags.DeleteSession != "" {
+		err = deleteSession(Flags.DeleteSession)
+		if err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	if Flags.RenameSession != "" {
+		err = renameSession(Flags.RenameSession, Flags.To)
+		if err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	if Flags.ForkSession != "" {
+		err = forkSession(Flags.ForkSession, Flags.At, Flags.To)
+		if err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	if Flags.ExportSession != "" { // if the export session flag is set, print the session or write it to the output file
+		err = exportSession(Flags.ExportSession, Flags.Format, Flags.Output)
+		if err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	if Flags.BatchJobs { // if the batch jobs flag is set, list the batches sent to the providers
+		err = listBatchJobs()
+		if err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	if Flags.BatchDownload != "" { // if the batch download flag is set, write the results of the batch to its output
+		err = downloadBatch(Flags.BatchDownload)
+		if err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	if Flags.Embed { // if the embed flag is set, print the embeddings of the input instead of sending it to a pattern
+		err = runEmbed(Flags)
+		if err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	if Flags.Batch != "" { // if the batch flag is set, run the pattern over every input of the batch
+		err = runBatch(Flags)
+		if err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	if Flags.Compare != "" || strings.Contains(Flags.Model, ",") { // if several models are given, send the message to all of them and compare the answers
+		err = compareModels(Flags)
+		if err != nil {
+			return "", err
+		}
+		return "", nil
+	}
 	if Flags.Interactive {
 		interactive.Interactive()
 	} // if the interactive flag is set, run the interactive function
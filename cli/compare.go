@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xssdoctor/gofabric/chat"
+	"github.com/xssdoctor/gofabric/flags"
+)
+
+// one model's answer in the comparison report
+type comparisonResult struct {
+	Model        string     `json:"model"`                 // model as it was asked for
+	AnsweredBy   string     `json:"answered_by,omitempty"` // provider/model that answered
+	LatencyMs    int64      `json:"latency_ms"`
+	Usage        chat.Usage `json:"usage"`
+	Cost         float64    `json:"cost"`
+	Priced       bool       `json:"priced,omitempty"`
+	FinishReason string     `json:"finish_reason,omitempty"`
+	Refusal      string     `json:"refusal,omitempty"`
+	Text         string     `json:"text,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// the comparison report written with -o
+type comparisonReport struct {
+	Time    time.Time          `json:"time"`
+	Pattern string             `json:"pattern,omitempty"`
+	Message string             `json:"message"`
+	Results []comparisonResult `json:"results"`
+}
+
+// sends the message to every model given with --compare or -m at once, prints their answers and writes the report to the output file
+func compareModels(flags flags.Flags) error {
+	if flags.Session != "" {
+		return errors.New("--session can't be used when comparing models")
+	}
+	list := flags.Compare
+	if list == "" {
+		list = flags.Model
+	}
+	models := chat.ParseModelChain(list)
+	if len(models) == 0 {
+		return errors.New("no models to compare")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	activeChat, err := newChat(ctx, flags)
+	if err != nil {
+		return err
+	}
+	comparisons := chat.CompareModels(ctx, activeChat, models)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return errors.New("cancelled")
+	}
+
+	report := comparisonReport{Time: time.Now(), Pattern: activeChat.PatternName, Message: flags.Message}
+	failed := 0
+	for _, comparison := range comparisons {
+		result := newComparisonResult(comparison)
+		if result.Error != "" {
+			failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+	fmt.Print(formatComparisonText(report))
+	if flags.Output != "" {
+		contents, err := formatComparison(report, flags.Format)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(flags.Output, []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+	if failed == len(report.Results) {
+		return errors.New("every model failed")
+	}
+	return nil
+}
+
+func newComparisonResult(comparison chat.Comparison) comparisonResult {
+	response := comparison.Response
+	result := comparisonResult{Model: comparison.Model}
+	if comparison.Err != nil {
+		result.Error = comparison.Err.Error()
+		return result
+	}
+	result.AnsweredBy = response.QualifiedModel()
+	result.LatencyMs = response.Latency.Milliseconds()
+	result.Usage = response.Usage
+	result.Cost, result.Priced = chat.Cost(result.AnsweredBy, response.Usage)
+	result.FinishReason = response.FinishReason
+	result.Refusal = response.Refusal
+	result.Text = response.Text
+	return result
+}
+
+// formats the report as markdown, json or text
+func formatComparison(report comparisonReport, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "markdown", "md":
+		return formatComparisonMarkdown(report), nil
+	case "json":
+		contents, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return "", errors.New("could not marshal comparison")
+		}
+		return string(contents) + "\n", nil
+	case "text", "txt":
+		return formatComparisonText(report), nil
+	default:
+		return "", fmt.Errorf("unknown format %s. Use markdown, json or text", format)
+	}
+}
+
+// heading shown above each answer, e.g. "openai/gpt-4o-2024-05-13 (2.3s, 120 in, 450 out, $0.0074)"
+func comparisonHeading(result comparisonResult) string {
+	if result.Error != "" {
+		return result.Model + " (failed)"
+	}
+	usage := fmt.Sprintf("%d in, %d out", result.Usage.InputTokens, result.Usage.OutputTokens)
+	if result.Usage.Estimated {
+		usage = "~" + usage
+	}
+	cost := "unpriced"
+	if result.Priced {
+		cost = fmt.Sprintf("$%.4f", result.Cost)
+	}
+	latency := (time.Duration(result.LatencyMs) * time.Millisecond).Round(100 * time.Millisecond)
+	return fmt.Sprintf("%s (%s, %s, %s)", result.AnsweredBy, latency, usage, cost)
+}
+
+// body of each answer, which is the error when the model failed
+func comparisonBody(result comparisonResult) string {
+	if result.Error != "" {
+		return "Error: " + result.Error
+	}
+	body := strings.TrimSpace(result.Text)
+	if result.Refusal != "" {
+		body += "\n\n(refused: " + result.Refusal + ")"
+	} else if result.FinishReason == chat.FinishLength {
+		body += "\n\n(cut off at the token limit)"
+	}
+	return body
+}
+
+func formatComparisonText(report comparisonReport) string {
+	var builder strings.Builder
+	for _, result := range report.Results {
+		builder.WriteString("=== " + comparisonHeading(result) + " ===\n\n")
+		builder.WriteString(comparisonBody(result) + "\n\n")
+	}
+	return builder.String()
+}
+
+func formatComparisonMarkdown(report comparisonReport) string {
+	var builder strings.Builder
+	builder.WriteString("# Model comparison\n\n")
+	if report.Pattern != "" {
+		builder.WriteString("_Pattern: " + report.Pattern + "_\n\n")
+	}
+	builder.WriteString(report.Time.Local().Format("2006-01-02 15:04") + "\n\n")
+
+	// the summary table, aligned so it also reads well as plain text
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "| Model\t Latency\t Input tokens\t Output tokens\t Cost\t")
+	fmt.Fprintln(w, "|---\t---\t---\t---\t---\t")
+	for _, result := range report.Results {
+		if result.Error != "" {
+			fmt.Fprintf(w, "| %s\t failed\t \t \t \t\n", result.Model)
+			continue
+		}
+		cost := "unpriced"
+		if result.Priced {
+			cost = fmt.Sprintf("$%.4f", result.Cost)
+		}
+		fmt.Fprintf(w, "| %s\t %dms\t %d\t %d\t %s\t\n", result.AnsweredBy, result.LatencyMs, result.Usage.InputTokens, result.Usage.OutputTokens, cost)
+	}
+	w.Flush()
+	builder.WriteString(table.String() + "\n")
+
+	builder.WriteString("## Message\n\n" + strings.TrimSpace(report.Message) + "\n\n")
+	for _, result := range report.Results {
+		name := result.AnsweredBy
+		if name == "" {
+			name = result.Model
+		}
+		builder.WriteString("## " + name + "\n\n")
+		builder.WriteString(comparisonBody(result) + "\n\n")
+	}
+	return builder.String()
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/xssdoctor/gofabric/flags"
+	"github.com/xssdoctor/gofabric/loaders"
+	"github.com/xssdoctor/gofabric/utils"
+)
+
+// the embedding of one input, written as json
+type embedResult struct {
+	ID        string    `json:"id,omitempty"` // names the input of a batch
+	Model     string    `json:"model"`        // provider/model that made the embedding
+	Embedding []float32 `json:"embedding"`
+}
+
+// prints the embedding of the message as json, or with --batch one line of json for every input. the model is -m when it is given, otherwise EMBEDDING_MODEL. -o writes to a file instead
+func runEmbed(flags flags.Flags) error {
+	var items []batchItem
+	if flags.Batch != "" {
+		var err error
+		items, err = batchItems(flags.Batch)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return fmt.Errorf("no inputs found in %s", flags.Batch)
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	// the message is put together from stdin, --input and --url like any other
+	activeChat, err := newChat(ctx, flags)
+	if err != nil {
+		return err
+	}
+	if flags.Model != "" {
+		activeChat.Model = flags.Model
+	} else {
+		activeChat.Model = activeChat.ConfigValue("EMBEDDING_MODEL")
+	}
+	if flags.Batch == "" {
+		if strings.TrimSpace(activeChat.Message) == "" {
+			return errors.New("there is nothing to embed. Pipe the text in, or use --input, --url or --batch")
+		}
+		items = []batchItem{{text: activeChat.Message}}
+	}
+	var (
+		ids   []string
+		texts []string
+	)
+	for _, item := range items {
+		text, err := embedText(item)
+		if err != nil {
+			utils.LogWarning(fmt.Errorf("%s has no embedding: %v", item.id, err))
+			continue
+		}
+		// the providers reject empty texts
+		if strings.TrimSpace(text) == "" {
+			utils.LogWarning(fmt.Errorf("%s has no embedding: it is empty", item.id))
+			continue
+		}
+		ids = append(ids, item.id)
+		texts = append(texts, text)
+	}
+	embeddings, err := activeChat.Embed(ctx, texts)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if flags.Output != "" {
+		file, err := os.Create(flags.Output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	for i, vector := range embeddings.Vectors {
+		if err := encoder.Encode(embedResult{ID: ids[i], Model: embeddings.Model, Embedding: vector}); err != nil {
+			return err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	if skipped := len(items) - len(texts); skipped > 0 {
+		return fmt.Errorf("%d of %d inputs have no embedding", skipped, len(items))
+	}
+	return nil
+}
+
+// the text of an input of a batch. files are read like --input, without the header naming them
+func embedText(item batchItem) (string, error) {
+	if item.path == "" {
+		return item.text, nil
+	}
+	document, err := loaders.Load(item.path)
+	if err != nil {
+		return "", err
+	}
+	return document.Text, nil
+}
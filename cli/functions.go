@@ -2,10 +2,13 @@ package cli
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 
@@ -13,6 +16,8 @@ import (
 	"github.com/xssdoctor/gofabric/chat"
 	"github.com/xssdoctor/gofabric/db"
 	"github.com/xssdoctor/gofabric/flags"
+	"github.com/xssdoctor/gofabric/loaders"
+	"github.com/xssdoctor/gofabric/utils"
 )
 
 
@@ -113,23 +118,25 @@ func listAllContexts() error {
 
 }
 
-func listAllModels() error {
+func listAllModels(refresh bool) error {
 	config, err := db.GetConfiguration()
 	if err != nil {
 		return err
 	}
 	ch := chat.Chat{
-		OpenAIApiKey: config.Openai_api_key,
-		AnthropicApiKey: config.Anthropic_api_key,
-		OllamaUrl: config.Ollama_url,
-		GroqApiKey: config.Groq_api_key,
-		GoogleApiKey: config.Google_api_key,
+		Config: config.Config,
+		RefreshModels: refresh,
 	}
-	models, _ := chat.ListAllModels(ch)
+	models, _ := chat.LoadModels(context.Background(), ch)
 	for modelType, modelList := range models {
-		fmt.Println(modelType)
+		if modelList.Stale {
+			// the provider could not be reached, so these come from an older listing
+			fmt.Printf("%s (stale, last fetched %s)\n", modelType, modelList.FetchedAt.Format("2006-01-02 15:04"))
+		} else {
+			fmt.Println(modelType)
+		}
 		fmt.Println()
-		for _, model := range modelList {
+		for _, model := range modelList.Models {
 			fmt.Println(model)
 		}
 		fmt.Println()
@@ -148,14 +155,15 @@ func ListSessions() error {
 	return nil
 }
 
-func initiateChat(flags flags.Flags) (string, error) {
+// builds the chat for the flags from the configuration, the pattern and the session. ctx cancels fetching the pages of --url
+func newChat(ctx context.Context, flags flags.Flags) (chat.Chat, error) {
 	var activeModel string
 	config, err := db.GetConfiguration()
 	if err != nil {
 		if err.Error() == "Could not get configuration from database" {
-			return "", errors.New("could not get configuration from database. Please run -setup again and input your api keys, url and default model")
+			return chat.Chat{}, errors.New("could not get configuration from database. Please run -setup again and input your api keys, url and default model")
 		}
-		return "", err
+		return chat.Chat{}, err
 	}
 	if flags.Model == "" && config.Default_model == "" {
 		activeModel = "gpt-4-turbo-preview"
@@ -164,22 +172,61 @@ func initiateChat(flags flags.Flags) (string, error) {
 	} else {
 		activeModel = flags.Model
 	}
-	if flags.Url == "" {
-		flags.Url = config.Ollama_url
+	if flags.OllamaUrl != "" {
+		config.Config["OLLAMA_URL"] = flags.OllamaUrl
 	}
+	patternName := flags.Pattern
+	var fallbacks []string
+	var tools []chat.Tool
+	var schema []byte
 	if flags.Pattern != "" {
 		e := db.Entry{
 			Name: flags.Pattern,
 		}
 		r, err := e.GetPatternByName()
 		if err != nil {
-			return "", errors.New("could not find pattern")
+			return chat.Chat{}, errors.New("could not find pattern")
 		}
 		if r.Pattern != "" {
 			flags.Pattern = r.Pattern
 		}
+		// a pattern's own fallback chain replaces the global one
+		chain, err := e.GetPatternFallback()
+		if err != nil {
+			return chat.Chat{}, err
+		}
+		fallbacks = chat.ParseModelChain(chain)
+		// the tools the pattern lets the model call
+		contents, err := e.GetPatternTools()
+		if err != nil {
+			return chat.Chat{}, err
+		}
+		if contents != nil {
+			tools, err = chat.ParseTools(contents)
+			if err != nil {
+				return chat.Chat{}, err
+			}
+		}
+		// the schema the output of the pattern must match
+		schema, err = e.GetPatternSchema()
+		if err != nil {
+			return chat.Chat{}, err
+		}
+	}
+	if flags.JsonSchema != "" {
+		schema, err = os.ReadFile(flags.JsonSchema)
+		if err != nil {
+			return chat.Chat{}, err
+		}
 	}
-	var session []map[string]string
+	var outputSchema json.RawMessage
+	if schema != nil {
+		outputSchema, err = chat.ParseSchema(schema)
+		if err != nil {
+			return chat.Chat{}, err
+		}
+	}
+	var session []chat.Message
 	if flags.Session != "" {
 		ses, err := getSession(flags.Session)
 		if err != nil {
@@ -190,49 +237,148 @@ func initiateChat(flags flags.Flags) (string, error) {
 		}
 
 	}
+	// documents and pages go in front of the message, each under a header naming the file or the link
+	if len(flags.Input) > 0 || len(flags.Url) > 0 {
+		documents, err := loaders.LoadAll(flags.Input)
+		if err != nil {
+			return chat.Chat{}, err
+		}
+		for _, link := range flags.Url {
+			document, err := loaders.FetchURL(ctx, link)
+			if err != nil {
+				return chat.Chat{}, err
+			}
+			documents = append(documents, document)
+		}
+		flags.Message = loaders.Compose(documents, flags.Message)
+	}
+	var attachments []chat.Attachment
+	for _, path := range flags.Attach {
+		attachment, err := chat.LoadAttachment(path)
+		if err != nil {
+			return chat.Chat{}, err
+		}
+		attachments = append(attachments, attachment)
+	}
 	activeChat := chat.Chat{
 		Message:          flags.Message,
+		Attachments:      attachments,
 		Pattern:          flags.Pattern,
+		PatternName:      patternName,
+		SessionName:      flags.Session,
 		Context:          flags.Context,
 		Model:            activeModel,
+		Fallbacks:        fallbacks,
+		Tools:            tools,
+		ConfirmTool:      confirmTool,
+		Schema:           outputSchema,
 		Stream: 		 flags.Stream,
-		OllamaUrl:        flags.Url,
+		RefreshModels: flags.RefreshModels,
+		Timeout: flags.Timeout,
 		Temperature: 	  flags.Temperature,
 		TopP:			flags.TopP,
 		PresencePenalty: flags.PresencePenalty,
 		FrequencyPenalty: flags.FrequencyPenalty,
-		OpenAIApiKey: config.Openai_api_key,
-		AnthropicApiKey: config.Anthropic_api_key,
-		GroqApiKey: config.Groq_api_key,
-		GoogleApiKey: config.Google_api_key,
+		Config: config.Config,
 		Session: session,
-		ResponseChan: make(chan string),
+		ResponseChan: make(chan chat.StreamEvent),
 
 	}
+	if flags.AllowTools {
+		activeChat.ConfirmTool = allowTool
+	}
+	return activeChat, nil
+}
+
+func initiateChat(flags flags.Flags) (string, error) {
+	// Ctrl+C cancels the request instead of killing the program, so a streamed answer can stop cleanly. it also stops a slow fetch of --url
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	activeChat, err := newChat(ctx, flags)
+	if err != nil {
+		return "", err
+	}
+	patternName := activeChat.PatternName
+	reduce, err := reducePattern(flags.ReducePattern, activeChat)
+	if err != nil {
+		return "", err
+	}
+	// inputs too large for the model are split into chunks unless that is turned off
+	send := func() (chat.Response, error) {
+		if flags.NoChunk {
+			return activeChat.SendMessageToModel(ctx)
+		}
+		return activeChat.SendChunked(ctx, reduce)
+	}
 	message := ""
+	var response chat.Response
 	if flags.Stream {
+		errChan := make(chan error, 1)
 		go func() {
-            _, err := activeChat.SendMessageToModel()
-            if err != nil {
-                activeChat.ResponseChan <- err.Error()
-            }
+            _, err := send()
+            errChan <- err
         }()
-        // fmt.printll evetying coming from the response channel
-        for response := range activeChat.ResponseChan {
-            message += response
-            fmt.Print(response)
+        // fmt.printll evetying coming from the response channel. the channel is closed when the model is done
+        for event := range activeChat.ResponseChan {
+            if event.Done {
+                response = *event.Response
+                continue
+            }
+            message += event.Text
+            fmt.Print(event.Text)
         }
+		streamErr := <-errChan
+		if errors.Is(ctx.Err(), context.Canceled) {
+			fmt.Println()
+			return message, errors.New("cancelled")
+		}
+		if streamErr != nil {
+			fmt.Println()
+			return message, streamErr
+		}
 	} else {
-		message, err = activeChat.SendMessageToModel()
+		response, err = send()
 		if err != nil {
 			return "", err
 		}
+		message = response.Text
 	}
+	warnIfIncomplete(response)
 	if flags.Session != "" {
-		err = UpdateSession(flags.Session, flags.Message, message)
+		err = UpdateSession(flags.Session, sessionTurn(activeChat.Message, activeChat.Attachments, patternName, response)...)
 		if err != nil {
 			return "", err
 		}
 	}
 	return message, err
-}
\ No newline at end of file
+}
+
+// returns the text of the pattern that merges the results of chunked inputs, given with --reduce-pattern or REDUCE_PATTERN. it is empty when there is none, so the built in prompt is used
+func reducePattern(name string, activeChat chat.Chat) (string, error) {
+	if name == "" {
+		name = activeChat.ConfigValue("REDUCE_PATTERN")
+	}
+	if name == "" {
+		return "", nil
+	}
+	e := db.Entry{
+		Name: name,
+	}
+	r, err := e.GetPatternByName()
+	if err != nil {
+		return "", fmt.Errorf("could not find reduce pattern %s", name)
+	}
+	return r.Pattern, nil
+}
+
+// warns on stderr when the model refused to answer or its answer was cut off, and says which model answered when the chosen one failed
+func warnIfIncomplete(response chat.Response) {
+	if len(response.FallbackFrom) > 0 {
+		utils.LogWarning(fmt.Errorf("answered by %s because %s failed", response.QualifiedModel(), strings.Join(response.FallbackFrom, " and ")))
+	}
+	if response.Refusal != "" {
+		utils.LogWarning(fmt.Errorf("%s refused to answer: %s", response.QualifiedModel(), response.Refusal))
+	} else if response.Truncated() {
+		utils.LogWarning(fmt.Errorf("the response was cut off because it reached the token limit of %s", response.QualifiedModel()))
+	}
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/xssdoctor/gofabric/chat"
+	"github.com/xssdoctor/gofabric/loaders"
+)
+
+// tools that run in Go, so a pattern's tools.json only needs their names
+func init() {
+	chat.RegisterTool(chat.Tool{
+		Name:        "fetch_url",
+		Description: "Fetches a web page and returns its main content as markdown",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"url":{"type":"string","description":"http or https url of the page"}},"required":["url"]}`),
+	}, fetchURLTool)
+	chat.RegisterTool(chat.Tool{
+		Name:        "read_file",
+		Description: "Reads a pdf, docx, html, markdown, subtitle or text file and returns its text",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"path":{"type":"string","description":"path of the file"}},"required":["path"]}`),
+	}, readFileTool)
+}
+
+func fetchURLTool(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil || args.URL == "" {
+		return "", errors.New("give the url to fetch as url")
+	}
+	document, err := loaders.FetchURL(ctx, args.URL)
+	if err != nil {
+		return "", err
+	}
+	return loaders.Compose([]loaders.Document{document}, ""), nil
+}
+
+func readFileTool(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil || args.Path == "" {
+		return "", errors.New("give the file to read as path")
+	}
+	document, err := loaders.Load(args.Path)
+	if err != nil {
+		return "", err
+	}
+	return document.Text, nil
+}
+
+// calls from models that are answered at the same time, e.g. with --compare, are asked about one at a time
+var confirmMu sync.Mutex
+
+// asks on the terminal whether the model may make the call. stdin can hold the message, so the terminal is opened directly. without a terminal nothing is allowed
+func confirmTool(tool chat.Tool, call chat.ToolCall) bool {
+	confirmMu.Lock()
+	defer confirmMu.Unlock()
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s was not run: there is no terminal to ask on. Use --allow-tools to run tools without asking\n", call.Name)
+		return false
+	}
+	defer tty.Close()
+	what := tool.Command
+	if what == "" {
+		what = "built in tool"
+	}
+	fmt.Fprintf(tty, "The model wants to run %s (%s) with %s\nRun it? [y/N] ", call.Name, what, string(call.Arguments))
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// lets every call run
+func allowTool(tool chat.Tool, call chat.ToolCall) bool {
+	return true
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// totals of one row in the usage report
+type usageTotal struct {
+	requests     int
+	inputTokens  int
+	outputTokens int
+	cost         float64
+	unpriced     int // requests to models without a price
+}
+
+// prints the tokens used and their cost from the usage log, broken down by pattern, model, session and date
+func usageReport() error {
+	records, err := chat.ReadUsage()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Println("No usage recorded yet")
+		return nil
+	}
+	groupings := []struct {
+		title string
+		key   func(chat.UsageRecord) string
+	}{
+		{"Pattern", func(r chat.UsageRecord) string { return orNone(r.Pattern) }},
+		{"Model", func(r chat.UsageRecord) string { return r.Model }},
+		{"Session", func(r chat.UsageRecord) string { return orNone(r.Session) }},
+		{"Date", func(r chat.UsageRecord) string { return r.Time.Local().Format("2006-01-02") }},
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, grouping := range groupings {
+		totals := make(map[string]*usageTotal)
+		for _, record := range records {
+			key := grouping.key(record)
+			if totals[key] == nil {
+				totals[key] = &usageTotal{}
+			}
+			totals[key].add(record)
+		}
+		keys := make([]string, 0, len(totals))
+		for key := range totals {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "%s\tRequests\tInput tokens\tOutput tokens\tCost\n", grouping.title)
+		for _, key := range keys {
+			totals[key].print(w, key)
+		}
+		fmt.Fprintln(w)
+	}
+	total := &usageTotal{}
+	for _, record := range records {
+		total.add(record)
+	}
+	total.print(w, "Total")
+	return w.Flush()
+}
+
+func (t *usageTotal) add(record chat.UsageRecord) {
+	t.requests++
+	t.inputTokens += record.Usage.InputTokens
+	t.outputTokens += record.Usage.OutputTokens
+	t.cost += record.Cost
+	if !record.Priced {
+		t.unpriced++
+	}
+}
+
+func (t *usageTotal) print(w *tabwriter.Writer, name string) {
+	cost := fmt.Sprintf("$%.4f", t.cost)
+	if t.unpriced > 0 {
+		// some of the requests went to models without a price, so the real cost is higher
+		cost += fmt.Sprintf(" (%d unpriced)", t.unpriced)
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", name, t.requests, t.inputTokens, t.outputTokens, cost)
+}
+
+func orNone(name string) string {
+	if name == "" {
+		return "(none)"
+	}
+	return name
+}
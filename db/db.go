@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/xssdoctor/gofabric/chat"
+	"github.com/xssdoctor/gofabric/utils"
 )
 
 var DB *sql.DB
@@ -20,11 +22,7 @@ type Entry struct {
 	Pattern string
 	Context string
 	Session string
-	Openai_api_key string
-	Anthropic_api_key string
-	Groq_api_key string
-	Ollama_url string
-	Google_api_key string
+	Config map[string]string // provider configuration, keyed by the names in chat.ConfigKeys
 	Default_model string
 }
 
@@ -56,54 +54,49 @@ func InitDB() error {
             return err
         }
     }
+    // sessions saved by older versions are rewritten in the current format. a session that can't be migrated is left as it is
+    if err := MigrateSessions(); err != nil {
+        utils.LogWarning(fmt.Errorf("could not migrate sessions: %v", err))
+    }
 
     return nil
 }
 
 // runs the initial setup of the program. this includes entering the api keys and default models
 func InitialRun() (error) {
-	var openAi, anthropic, ollama, model, groq, google string
-	// enters the OpenAI, Anthropic, Groq api keys, and ollama base url
-	e := Entry{}
-	fmt.Println("Enter your OpenAI API key: (Leave blank if you don't have one)")
-	fmt.Scanln(&openAi)
-	e.Openai_api_key = strings.TrimRight(openAi, "\n")
-	fmt.Println("Enter your Anthropic API key: (Leave blank if you don't have one)")
-	fmt.Scanln(&anthropic)
-	e.Anthropic_api_key = strings.TrimRight(anthropic, "\n")
-	fmt.Println("Enter your google API key: (Leave blank if you don't have one)")
-	fmt.Scanln(&google)
-	e.Google_api_key = strings.TrimRight(google, "\n")
-	fmt.Println("Enter your Groq API key: (Leave blank if you don't have one)")
-	fmt.Scanln(&groq)
-	e.Groq_api_key = strings.TrimRight(groq, "\n")
-	fmt.Println("Enter your Ollama URL: (leave blank if you don't have one or if you want the default of localhost:11434)")
-	fmt.Scanln(&ollama)
-	e.Ollama_url = strings.TrimRight(ollama, "\n")
-	if e.Ollama_url == "" {
-		e.Ollama_url = "http://127.0.0.1:11434" // this is the default value for the ollama url
-	
+	var model string
+	// enters the api keys and urls of every registered provider
+	e := Entry{Config: make(map[string]string)}
+	for _, key := range chat.ConfigKeys() {
+		var value string
+		if key.Prompt != "" {
+			fmt.Println(key.Prompt)
+			fmt.Scanln(&value)
+		}
+		value = strings.TrimRight(value, "\n")
+		if value == "" {
+			value = key.Default // this is the default value for the key, e.g. the ollama url
+		}
+		e.Config[key.Name] = value
 	}
 	fmt.Println()
 	fmt.Println()
 	chatInstance := chat.Chat{ // creates a blank chat instance with the api keys, the purpose of this is to list all the models
-		OpenAIApiKey: e.Openai_api_key,
-		AnthropicApiKey: e.Anthropic_api_key,
-		OllamaUrl: e.Ollama_url,
-		GoogleApiKey: e.Google_api_key,
+		Config: e.Config,
+		RefreshModels: true, // the keys are new, so the cached lists can't be trusted
 	}
-	models, _ := chat.ListAllModels(chatInstance) // lists all the models for each of the three services, returns a map[string]string of the models
+	models, _ := chat.LoadModels(context.Background(), chatInstance) // lists all the models for each of the providers, returns a map of the models
 	for key, value := range models {
 		fmt.Println(key)
 		fmt.Println()
-		for _, model := range value {
+		for _, model := range value.Models {
 			fmt.Println(model)
 		}
 		fmt.Println()
 	
 	}
 	// from the listed models, the user is prompted to choose a default model
-	fmt.Println("Enter your default model: Choose from the above options")
+	fmt.Println("Enter your default model: Choose from the above options. Use provider/model, e.g. ollama/llama3, to pick the provider yourself")
 	fmt.Scanln(&model)
 	e.Default_model = strings.TrimRight(model, "\n")
 	err := e.InsertConfiguration() // takes the Entry struct which includes all relivant api keys and default models and inserts it into the database
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"regexp"
 
+	"github.com/xssdoctor/gofabric/chat"
 	"github.com/xssdoctor/gofabric/utils"
 )
 
@@ -18,14 +20,13 @@ func createTables() {
 	fileName:= filepath.Join(usrHome, ".config/fabric/.env")
 	// check if file exist
 	if _, err := os.Stat(fileName); os.IsNotExist(err) {
-		// create file
-		fileContents := []byte(`CLAUDE_API_KEY=
-OPENAI_API_KEY=
-GROQ_API_KEY=
-GOOGLE_API_KEY=
-OLLAMA_URL=
-DEFAULT_MODEL=`)
-		os.WriteFile(fileName, fileContents, 0644)
+		// create file with a blank line for every key the registered providers need
+		fileContents := ""
+		for _, key := range chat.ConfigKeys() {
+			fileContents += key.Name + "=\n"
+		}
+		fileContents += "DEFAULT_MODEL="
+		os.WriteFile(fileName, []byte(fileContents), 0644)
 	}
 }
 
@@ -112,7 +113,6 @@ func ListAllSessions() ([]Entry, error) {
 
 // finds all configurations in the .env file and enters the id, name, and configuration into a slice of Entry structs. it returns these entries or an error
 func GetConfiguration() (Entry, error) {
-	var claudeKey, openaiKey, groqKey, googleKey, ollamaUrl string
 	usrHome, err := os.UserHomeDir()
 	if err != nil {
 		return Entry{}, err
@@ -122,44 +122,20 @@ func GetConfiguration() (Entry, error) {
 	if _, err := os.Stat(fileName); os.IsNotExist(err) {
 		createTables()
 	}
-	claudepattern := `CLAUDE_API_KEY=(.*)\n`
-	openaiPattern := `OPENAI_API_KEY=(.*)\n`
-	groqPattern := `GROQ_API_KEY=(.*)\n`
-	googlePattern := `GOOGLE_API_KEY=(.*)\n`
-	ollamaUrlPattern := `OLLAMA_URL=(.*)\n`
-	defaultModel := `DEFAULT_MODEL=(.*)\n`
-	claudeKey, err = utils.FindRegex(claudepattern, fileName)
-	if err != nil {
-		return Entry{}, err
-	
-	}
-	openaiKey, err = utils.FindRegex(openaiPattern, fileName)
-	if err != nil {
-		return Entry{}, err
-	}
-	groqKey, err = utils.FindRegex(groqPattern, fileName)
-	if err != nil {
-		return Entry{}, err
-	}
-	googleKey, err = utils.FindRegex(googlePattern, fileName)
-	if err != nil {
-		return Entry{}, err
-	}
-	ollamaUrl, err = utils.FindRegex(ollamaUrlPattern, fileName)
-	if err != nil {
-		return Entry{}, err
+	en := Entry{Config: make(map[string]string)}
+	// reads every key that the registered providers need
+	for _, key := range chat.ConfigKeys() {
+		value, err := utils.FindRegex(`(?m)^`+regexp.QuoteMeta(key.Name)+`=(.*)\n`, fileName) // anchored, so no key matches the end of a longer one
+		if err != nil {
+			return Entry{}, err
+		}
+		en.Config[key.Name] = value
 	}
-	defaultModel, err = utils.FindRegex(defaultModel, fileName)
+	defaultModel, err := utils.FindRegex(`(?m)^DEFAULT_MODEL=(.*)\n`, fileName)
 	if err != nil {
 		return Entry{}, err
 	}
-	en := Entry{}
-	en.Openai_api_key = openaiKey
-	en.Anthropic_api_key = claudeKey
-	en.Ollama_url = ollamaUrl
 	en.Default_model = defaultModel
-	en.Groq_api_key = groqKey
-	en.Google_api_key = googleKey
 	return en, nil
 }
 
@@ -219,60 +195,22 @@ func (e *Entry) InsertSession() error {
 
 // inserts or replaces the configuration in the .env file
 func (e *Entry) InsertConfiguration() error {
-	err := utils.InsertIntoConfiguration("OPENAI_API_KEY", e.Openai_api_key, createTables)
-	if err != nil {
-		return err
-	}
-	err = utils.InsertIntoConfiguration("CLAUDE_API_KEY", e.Anthropic_api_key, createTables)
-	if err != nil {
-		return err
-	}
-	err = utils.InsertIntoConfiguration("OLLAMA_URL", e.Ollama_url, createTables)
-	if err != nil {
-		return err
-	}
-	err = utils.InsertIntoConfiguration("GOOGLE_API_KEY", e.Google_api_key, createTables)
-	if err != nil {
-		return err
-	}
-	err = utils.InsertIntoConfiguration("DEFAULT_MODEL", e.Default_model, createTables)
-	if err != nil {
-		return err
-	}
-	err = utils.InsertIntoConfiguration("GROQ_API_KEY", e.Groq_api_key, createTables)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (e *Entry) InsertOpenaiApiKey() error {
-	err := utils.InsertIntoConfiguration("OPENAI_API_KEY", e.Openai_api_key, createTables)
-	if err != nil {
-		return err
-	}
-	return nil
-	
-}
-
-func (e *Entry) InsertAnthropicApiKey() error {
-	err := utils.InsertIntoConfiguration("CLAUDE_API_KEY", e.Anthropic_api_key, createTables)
-	if err != nil {
-		return err
+	for _, key := range chat.ConfigKeys() {
+		err := e.InsertConfigurationKey(key.Name)
+		if err != nil {
+			return err
+		}
 	}
-	return nil
-}
-
-func (e *Entry) InsertOllamaUrl() error {
-	err := utils.InsertIntoConfiguration("OLLAMA_URL", e.Ollama_url, createTables)
+	err := utils.InsertIntoConfiguration("DEFAULT_MODEL", e.Default_model, createTables)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (e *Entry) InsertGoogleApiKey() error {
-	err := utils.InsertIntoConfiguration("GOOGLE_API_KEY", e.Google_api_key, createTables)
+// inserts or replaces a single provider key in the .env file
+func (e *Entry) InsertConfigurationKey(name string) error {
+	err := utils.InsertIntoConfiguration(name, e.Config[name], createTables)
 	if err != nil {
 		return err
 	}
@@ -287,36 +225,14 @@ func (e *Entry) InsertDefaultModel() error {
 	return nil
 }
 
-func (e *Entry) InsertGroqApiKey() error {
-	err := utils.InsertIntoConfiguration("GROQ_API_KEY", e.Groq_api_key, createTables)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func (e *Entry) UpdateConfiguration() error {
-	err := utils.InsertIntoConfiguration("OPENAI_API_KEY", e.Openai_api_key, createTables)
-	if err != nil {
-		return err
-	}
-	err = utils.InsertIntoConfiguration("CLAUDE_API_KEY", e.Anthropic_api_key, createTables)
-	if err != nil {
-		return err
-	}
-	err = utils.InsertIntoConfiguration("OLLAMA_URL", e.Ollama_url, createTables)
-	if err != nil {
-		return err
-	}
-	err = utils.InsertIntoConfiguration("GOOGLE_API_KEY", e.Google_api_key, createTables)
-	if err != nil {
-		return err
-	}
-	err = utils.InsertIntoConfiguration("DEFAULT_MODEL", e.Default_model, createTables)
-	if err != nil {
-		return err
+	for _, key := range chat.ConfigKeys() {
+		err := e.InsertConfigurationKey(key.Name)
+		if err != nil {
+			return err
+		}
 	}
-	err = utils.InsertIntoConfiguration("GROQ_API_KEY", e.Groq_api_key, createTables)
+	err := utils.InsertIntoConfiguration("DEFAULT_MODEL", e.Default_model, createTables)
 	if err != nil {
 		return err
 	}
@@ -381,4 +297,55 @@ func (e *Entry) GetPatternByName() (Entry, error) {
     en.Name = e.Name
 	en.Pattern = string(pattern)
     return en, nil
-}
\ No newline at end of file
+}
+
+// finds the fallback chain of a pattern, kept in fallback.txt next to its system.md, e.g. groq/llama3-70b-8192 -> openai/gpt-4o. patterns without one return an empty string
+func (e *Entry) GetPatternFallback() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	fallback_path := filepath.Join(homeDir, ".config/fabric/patterns", e.Name, "fallback.txt")
+	fallback, err := os.ReadFile(fallback_path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(fallback), nil
+}
+
+// reads the json schema the output of a pattern must match, kept in schema.json next to its system.md. patterns without one return nil
+func (e *Entry) GetPatternSchema() ([]byte, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	schema_path := filepath.Join(homeDir, ".config/fabric/patterns", e.Name, "schema.json")
+	schema, err := os.ReadFile(schema_path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return schema, nil
+}
+
+// reads the tools of a pattern, kept in tools.json next to its system.md. patterns without one return nil
+func (e *Entry) GetPatternTools() ([]byte, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	tools_path := filepath.Join(homeDir, ".config/fabric/patterns", e.Name, "tools.json")
+	tools, err := os.ReadFile(tools_path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return tools, nil
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// version of the session file format that is written. bump it and add a migration when the format changes
+const SessionVersion = 2
+
+// SessionFile is the format of the files in ~/.config/fabric/sessions
+type SessionFile struct {
+	Version  int            `json:"version"`
+	Messages []chat.Message `json:"messages"`
+}
+
+// migrations turn a session of one version into the next. the key is the version the migration starts from
+var sessionMigrations = map[int]func(SessionFile) SessionFile{
+	1: migrateSessionV1,
+}
+
+// version 1 was a bare list of {"Role", "Content"} maps, and the replies were saved under the system role
+func migrateSessionV1(session SessionFile) SessionFile {
+	for i, message := range session.Messages {
+		role := strings.ToLower(message.Role)
+		if role == chat.RoleSystem {
+			role = chat.RoleAssistant
+		}
+		session.Messages[i].Role = role
+	}
+	session.Version = 2
+	return session
+}
+
+// ParseSession reads the contents of a session file, migrating older versions to the current one. migrated is true when the contents were in an older format
+func ParseSession(contents []byte) (session SessionFile, migrated bool, err error) {
+	trimmed := bytes.TrimSpace(contents)
+	if len(trimmed) == 0 {
+		return SessionFile{Version: SessionVersion}, false, nil
+	}
+	if trimmed[0] == '[' {
+		// version 1 files have no header. the field names match chat.Message regardless of case
+		session.Version = 1
+		if err := json.Unmarshal(trimmed, &session.Messages); err != nil {
+			return SessionFile{}, false, err
+		}
+	} else if err := json.Unmarshal(trimmed, &session); err != nil {
+		return SessionFile{}, false, err
+	}
+	if session.Version > SessionVersion {
+		return SessionFile{}, false, fmt.Errorf("session was written by a newer version of fabric (version %d)", session.Version)
+	}
+	for session.Version < SessionVersion {
+		migrate, ok := sessionMigrations[session.Version]
+		if !ok {
+			return SessionFile{}, false, fmt.Errorf("no migration for session version %d", session.Version)
+		}
+		session = migrate(session)
+		migrated = true
+	}
+	return session, migrated, nil
+}
+
+// GetSession reads the session named e.Name and returns its messages. files in an older format are rewritten in the current one
+func (e *Entry) GetSession() ([]chat.Message, error) {
+	sessionEntry, err := e.GetSessionByName()
+	if err != nil {
+		return nil, err
+	}
+	session, migrated, err := ParseSession([]byte(sessionEntry.Session))
+	if err != nil {
+		return nil, err
+	}
+	if migrated {
+		if err := e.SaveSession(session.Messages); err != nil {
+			return nil, err
+		}
+	}
+	return session.Messages, nil
+}
+
+// SaveSession writes the messages to the session named e.Name in the current format
+func (e *Entry) SaveSession(messages []chat.Message) error {
+	if messages == nil {
+		messages = []chat.Message{}
+	}
+	contents, err := json.MarshalIndent(SessionFile{Version: SessionVersion, Messages: messages}, "", "  ")
+	if err != nil {
+		return err
+	}
+	sessionEntry := Entry{Name: e.Name, Session: string(contents)}
+	return sessionEntry.InsertSession()
+}
+
+// path of a session file. names are plain file names, so they can't point outside the sessions directory
+func sessionPath(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid session name %q", name)
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "fabric", "sessions", name), nil
+}
+
+// DeleteSession removes the session named e.Name
+func (e *Entry) DeleteSession() error {
+	path, err := sessionPath(e.Name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// RenameSession renames the session named e.Name to newName. it fails if a session called newName already exists
+func (e *Entry) RenameSession(newName string) error {
+	oldPath, err := sessionPath(e.Name)
+	if err != nil {
+		return err
+	}
+	newPath, err := sessionPath(newName)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(oldPath); err != nil {
+		return err
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("session %s already exists", newName)
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// ForkSession copies the first n messages of the session named e.Name into a new session called newName
+func (e *Entry) ForkSession(n int, newName string) error {
+	messages, err := e.GetSession()
+	if err != nil {
+		return err
+	}
+	if n < 1 || n > len(messages) {
+		return fmt.Errorf("session %s has %d messages, can't fork at message %d", e.Name, len(messages), n)
+	}
+	newPath, err := sessionPath(newName)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("session %s already exists", newName)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	fork := Entry{Name: newName}
+	return fork.SaveSession(messages[:n])
+}
+
+// MigrateSessions rewrites every session in ~/.config/fabric/sessions that is in an older format. sessions that can't be read are left alone
+func MigrateSessions() error {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	sessions_dir := filepath.Join(homeDir, ".config", "fabric", "sessions")
+	sessionNames, err := os.ReadDir(sessions_dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, sessionName := range sessionNames {
+		if sessionName.IsDir() {
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join(sessions_dir, sessionName.Name()))
+		if err != nil {
+			continue
+		}
+		session, migrated, err := ParseSession(contents)
+		if err != nil || !migrated {
+			continue
+		}
+		e := Entry{Name: sessionName.Name()}
+		if err := e.SaveSession(session.Messages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// a session as the first versions of fabric saved it
+const sessionV1 = `[
+	{"Role": "User", "Content": "what is 2+2?"},
+	{"Role": "system", "Content": "4"},
+	{"role": "user", "content": "and 3+3?"}
+]`
+
+func TestParseSessionV1(t *testing.T) {
+	session, migrated, err := ParseSession([]byte(sessionV1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !migrated || session.Version != SessionVersion {
+		t.Errorf("the v1 session was read as version %d, migrated: %v", session.Version, migrated)
+	}
+	// the roles are lower case and the replies saved under the system role belong to the assistant
+	want := []chat.Message{
+		{Role: chat.RoleUser, Content: "what is 2+2?"},
+		{Role: chat.RoleAssistant, Content: "4"},
+		{Role: chat.RoleUser, Content: "and 3+3?"},
+	}
+	if !reflect.DeepEqual(session.Messages, want) {
+		t.Errorf("the messages are %+v, want %+v", session.Messages, want)
+	}
+}
+
+func TestParseSessionV2(t *testing.T) {
+	contents := `{"version": 2, "messages": [
+		{"role": "system", "content": "be brief"},
+		{"role": "user", "content": "hi", "timestamp": "2024-10-01T12:00:00Z"},
+		{"role": "assistant", "content": "hello", "model": "openai/gpt-4o", "usage": {"input_tokens": 5, "output_tokens": 1}}
+	]}`
+	session, migrated, err := ParseSession([]byte(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated {
+		t.Error("a session in the current format was migrated")
+	}
+	want := []chat.Message{
+		// a system message of version 2 is a real system message, not a reply
+		{Role: chat.RoleSystem, Content: "be brief"},
+		{Role: chat.RoleUser, Content: "hi", Timestamp: time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)},
+		{Role: chat.RoleAssistant, Content: "hello", Model: "openai/gpt-4o", Usage: &chat.Usage{InputTokens: 5, OutputTokens: 1}},
+	}
+	if !reflect.DeepEqual(session.Messages, want) {
+		t.Errorf("the messages are %+v, want %+v", session.Messages, want)
+	}
+
+	session, migrated, err = ParseSession([]byte("  \n"))
+	if err != nil || migrated || session.Version != SessionVersion || len(session.Messages) != 0 {
+		t.Errorf("an empty file was read as %+v, %v, %v, want an empty session", session, migrated, err)
+	}
+}
+
+func TestParseSessionErrors(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		contents string
+		problem  string
+	}{
+		{"newer version", `{"version": 3, "messages": [{"role": "user", "content": "hi", "thread": "t1"}]}`, "newer version of fabric (version 3)"},
+		{"no version", `{"messages": [{"role": "user", "content": "hi"}]}`, "no migration for session version 0"},
+		{"corrupt object", `{"version": 2, "messages": [{"role": "user", "content": "hi"}`, "unexpected end of JSON input"},
+		{"corrupt list", `[{"Role": "User", "Content": "hi"},]`, "invalid character"},
+		{"wrong types", `{"version": "2", "messages": []}`, "cannot unmarshal string"},
+	} {
+		session, _, err := ParseSession([]byte(test.contents))
+		if err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("%s: the error is %v, want one saying %q", test.name, err, test.problem)
+		}
+		if len(session.Messages) != 0 {
+			t.Errorf("%s: the session that failed to load has the messages %+v", test.name, session.Messages)
+		}
+	}
+}
+
+func TestGetSessionRewritesV1(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	entry := Entry{Name: "old", Session: sessionV1}
+	if err := entry.InsertSession(); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := (&Entry{Name: "old"}).GetSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || messages[1].Role != chat.RoleAssistant {
+		t.Errorf("the messages are %+v", messages)
+	}
+	contents, err := os.ReadFile(filepath.Join(home, ".config", "fabric", "sessions", "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, migrated, err := ParseSession(contents)
+	if err != nil || migrated || !reflect.DeepEqual(session.Messages, messages) {
+		t.Errorf("the file was rewritten as %s, want the migrated session in the current format", contents)
+	}
+}
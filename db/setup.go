@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -32,63 +33,40 @@ func Setup() error {
 		return errors.New("there is an error with your configuration. Delete the database and run the setup command again")
 	}
 }
-	var openAi, anthropic, ollama, model, groq, google string
-		e := Entry{}
-		fmt.Println("Enter your OpenAI API key: (Leave blank if you don't have one)")
-		fmt.Scanln(&openAi)
-		if openAi == "" {
-			openAi = config.Openai_api_key
-		
-		}
-		e.Openai_api_key = strings.TrimRight(openAi, "\n")
-		fmt.Println("Enter your Anthropic API key: (Leave blank if you don't have one)")
-		fmt.Scanln(&anthropic)
-		if anthropic == "" {
-			anthropic = config.Anthropic_api_key
-		}
-		e.Anthropic_api_key = strings.TrimRight(anthropic, "\n")
-		fmt.Println("Enter your Google API key: (Leave blank if you don't have one)")
-		fmt.Scanln(&google)
-		if google == "" {
-			google = config.Google_api_key
-		}
-		e.Google_api_key = strings.TrimRight(google, "\n")
-		fmt.Println("Enter your Groq API key: (Leave blank if you don't have one)")
-		fmt.Scanln(&groq)
-		if groq == "" {
-			groq = config.Groq_api_key
-		}
-		e.Groq_api_key = strings.TrimRight(groq, "\n")
-		fmt.Println("Enter your Ollama URL: (leave blank if you don't have one or if you want the default of localhost:11434)")
-		fmt.Scanln(&ollama)
-		if ollama == "" {
-			ollama = config.Ollama_url
-		}
-		e.Ollama_url = strings.TrimRight(ollama, "\n")
-		if e.Ollama_url == "" {
-			e.Ollama_url = "http://127.0.0.1:11434"
-		
+	var model string
+		e := Entry{Config: make(map[string]string)}
+		for _, key := range chat.ConfigKeys() {
+			var value string
+			if key.Prompt != "" {
+				fmt.Println(key.Prompt)
+				fmt.Scanln(&value)
+			}
+			if value == "" {
+				value = config.Config[key.Name] // keeps the current value if the user leaves it blank
+			}
+			value = strings.TrimRight(value, "\n")
+			if value == "" {
+				value = key.Default
+			}
+			e.Config[key.Name] = value
 		}
 		fmt.Println()
 		fmt.Println()
 		chatInstance := chat.Chat{
-			OpenAIApiKey: e.Openai_api_key,
-			AnthropicApiKey: e.Anthropic_api_key,
-			OllamaUrl: e.Ollama_url,
-			GroqApiKey: e.Groq_api_key,
-			GoogleApiKey: e.Google_api_key,
+			Config: e.Config,
+			RefreshModels: true, // the keys may have changed, so the cached lists can't be trusted
 		}
-		models, _ := chat.ListAllModels(chatInstance)
+		models, _ := chat.LoadModels(context.Background(), chatInstance)
 		for key, value := range models {
 			fmt.Println(key)
 			fmt.Println()
-			for _, model := range value {
+			for _, model := range value.Models {
 				fmt.Println(model)
 			}
 			fmt.Println()
 		
 		}
-		fmt.Println("Enter your default model: Choose from the available options")
+		fmt.Println("Enter your default model: Choose from the available options. Use provider/model, e.g. ollama/llama3, to pick the provider yourself")
 		fmt.Scanln(&model)
 		if model == "" {
 			model = config.Default_model
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"errors"
 	"os"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 )
@@ -21,17 +22,42 @@ type Flags struct {
     FrequencyPenalty float64 `short:"F" long:"frequencypenalty" description:"Set frequency penalty" default:"0.0"`
     ListPatterns     bool    `short:"l" long:"listpatterns" description:"List all patterns"`
     ListAllModels    bool    `short:"L" long:"listmodels" description:"List all available models"`
+    RefreshModels    bool    `long:"refresh-models" description:"Fetch the model lists again instead of using the cached ones"`
     ListAllContexts  bool    `short:"x" long:"listcontexts" description:"List all contexts"`
     ListAllSessions  bool    `short:"X" long:"listsessions" description:"List all sessions"`
+    ShowSession      string  `long:"showsession" description:"Print a session as a transcript"`
+    DeleteSession    string  `long:"deletesession" description:"Delete a session"`
+    RenameSession    string  `long:"renamesession" description:"Rename a session to the name given with --to"`
+    ForkSession      string  `long:"forksession" description:"Copy a session up to the message given with --at into the session given with --to"`
+    ExportSession    string  `long:"exportsession" description:"Export a session in the format given with --format. Use -o to write it to a file"`
+    To               string  `long:"to" description:"New session name for --renamesession and --forksession"`
+    At               int     `long:"at" description:"Number of messages kept by --forksession, counting from 1"`
+    Format           string  `long:"format" description:"Format for --exportsession and the --compare report: markdown, json or text" default:"markdown"`
+    Usage            bool    `long:"usage" description:"Show the tokens used and what they cost by pattern, model, session and date. Prices can be set in ~/.config/fabric/prices.json"`
     UpdatePatterns   bool    `short:"U" long:"updatepatterns" description:"Update patterns"`
     AddContext       bool `short:"A" long:"addcontext" description:"Add a context"`
     Message          string  `hidden:"true" description:"Message to send to chat"`
+    Input            []string `long:"input" description:"Read a pdf, docx, html, markdown, srt, vtt or text file and send its text before the message. Repeat it to send several"`
+    ReducePattern    string  `long:"reduce-pattern" description:"Pattern that merges the results when an input too large for the model is sent in chunks. Defaults to REDUCE_PATTERN from the .env file, or a built in prompt"`
+    NoChunk          bool    `long:"no-chunk" description:"Send inputs that are too large for the model as they are, instead of in chunks"`
+    AllowTools       bool    `long:"allow-tools" description:"Run the tools the model calls without asking first. The tools of a pattern are declared in tools.json next to its system.md"`
+    JsonSchema       string  `long:"json-schema" description:"Make the output JSON that matches the JSON schema in this file. Replaces the schema.json of the pattern. The output is checked and the model asked again when it doesn't match"`
+    Attach           []string `short:"a" long:"attach" description:"Attach an image to the message. Repeat it to attach several. The model must be able to read images"`
     Copy             bool    `short:"c" long:"copy" description:"Copy to clipboard"`
-    Model            string  `short:"m" long:"model" description:"Choose model"`
-    Url              string  `short:"u" long:"url" description:"Choose ollama url" default:"http://127.0.0.1:11434"`
+    Model            string  `short:"m" long:"model" description:"Choose model. Use provider/model, e.g. ollama/llama3, to skip looking the model up. Several models separated by commas are compared like --compare"`
+    Compare          string  `long:"compare" description:"Send the message to several models at once and show their answers side by side, e.g. gpt-4o,claude-3-opus-20240229,ollama/llama3. Use -o to write a report in the format given with --format"`
+    Batch            string  `long:"batch" description:"Run the pattern over every input in a directory, a glob such as 'notes/*.md', or a .jsonl or .csv file with an input on every line. Use -o to write one output per input to a directory, or every result to a .jsonl file. Inputs that were finished are skipped when it is run again"`
+    BatchAPI         bool    `long:"batch-api" description:"Send the inputs of --batch to the provider's batch api instead of running them now. OpenAI and Anthropic answer within 24 hours for half the price. Follow it with --batch-jobs and get the results with --batch-download"`
+    BatchJobs        bool    `long:"batch-jobs" description:"List the batches sent with --batch-api and how far they are"`
+    BatchDownload    string  `long:"batch-download" description:"Write the results of a batch sent with --batch-api to the output it was given, once the provider is done"`
+    BatchWorkers     int     `long:"batch-workers" description:"Number of inputs of --batch sent at once" default:"4"`
+    Embed            bool    `long:"embed" description:"Print the embedding of the input as JSON instead of sending it to a pattern. With --batch, one line of JSON for every input. Uses EMBEDDING_MODEL from the .env file, or the model given with -m"`
+    OllamaUrl        string  `long:"ollama-url" description:"Choose ollama url. Defaults to the one given in the setup"`
+    Url              []string `long:"url" description:"Fetch a web page and send its main content as markdown before the message. Repeat it to send several"`
     Output           string  `short:"o" long:"output" description:"Output to file" default:""`
     Interactive     bool    `short:"i" long:"interactive" description:"Interactive mode"`
     LatestPatterns string    `short:"n" long:"latest" description:"Number of latest patterns to list" default:"0"`
+    Timeout          time.Duration `long:"timeout" description:"Give up on the model after this long, e.g. 90s or 5m. Defaults to the provider's timeout"`
 }
 
 // Initialize flags. returns a Flags struct and an error
@@ -11,11 +11,13 @@ require (
 	github.com/google/generative-ai-go v0.14.0
 	github.com/jessevdk/go-flags v1.6.1
 	github.com/joho/godotenv v1.5.1
+	github.com/ledongthuc/pdf v0.0.0-20240201131950-da5b75280b06
 	github.com/liushuangls/go-anthropic/v2 v2.3.1
 	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/otiai10/copy v1.14.0
 	github.com/potproject/claude-sdk-go v1.1.4
 	github.com/sashabaranov/go-openai v1.26.0
+	golang.org/x/net v0.26.0
 	google.golang.org/api v0.185.0
 	gopkg.in/gookit/color.v1 v1.1.6
 )
@@ -76,7 +78,6 @@ require (
 	go.opentelemetry.io/otel/trace v1.26.0 // indirect
 	golang.org/x/crypto v0.24.0 // indirect
 	golang.org/x/mod v0.17.0 // indirect
-	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/oauth2 v0.21.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
@@ -1,6 +1,7 @@
 package interactive
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -40,11 +41,11 @@ func getPatterns() []list.Item {
 }
 
 func getModels(c chat.Chat) []list.Item {
-	models, _ := chat.ListAllModels(c)
+	models, _ := chat.LoadModels(context.Background(), c)
 	finalList := make([]list.Item, 0, len(models))
-	for _, modelList := range models {
-		for _, model := range modelList {
-			finalList = append(finalList, item(model))
+	for provider, modelList := range models {
+		for _, model := range modelList.Models {
+			finalList = append(finalList, item(provider+"/"+model)) // qualified so that the chosen model goes straight to its provider
 		}
 	}
 	return finalList
@@ -53,16 +54,13 @@ func getModels(c chat.Chat) []list.Item {
 func Interactive() {
     patterns := getPatterns()
 	godotenv.Load(env)
-    openaiAPIKey := os.Getenv("OPENAI_API_KEY")
-	groqApiKey := os.Getenv("GROQ_API_KEY")
-	google_api_key := os.Getenv("GOOGLE_API_KEY")
-	claude_api_key := os.Getenv("CLAUDE_API_KEY")
+	config := make(map[string]string)
+	for _, key := range chat.ConfigKeys() {
+		config[key.Name] = os.Getenv(key.Name)
+	}
     chat := chat.Chat{
-		OpenAIApiKey: openaiAPIKey,
-		GroqApiKey: groqApiKey,
-		GoogleApiKey: google_api_key,
-		AnthropicApiKey: claude_api_key,
-		ResponseChan: make(chan string),
+		Config: config,
+		ResponseChan: make(chan chat.StreamEvent),
 	}
 	models := getModels(chat)
     chatModel := InitialChatModel(&chat)
@@ -1,13 +1,17 @@
 package interactive
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/xssdoctor/gofabric/chat"
+	"github.com/xssdoctor/gofabric/loaders"
 )
 
 type chatModel struct {
@@ -18,10 +22,16 @@ type chatModel struct {
     chat          *chat.Chat
     responses    string
     quitting     bool
+    cancel       context.CancelFunc // stops the response that is being generated
+    done         chan error         // receives the result of the response once the stream is closed
+    streaming    bool               // a response is being generated. it ends when the stream is closed
 }
 
 type errMsg error
 
+// sent when a stream closed without an error
+type streamDoneMsg struct{}
+
 
 
 func InitialChatModel(chat *chat.Chat) chatModel {
@@ -34,7 +44,7 @@ func InitialChatModel(chat *chat.Chat) chatModel {
 	ta.FocusedStyle.CursorLine = lipgloss.NewStyle()
 	ta.ShowLineNumbers = false
 	vp := viewport.New(30, 5)
-	vp.SetContent("Keybindings:\n\nCtrl+s: Send message or choose a pattern/model\nCtrl+p: Choose a pattern\nCtrl+b: Choose a model\n\nCtrl+f: interact with llm\nCtrl+c: quit")
+	vp.SetContent("Keybindings:\n\nCtrl+s: Send message or choose a pattern/model\nCtrl+p: Choose a pattern\nCtrl+b: Choose a model\n/input <file>: send a pdf, docx, html, markdown, subtitle or text file with the message\n\nCtrl+f: interact with llm\nCtrl+x: stop the response\nCtrl+c: quit")
     return chatModel{
         userInput:    ta,
         outputView:   vp,
@@ -63,11 +73,28 @@ func (m *chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
     case tea.KeyMsg:
         switch msg.Type {
         case tea.KeyCtrlS:
-            m.chat.Message = m.userInput.Value()
+            // only one response at a time. the one being generated has to finish or be stopped with Ctrl+x first
+            if m.streaming {
+                return m, tea.Batch(tiCmd, vpCmd)
+            }
+            message, err := expandInputs(m.userInput.Value())
+            if err != nil {
+                m.outputView.SetContent(err.Error())
+                return m, tea.Batch(tiCmd, vpCmd)
+            }
+            m.chat.Message = message
             m.chat.Stream = true
-            m.chat.ResponseChan = make(chan string)
+            m.chat.ResponseChan = make(chan chat.StreamEvent)
+            ctx, cancel := context.WithCancel(context.Background())
+            m.cancel = cancel
+            done := make(chan error, 1)
+            m.done = done
+            m.streaming = true
+            // the request gets its own copy, so choosing another pattern or model while it runs only changes the next one
+            request := *m.chat
             go func() {
-                m.chat.SendMessageToModel()
+                _, err := request.SendMessageToModel(ctx)
+                done <- err
             }()
             m.userInput.Reset()
             m.responses = ""
@@ -76,27 +103,96 @@ func (m *chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
             m.quitting = true
             return m, tea.Quit
         }
-    case string:
-        m.responses += msg
+    case chat.StreamEvent:
+        m.responses += msg.Text
+        if msg.Done {
+            m.responses += responseWarning(msg.Response)
+        }
         m.outputView.SetContent(m.responses)
         m.outputView.GotoBottom()
         return m, tea.Batch(tiCmd, vpCmd, m.waitForResponse())
+    case streamDoneMsg:
+        m.streaming = false
+        m.cancel = nil
+        return m, tea.Batch(tiCmd, vpCmd)
+    case errMsg:
+        m.streaming = false
+        m.cancel = nil
+        if errors.Is(msg, context.Canceled) {
+            m.responses += "\n[stopped]"
+        } else {
+            m.responses += "\n" + msg.Error()
+        }
+        m.outputView.SetContent(m.responses)
+        m.outputView.GotoBottom()
+        return m, tea.Batch(tiCmd, vpCmd)
     }
 
     return m, tea.Batch(tiCmd, vpCmd)
 }
 
+// lines of the form /input <file> are replaced by the text of the file, which goes in front of the message under a header naming the file
+func expandInputs(message string) (string, error) {
+    var paths, lines []string
+    for _, line := range strings.Split(message, "\n") {
+        if path, found := strings.CutPrefix(strings.TrimSpace(line), "/input "); found {
+            paths = append(paths, strings.TrimSpace(path))
+            continue
+        }
+        lines = append(lines, line)
+    }
+    if len(paths) == 0 {
+        return message, nil
+    }
+    documents, err := loaders.LoadAll(paths)
+    if err != nil {
+        return "", err
+    }
+    return loaders.Compose(documents, strings.Join(lines, "\n")), nil
+}
+
+// cancels the response that is being generated, if there is one. the stream closes soon after, which ends it
+func (m *chatModel) stopResponse() {
+    if m.cancel != nil {
+        m.cancel()
+    }
+}
+
 func (m *chatModel) waitForResponse() tea.Cmd {
+    responseChan := m.chat.ResponseChan
+    done := m.done
     return func() tea.Msg {
-        response, ok := <-m.chat.ResponseChan
+        response, ok := <-responseChan
         if !ok {
-            return nil
+            // the stream is over, report why if it did not finish normally
+            if err := <-done; err != nil {
+                return errMsg(err)
+            }
+            return streamDoneMsg{}
         }
         return response
     }
 }
 
 
+// notes shown under a response that did not finish normally
+func responseWarning(response *chat.Response) string {
+    if response == nil {
+        return ""
+    }
+    note := ""
+    if len(response.FallbackFrom) > 0 {
+        note = "\n[answered by " + response.QualifiedModel() + " because " + strings.Join(response.FallbackFrom, " and ") + " failed]"
+    }
+    if response.Refusal != "" {
+        return note + "\n[refused: " + response.Refusal + "]"
+    }
+    if response.Truncated() {
+        return note + "\n[cut off: the response reached the token limit of " + response.QualifiedModel() + "]"
+    }
+    return note
+}
+
 func (m *chatModel) View() string {
     s := fmt.Sprintf(
         "%s\n\n%s",
@@ -8,6 +8,8 @@ import (
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/xssdoctor/gofabric/chat"
+	"github.com/xssdoctor/gofabric/db"
 )
 
 type model struct {
@@ -49,6 +51,10 @@ func (m model) View() string {
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case chat.StreamEvent, streamDoneMsg, errMsg:
+		// the response keeps streaming while a list has the focus, so its events always go to the chat
+		_, cmd := m.chat.Update(msg)
+		return m, cmd
 	case tea.WindowSizeMsg:
 		if m.fullscreen {
 			m.chat.userInput.SetWidth(msg.Width)
@@ -99,6 +105,9 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg.String() {
 			case "ctrl+c":
 				return m, tea.Quit
+			case "ctrl+x":
+				m.chat.stopResponse()
+				return m, nil
 			case "ctrl+f":
 				m.fullscreen = true
 				m.patternsList = false
@@ -130,6 +139,10 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					}
 
 					m.chat.chat.Pattern = string(fileBytes)
+					m.chat.chat.PatternName = patternName
+					patternEntry := db.Entry{Name: patternName}
+					chain, _ := patternEntry.GetPatternFallback()
+					m.chat.chat.Fallbacks = chat.ParseModelChain(chain)
 				} else if m.focus == 1 {
 					m.lists[1].SetDelegate(itemDelegate{highlightedIndex: m.lists[1].Index()})
 					m.chat.chat.Model = m.lists[1].SelectedItem().(item).FilterValue()
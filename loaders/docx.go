@@ -0,0 +1,94 @@
+package loaders
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// reads the paragraphs of a word document. headings become markdown headings and list items start with a dash. table cells become paragraphs of their own and the rest of the formatting is dropped
+func loadDOCX(path string) (Document, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return Document{}, err
+	}
+	defer archive.Close()
+	var body *zip.File
+	for _, file := range archive.File {
+		if file.Name == "word/document.xml" {
+			body = file
+		}
+	}
+	if body == nil {
+		return Document{}, errors.New("the file is not a word document")
+	}
+	reader, err := body.Open()
+	if err != nil {
+		return Document{}, err
+	}
+	defer reader.Close()
+
+	var text, paragraph strings.Builder
+	prefix := ""
+	inText := false
+	decoder := xml.NewDecoder(reader)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Document{}, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				paragraph.WriteString("\t")
+			case "br", "cr":
+				paragraph.WriteString("\n")
+			case "pStyle":
+				// Heading1 to Heading9 and Title are the built in heading styles
+				style := xmlAttr(t, "val")
+				if level := strings.TrimPrefix(style, "Heading"); len(level) == 1 && level >= "1" && level <= "9" {
+					prefix = strings.Repeat("#", int(level[0]-'0')) + " "
+				} else if style == "Title" {
+					prefix = "# "
+				}
+			case "numPr":
+				if prefix == "" {
+					prefix = "- "
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if line := strings.TrimSpace(paragraph.String()); line != "" {
+					text.WriteString(prefix + line + "\n\n")
+				}
+				paragraph.Reset()
+				prefix = ""
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(t)
+			}
+		}
+	}
+	return Document{Format: FormatDOCX, Text: text.String()}, nil
+}
+
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
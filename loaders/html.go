@@ -0,0 +1,364 @@
+package loaders
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+func loadHTML(path string) (Document, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Document{}, err
+	}
+	defer file.Close()
+	title, markdown, err := HTMLToMarkdown(file, nil)
+	if err != nil {
+		return Document{}, err
+	}
+	return Document{Format: FormatHTML, Title: title, Text: markdown}, nil
+}
+
+// HTMLToMarkdown converts a page to markdown and returns it with the page's title. Only the main content is kept when the page marks it with <main> or <article>, and scripts, styles, navigation and forms are left out. Relative links are resolved against base when it is given
+func HTMLToMarkdown(r io.Reader, base *url.URL) (string, string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", "", err
+	}
+	title := ""
+	if node := findElement(doc, atom.Title); node != nil {
+		title = strings.TrimSpace(collapseSpace(textContent(node)))
+	}
+	root := findElement(doc, atom.Main)
+	if root == nil {
+		root = findElement(doc, atom.Article)
+	}
+	if root == nil {
+		root = findElement(doc, atom.Body)
+	}
+	if root == nil {
+		root = doc
+	}
+	c := &converter{base: base}
+	c.children(root)
+	return title, tidy(c.out.String()), nil
+}
+
+// elements whose contents are never part of the text
+var skipped = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Svg: true, atom.Iframe: true, atom.Nav: true, atom.Footer: true, atom.Aside: true,
+	atom.Form: true, atom.Button: true, atom.Select: true, atom.Textarea: true,
+}
+
+// elements that start on a line of their own
+var blocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.Header: true, atom.Figure: true, atom.Figcaption: true, atom.Dl: true, atom.Dt: true,
+	atom.Dd: true, atom.Address: true, atom.Details: true, atom.Summary: true, atom.Caption: true,
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func collapseSpace(text string) string {
+	return whitespace.ReplaceAllString(text, " ")
+}
+
+// converter writes markdown as it walks the page
+type converter struct {
+	out    strings.Builder
+	base   *url.URL
+	prefix []string // written at the start of every line, for the indentation of lists and the markers of quotes
+	last   byte     // last byte written
+	marker bool     // a list marker was just written, so the first block of the item goes on its line
+}
+
+// writes text exactly, adding the prefix at the start of every line that isn't blank
+func (c *converter) write(text string) {
+	c.marker = false
+	for i := 0; i < len(text); i++ {
+		if c.last == '\n' || c.out.Len() == 0 {
+			// blank lines are left empty, which keeps quotes and lists readable as plain text
+			if text[i] != '\n' {
+				c.out.WriteString(strings.Join(c.prefix, ""))
+			}
+		}
+		c.out.WriteByte(text[i])
+		c.last = text[i]
+	}
+}
+
+// writes inline text with its whitespace collapsed, dropping spaces at the start of a line
+func (c *converter) inline(text string) {
+	text = collapseSpace(text)
+	if c.last == '\n' || c.last == ' ' || c.out.Len() == 0 {
+		text = strings.TrimLeft(text, " ")
+	}
+	if text != "" {
+		c.write(text)
+	}
+}
+
+// ends the current line and adds blank lines until there are lines newlines in a row
+func (c *converter) breakLines(lines int) {
+	if c.out.Len() == 0 || c.marker {
+		return
+	}
+	current := c.out.String()
+	trailing := len(current) - len(strings.TrimRight(current, "\n"))
+	for ; trailing < lines; trailing++ {
+		c.write("\n")
+	}
+}
+
+func (c *converter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.node(child)
+	}
+}
+
+func (c *converter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		c.inline(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		c.children(n)
+		return
+	}
+	if skipped[n.DataAtom] {
+		return
+	}
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		c.breakLines(2)
+		c.write(strings.Repeat("#", level) + " ")
+		c.children(n)
+		c.breakLines(2)
+	case atom.Br:
+		c.write("\n")
+	case atom.Hr:
+		c.breakLines(2)
+		c.write("---")
+		c.breakLines(2)
+	case atom.A:
+		text := strings.TrimSpace(collapseSpace(textContent(n)))
+		href := c.resolve(attr(n, "href"))
+		if text == "" {
+			return
+		}
+		c.spaceBefore(n)
+		if href == "" {
+			c.inline(text)
+		} else {
+			c.write("[" + text + "](" + href + ")")
+		}
+		c.spaceAfter(n)
+	case atom.Img:
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			c.write("![" + alt + "](" + c.resolve(attr(n, "src")) + ")")
+		}
+	case atom.Strong, atom.B:
+		c.wrap(n, "**")
+	case atom.Em, atom.I:
+		c.wrap(n, "*")
+	case atom.Code:
+		c.wrap(n, "`")
+	case atom.Pre:
+		c.breakLines(2)
+		c.write("```\n" + strings.Trim(textContent(n), "\n") + "\n```")
+		c.breakLines(2)
+	case atom.Ul, atom.Ol:
+		c.list(n)
+	case atom.Li:
+		// a list item outside of a list
+		c.breakLines(1)
+		c.write("- ")
+		c.marker = true
+		c.children(n)
+		c.breakLines(1)
+	case atom.Blockquote:
+		c.breakLines(2)
+		c.prefix = append(c.prefix, "> ")
+		c.children(n)
+		c.prefix = c.prefix[:len(c.prefix)-1]
+		c.breakLines(2)
+	case atom.Table:
+		c.table(n)
+	default:
+		if blocks[n.DataAtom] {
+			c.breakLines(2)
+			c.children(n)
+			c.breakLines(2)
+			return
+		}
+		c.children(n)
+	}
+}
+
+// writes the text of the element between markers, e.g. **bold**
+func (c *converter) wrap(n *html.Node, marker string) {
+	text := strings.TrimSpace(collapseSpace(textContent(n)))
+	if text == "" {
+		return
+	}
+	c.spaceBefore(n)
+	c.write(marker + text + marker)
+	c.spaceAfter(n)
+}
+
+// keeps the space between an element and the text before it, which is lost when the element's text is trimmed
+func (c *converter) spaceBefore(n *html.Node) {
+	text := textContent(n)
+	if strings.TrimLeft(text, " \t\n") != text && c.last != ' ' && c.last != '\n' && c.out.Len() > 0 {
+		c.write(" ")
+	}
+}
+
+func (c *converter) spaceAfter(n *html.Node) {
+	text := textContent(n)
+	if strings.TrimRight(text, " \t\n") != text {
+		c.write(" ")
+	}
+}
+
+// writes the items of a list, numbered for ordered lists. nested lists are indented under their item
+func (c *converter) list(n *html.Node) {
+	// nested lists follow their item without a blank line
+	gap := 2
+	if len(c.prefix) > 0 {
+		gap = 1
+	}
+	c.breakLines(gap)
+	number := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		number = start
+	}
+	for item := n.FirstChild; item != nil; item = item.NextSibling {
+		if item.Type != html.ElementNode || item.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		c.breakLines(1)
+		c.write(marker)
+		c.marker = true
+		c.prefix = append(c.prefix, strings.Repeat(" ", len(marker)))
+		c.children(item)
+		c.prefix = c.prefix[:len(c.prefix)-1]
+		c.breakLines(1)
+	}
+	c.breakLines(gap)
+}
+
+// writes a table as a markdown table, taking the first row as the header
+func (c *converter) table(n *html.Node) {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if child.DataAtom != atom.Tr {
+				walk(child)
+				continue
+			}
+			var cells []string
+			for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+					text := strings.TrimSpace(collapseSpace(textContent(cell)))
+					cells = append(cells, strings.ReplaceAll(text, "|", `\|`))
+				}
+			}
+			if len(cells) > 0 {
+				rows = append(rows, cells)
+			}
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return
+	}
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	c.breakLines(2)
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		c.write("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			c.write(strings.Repeat("| --- ", columns) + "|\n")
+		}
+	}
+	c.breakLines(2)
+}
+
+// resolves a link against the base url. links that lead nowhere, such as anchors and scripts, are dropped
+func (c *converter) resolve(link string) string {
+	link = strings.TrimSpace(link)
+	if link == "" || strings.HasPrefix(link, "#") || strings.HasPrefix(strings.ToLower(link), "javascript:") {
+		return ""
+	}
+	if c.base == nil {
+		return link
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return c.base.ResolveReference(ref).String()
+}
+
+// returns the first element of the kind, searching depth first
+func findElement(n *html.Node, kind atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == kind {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, kind); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// returns the text inside the node, leaving out the elements that are never shown
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.Type == html.ElementNode && skipped[n.DataAtom] {
+		return ""
+	}
+	var builder strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		builder.WriteString(textContent(child))
+	}
+	return builder.String()
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,158 @@
+package loaders
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// formats of the documents the loaders read
+const (
+	FormatPDF       = "pdf"
+	FormatDOCX      = "docx"
+	FormatHTML      = "html"
+	FormatMarkdown  = "markdown"
+	FormatSubtitles = "subtitles"
+	FormatText      = "text"
+)
+
+// Document is the text extracted from an input file or a web page
+type Document struct {
+	Source string // file name or url the text came from
+	Format string // one of the Format constants
+	Title  string // title of the document, when it has one
+	Text   string
+}
+
+// a loader extracts the text of a file in one format
+type loader func(path string) (Document, error)
+
+// loaders by file extension. files with other extensions are recognized by their contents
+var loadersByExtension = map[string]loader{
+	".pdf":      loadPDF,
+	".docx":     loadDOCX,
+	".html":     loadHTML,
+	".htm":      loadHTML,
+	".xhtml":    loadHTML,
+	".md":       loadMarkdown,
+	".markdown": loadMarkdown,
+	".srt":      loadSubtitles,
+	".vtt":      loadSubtitles,
+	".txt":      loadText,
+}
+
+// Load extracts clean text from the file. The format is found from the extension, or from the contents when the extension is not known
+func Load(path string) (Document, error) {
+	load, ok := loadersByExtension[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		var err error
+		load, err = sniff(path)
+		if err != nil {
+			return Document{}, err
+		}
+	}
+	document, err := load(path)
+	if err != nil {
+		return Document{}, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	document.Source = filepath.Base(path)
+	document.Text = tidy(document.Text)
+	if document.Text == "" {
+		return Document{}, fmt.Errorf("no text found in %s", path)
+	}
+	return document, nil
+}
+
+// LoadAll loads every file in order, stopping at the first that can't be read
+func LoadAll(paths []string) ([]Document, error) {
+	documents := make([]Document, 0, len(paths))
+	for _, path := range paths {
+		document, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+// picks the loader from the first bytes of the file
+func sniff(path string) (loader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	head := make([]byte, 512)
+	n, err := file.Read(head)
+	if err != nil && n == 0 {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	switch {
+	case contentType == "application/pdf":
+		return loadPDF, nil
+	case strings.HasPrefix(contentType, "text/html"):
+		return loadHTML, nil
+	case contentType == "application/zip":
+		// docx files are zip archives. loadDOCX says so when this one isn't
+		return loadDOCX, nil
+	case strings.HasPrefix(string(head), "WEBVTT"):
+		return loadSubtitles, nil
+	case strings.HasPrefix(contentType, "text/plain") || utf8.Valid(head):
+		return loadText, nil
+	}
+	return nil, fmt.Errorf("%s is not a document that can be read (%s). Use pdf, docx, html, markdown, srt, vtt or text files", path, contentType)
+}
+
+func loadText(path string) (Document, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return Document{}, err
+	}
+	if !utf8.Valid(contents) {
+		return Document{}, errors.New("the file is not utf-8 text")
+	}
+	return Document{Format: FormatText, Text: string(contents)}, nil
+}
+
+func loadMarkdown(path string) (Document, error) {
+	document, err := loadText(path)
+	document.Format = FormatMarkdown
+	return document, err
+}
+
+var (
+	trailingSpace = regexp.MustCompile(`[ \t]+\n`)
+	blankLines    = regexp.MustCompile(`\n{3,}`)
+)
+
+// normalizes line endings and removes trailing spaces and runs of blank lines
+func tidy(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.TrimPrefix(text, "\uFEFF")
+	text = trailingSpace.ReplaceAllString(text, "\n")
+	text = blankLines.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// Compose puts the documents in front of the message, each under a header naming its source, so the model can tell them apart and refer to them
+func Compose(documents []Document, message string) string {
+	var builder strings.Builder
+	for _, document := range documents {
+		builder.WriteString("SOURCE: " + document.Source + "\n")
+		if document.Title != "" {
+			builder.WriteString("TITLE: " + document.Title + "\n")
+		}
+		builder.WriteString("\n" + document.Text + "\n\n")
+	}
+	builder.WriteString(message)
+	return builder.String()
+}
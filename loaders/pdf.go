@@ -0,0 +1,50 @@
+package loaders
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+func loadPDF(path string) (Document, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Document{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return Document{}, err
+	}
+	return readPDF(file, info.Size())
+}
+
+// reads the text of every page. scanned pages have no text, so a scanned document ends up empty
+func readPDF(r io.ReaderAt, size int64) (document Document, err error) {
+	// the pdf reader panics on some broken files
+	defer func() {
+		if recover() != nil {
+			err = errors.New("the pdf is damaged or uses features that are not supported")
+		}
+	}()
+	reader, err := pdf.NewReader(r, size)
+	if err != nil {
+		return Document{}, err
+	}
+	var builder strings.Builder
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			return Document{}, err
+		}
+		builder.WriteString(strings.TrimSpace(text) + "\n\n")
+	}
+	return Document{Format: FormatPDF, Title: reader.Trailer().Key("Info").Key("Title").Text(), Text: builder.String()}, nil
+}
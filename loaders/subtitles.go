@@ -0,0 +1,53 @@
+package loaders
+
+import (
+	"html"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	// <v Speaker Name> opens the text of a speaker in webvtt
+	voiceTag = regexp.MustCompile(`^<v(?:\.[^ >]*)? ([^>]+)>`)
+	// other markup, such as <i>, <c.yellow>, <00:00:01.000> or {\an8}
+	cueMarkup = regexp.MustCompile(`<[^>]*>|\{\\[^}]*\}`)
+)
+
+// reads the spoken text of srt and webvtt subtitles, dropping the numbers, timings, styles and notes. speakers named in webvtt voice tags are kept, and lines repeated by rolling captions are only kept once
+func loadSubtitles(path string) (Document, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return Document{}, err
+	}
+	text := strings.ReplaceAll(string(contents), "\r\n", "\n")
+	var builder strings.Builder
+	previous := ""
+	for _, cue := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(cue, "\n"), "\n")
+		// the text of a cue comes after its timing. blocks without one are the header, notes and styles
+		timing := -1
+		for i, line := range lines {
+			if strings.Contains(line, "-->") {
+				timing = i
+				break
+			}
+		}
+		if timing < 0 {
+			continue
+		}
+		for _, line := range lines[timing+1:] {
+			speaker := ""
+			if match := voiceTag.FindStringSubmatch(line); match != nil {
+				speaker = strings.TrimSpace(match[1]) + ": "
+			}
+			line = strings.TrimSpace(html.UnescapeString(cueMarkup.ReplaceAllString(line, "")))
+			if line == "" || line == previous {
+				continue
+			}
+			previous = line
+			builder.WriteString(speaker + line + "\n")
+		}
+	}
+	return Document{Format: FormatSubtitles, Text: builder.String()}, nil
+}
@@ -0,0 +1,102 @@
+package loaders
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// DefaultFetchTimeout is how long fetching a page may take when the caller sets no deadline
+const DefaultFetchTimeout = 30 * time.Second
+
+// largest page that is read. longer pages are cut off here
+const maxPageSize = 10 << 20
+
+// some sites turn away clients that don't look like a browser
+const userAgent = "Mozilla/5.0 (compatible; fabric)"
+
+// FetchURL downloads the page and converts it to markdown. Html pages keep only their main content, and plain text, markdown and pdf are read as they are. Proxies are taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+func FetchURL(ctx context.Context, link string) (Document, error) {
+	parsed, err := url.Parse(link)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return Document{}, fmt.Errorf("%s is not a web address. Use a link starting with http:// or https://", link)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultFetchTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return Document{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/markdown,text/plain,application/pdf;q=0.9,*/*;q=0.5")
+	// the default transport already uses the proxy settings of the environment
+	client := &http.Client{Transport: http.DefaultTransport}
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return Document{}, fmt.Errorf("could not fetch %s: the site took too long to answer", link)
+		}
+		if errors.Is(err, context.Canceled) {
+			return Document{}, fmt.Errorf("fetching %s was cancelled", link)
+		}
+		return Document{}, fmt.Errorf("could not fetch %s: %v", link, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return Document{}, fmt.Errorf("could not fetch %s: %s", link, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return Document{}, fmt.Errorf("could not read %s: %v", link, err)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "" {
+		mediaType = strings.SplitN(http.DetectContentType(body), ";", 2)[0]
+	}
+	var document Document
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		// pages that are not utf-8 are converted using the charset they declare
+		reader, err := charset.NewReader(bytes.NewReader(body), contentType)
+		if err != nil {
+			return Document{}, fmt.Errorf("could not read %s: %v", link, err)
+		}
+		// links are resolved against the address the page ended up at, after redirects
+		title, markdown, err := HTMLToMarkdown(reader, resp.Request.URL)
+		if err != nil {
+			return Document{}, fmt.Errorf("could not read %s: %v", link, err)
+		}
+		document = Document{Format: FormatHTML, Title: title, Text: markdown}
+	case mediaType == "application/pdf":
+		document, err = readPDF(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			return Document{}, fmt.Errorf("could not read %s: %v", link, err)
+		}
+	case mediaType == "text/markdown":
+		document = Document{Format: FormatMarkdown, Text: string(body)}
+	case strings.HasPrefix(mediaType, "text/"):
+		document = Document{Format: FormatText, Text: string(body)}
+	default:
+		return Document{}, fmt.Errorf("%s is not a page that can be read (%s)", link, mediaType)
+	}
+	document.Source = link
+	document.Text = tidy(document.Text)
+	if document.Text == "" {
+		return Document{}, fmt.Errorf("no text found at %s", link)
+	}
+	return document, nil
+}
@@ -0,0 +1,136 @@
+package loaders
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a stand-in web site
+func siteStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/blog/post", http.StatusFound)
+	})
+	mux.HandleFunc("/blog/post", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("User-Agent"), "fabric") {
+			t.Errorf("the page was fetched with the user agent %q", r.Header.Get("User-Agent"))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head><title>A post</title></head><body>
+<nav><a href="/">Home</a></nav>
+<main><h1>Hello</h1><p>Read <a href="next">the next post</a>.</p></main>
+<footer>Copyright</footer>
+</body></html>`))
+	})
+	mux.HandleFunc("/latin1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write([]byte("<html><body><p>caf\xe9</p></body></html>"))
+	})
+	mux.HandleFunc("/notes.md", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/markdown")
+		w.Write([]byte("# Notes\n\n- one\n"))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("just text"))
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>  </body></html>"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchURL(t *testing.T) {
+	server := siteStandIn(t)
+
+	document, err := FetchURL(context.Background(), server.URL+"/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if document.Format != FormatHTML || document.Title != "A post" || document.Source != server.URL+"/old" {
+		t.Errorf("the page is %+v", document)
+	}
+	if !strings.Contains(document.Text, "Hello") || strings.Contains(document.Text, "Copyright") || strings.Contains(document.Text, "Home") {
+		t.Errorf("the page has the text %q, want only its main content", document.Text)
+	}
+	// links are resolved against the address after the redirect
+	if !strings.Contains(document.Text, server.URL+"/blog/next") {
+		t.Errorf("the link of the page is not resolved against its address: %q", document.Text)
+	}
+
+	for _, test := range []struct {
+		path   string
+		format string
+		text   string
+	}{
+		{"/latin1", FormatHTML, "café"},
+		{"/notes.md", FormatMarkdown, "# Notes\n\n- one"},
+		{"/plain", FormatText, "just text"},
+	} {
+		document, err := FetchURL(context.Background(), server.URL+test.path)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		if document.Format != test.format || !strings.Contains(document.Text, test.text) {
+			t.Errorf("%s is %+v, want the format %s and the text %q", test.path, document, test.format, test.text)
+		}
+	}
+}
+
+func TestFetchURLErrors(t *testing.T) {
+	server := siteStandIn(t)
+	for _, test := range []struct {
+		link    string
+		problem string
+	}{
+		{"ftp://example.com/file", "is not a web address"},
+		{"example.com", "is not a web address"},
+		{server.URL + "/missing", "404"},
+		{server.URL + "/image.png", "is not a page that can be read (image/png)"},
+		{server.URL + "/empty", "no text found"},
+	} {
+		_, err := FetchURL(context.Background(), test.link)
+		if err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("%s gave the error %v, want one saying %q", test.link, err, test.problem)
+		}
+	}
+}
+
+func TestFetchURLStops(t *testing.T) {
+	server := siteStandIn(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := FetchURL(ctx, server.URL+"/slow"); err == nil || !strings.Contains(err.Error(), "took too long") {
+		t.Errorf("a slow site gave the error %v, want one saying it took too long", err)
+	}
+
+	// Ctrl+C cancels the context
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := FetchURL(ctx, server.URL+"/slow"); err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Errorf("a cancelled fetch gave the error %v, want one saying it was cancelled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the cancelled fetch took %v", elapsed)
+	}
+}
@@ -1,16 +1,34 @@
 package main
 
 import (
+	"errors"
+	"os"
+
+	goflags "github.com/jessevdk/go-flags"
 	"github.com/xssdoctor/gofabric/cli"
 	"github.com/xssdoctor/gofabric/db"
+	"github.com/xssdoctor/gofabric/models" // registers the providers with the chat package
 	"github.com/xssdoctor/gofabric/utils"
 )
 
 func main() {
+	// endpoints from endpoints.json are providers too, and have to be known before the configuration is read
+	if err := models.RegisterEndpoints(); err != nil {
+		utils.LogWarning(err)
+	}
 	err := db.InitDB() // initialize the database, including creating tables and populating the database. If the database already exists, it will not be overwritten, but the tables will be created if they do not exist.
 	if err != nil {
 		utils.LogError(err)
 	}
-	cli.Cli()
+	if _, err := cli.Cli(); err != nil {
+		// go-flags prints its own errors, including the help text
+		var flagsErr *goflags.Error
+		if !errors.As(err, &flagsErr) {
+			utils.LogError(err)
+		} else if flagsErr.Type == goflags.ErrHelp {
+			return
+		}
+		os.Exit(1)
+	}
 
 }
\ No newline at end of file
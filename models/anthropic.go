@@ -4,16 +4,42 @@ import (
 	"context"
 	"errors"
 	"io"
+	"strings"
 
 	"github.com/liushuangls/go-anthropic/v2"
 	claude "github.com/potproject/claude-sdk-go"
+	"github.com/xssdoctor/gofabric/chat"
 )
 
 type Anthropic struct {
 	DefaultModel
 }
 
-func NewClaude(apiKey string, message string, pattern string, context string, model string, temperature float64, topP float64, session []map[string]string, responseChan chan string) *Anthropic {
+// registers claude with the chat package
+func init() {
+	chat.Register(chat.Provider{
+		Name: "claude",
+		Keys: []chat.ConfigKey{
+			{Name: "CLAUDE_API_KEY", Prompt: "Enter your Anthropic API key: (Leave blank if you don't have one)"},
+			{Name: "CLAUDE_BASE_URL", Default: "https://api.anthropic.com/"}, // only needs changing for a proxy or a local stand-in
+		},
+		Matches: func(model string) bool {
+			return strings.HasPrefix(model, "claude-")
+		},
+		New: func(c chat.Chat) chat.Model {
+			model := NewClaude(c.ConfigValue("CLAUDE_API_KEY"), c.Message, c.Pattern, c.Context, c.Model, c.Temperature, c.TopP, c.Session, c.ResponseChan)
+			model.Attachments = c.Attachments
+			model.Tools = c.Tools
+			model.Schema = c.Schema
+			model.Url = c.ConfigValue("CLAUDE_BASE_URL")
+			return model
+		},
+		Tools:  true,
+		Priced: true,
+	})
+}
+
+func NewClaude(apiKey string, message string, pattern string, context string, model string, temperature float64, topP float64, session []chat.Message, responseChan chan chat.StreamEvent) *Anthropic {
 	return &Anthropic{
 		DefaultModel{
 			Message: message,
@@ -30,12 +56,16 @@ func NewClaude(apiKey string, message string, pattern string, context string, mo
 
 }
 
-func (ant *Anthropic) SendMessage() (string, error) {
+func (ant *Anthropic) SendMessage(ctx context.Context) (chat.Response, error) {
     if ant.Context != "" {
         ant.Context = "CONTEXT:\n" + ant.Context + "\n" //set context to CONTEXT\n[context]
     }
+	if len(ant.Tools) > 0 || schemaType(ant.Schema) == "object" {
+		return ant.sendWithTools(ctx)
+	}
 	messages := CreateClaudeMessage(ant)
-	c := claude.NewClient(ant.ApiKey)
+	recorder := &statusRecorder{}
+	c := newClaudeClient(ant.ApiKey, ant.baseURL(), recorder)
 	m := claude.RequestBodyMessages{
 		Model:     ant.Model,
 		MaxTokens: 4096,
@@ -44,59 +74,91 @@ func (ant *Anthropic) SendMessage() (string, error) {
 		System: ant.Context + ant.Pattern,
 		Messages: messages,
 	}
-	ctx := context.Background()
 	res, err := c.CreateMessages(ctx, m)
 	if err != nil {
-		return "", err
+		return chat.Response{}, recorder.wrap(err)
+	}
+	response := chat.Response{
+		FinishReason: claudeFinishReason(res.StopReason),
+		Usage:        chat.Usage{InputTokens: int(res.Usage.InputTokens), OutputTokens: int(res.Usage.OutputTokens)},
+		Model:        res.Model,
+		RequestID:    res.Id,
+	}
+	for _, content := range res.Content {
+		response.Text += content.Text
 	}
-	return res.Content[0].Text, nil
+	return response, nil
 }
 
-func (ant *Anthropic) StreamMessage() (error) {
+func (ant *Anthropic) StreamMessage(ctx context.Context) (chat.Response, error) {
 	// streams message and also returns completed message for further functions
     if ant.Context != "" {
         ant.Context = "CONTEXT:\n" + ant.Context + "\n" //set context to CONTEXT:\n[context]
     }
-	c := claude.NewClient(ant.ApiKey)
+	messages := CreateClaudeMessage(ant)
+	recorder := &statusRecorder{}
+	c := newClaudeClient(ant.ApiKey, ant.baseURL(), recorder)
 	m := claude.RequestBodyMessages{
 		Model:     ant.Model,
 		MaxTokens: 4096,
 		Temperature: ant.Temperature,
 		TopP: ant.TopP,
 		System: ant.Context + ant.Pattern,
-		Messages: []claude.RequestBodyMessagesMessages{
-			{
-				Role:    claude.MessagesRoleUser,
-				Content: ant.Message,
-			},
-		},
+		Messages: messages,
 	}
-	ctx := context.Background()
 	stream, err := c.CreateMessagesStream(ctx, m)
 	if err != nil {
-		return err
+		return chat.Response{}, recorder.wrap(err)
 	}
 	defer stream.Close()
 	for {
+		// every event carries the message so far, apart from the text
 		res, err := stream.Recv()
+		response := chat.Response{
+			FinishReason: claudeFinishReason(res.StopReason),
+			Usage:        chat.Usage{InputTokens: int(res.Usage.InputTokens), OutputTokens: int(res.Usage.OutputTokens)},
+			Model:        res.Model,
+			RequestID:    res.Id,
+		}
 		if errors.Is(err, io.EOF) {
-			ant.ResponseChan <- "\n"
-			close(ant.ResponseChan)
-			return nil
+			ant.ResponseChan <- chat.StreamEvent{Text: "\n"}
+			return response, nil
 		}
 		if err != nil {
-			return err
+			return response, recorder.wrap(err)
 		}
 
-		asText := res.Content[0].Text
-		ant.ResponseChan <- asText
+		if len(res.Content) > 0 {
+			ant.ResponseChan <- chat.StreamEvent{Text: res.Content[0].Text}
+		}
 	}
 }
 
-func (ant *Anthropic) ListModels() ([]string, error) {
+func (ant *Anthropic) ListModels(ctx context.Context) ([]string, error) {
 	// returns a list of models. I had to create it myself since the anthropic api doesn't have a ListModels function
 	if ant.ApiKey == "" {
 		return []string{}, errors.New("no claude api key")
 	}
 	return []string{anthropic.ModelClaude3Haiku20240307, anthropic.ModelClaude3Opus20240229, anthropic.ModelClaude2Dot0, anthropic.ModelClaude2Dot1, anthropic.ModelClaudeInstant1Dot2, "claude-3-5-sonnet-20240620"}, nil
-}
\ No newline at end of file
+}
+// builds a claude client whose failed requests are kept by the recorder. the sdk has no other way to change the http client, so the defaults are repeated here
+func newClaudeClient(apiKey string, baseURL string, recorder *statusRecorder) *claude.Client {
+	return claude.NewClientWithConfig(claude.ClientConfig{
+		ApiKey:     apiKey,
+		Version:    claudeAPIVersion,
+		BaseURL:    baseURL,
+		Endpoint:   "v1/messages",
+		HTTPClient: recorder.client(),
+	})
+}
+
+// version of the anthropic api the requests are written for
+const claudeAPIVersion = "2023-06-01"
+
+// the base url always ends with a slash, as the sdk expects
+func (ant *Anthropic) baseURL() string {
+	if ant.Url == "" {
+		return "https://api.anthropic.com/"
+	}
+	return strings.TrimSuffix(ant.Url, "/") + "/"
+}
@@ -0,0 +1,197 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	claude "github.com/potproject/claude-sdk-go"
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// a batch as anthropic describes it
+type claudeBatch struct {
+	ID               string `json:"id"`
+	ProcessingStatus string `json:"processing_status"`
+	RequestCounts    struct {
+		Processing int `json:"processing"`
+		Succeeded  int `json:"succeeded"`
+		Errored    int `json:"errored"`
+		Canceled   int `json:"canceled"`
+		Expired    int `json:"expired"`
+	} `json:"request_counts"`
+	CancelInitiatedAt *string `json:"cancel_initiated_at"`
+	ResultsURL        string  `json:"results_url"`
+}
+
+type claudeBatchRequest struct {
+	CustomID string                     `json:"custom_id"`
+	Params   claude.RequestBodyMessages `json:"params"`
+}
+
+// a line of the results of a batch
+type claudeBatchLine struct {
+	CustomID string `json:"custom_id"`
+	Result   struct {
+		Type    string                      `json:"type"` // succeeded, errored, canceled or expired
+		Message claude.ResponseBodyMessages `json:"message"`
+		Error   struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"error"`
+	} `json:"result"`
+}
+
+// SubmitBatch creates a message batch. anthropic has 24 hours to finish it
+func (ant *Anthropic) SubmitBatch(ctx context.Context, requests []chat.BatchRequest) (string, error) {
+	if ant.Context != "" {
+		ant.Context = "CONTEXT:\n" + ant.Context + "\n" //set context to CONTEXT:\n[context]
+	}
+	body := struct {
+		Requests []claudeBatchRequest `json:"requests"`
+	}{}
+	for _, request := range requests {
+		model := *ant
+		model.Pattern = request.Pattern
+		model.Message = request.Message
+		messages := CreateClaudeMessage(&model)
+		// the sdk fills in the content of plain text messages when it sends them. here the request is marshalled directly
+		for i := range messages {
+			if messages[i].ContentRaw == nil {
+				messages[i].ContentRaw = messages[i].Content
+			}
+		}
+		body.Requests = append(body.Requests, claudeBatchRequest{
+			CustomID: request.ID,
+			Params: claude.RequestBodyMessages{
+				Model:       ant.Model,
+				MaxTokens:   4096,
+				Temperature: ant.Temperature,
+				TopP:        ant.TopP,
+				System:      ant.Context + request.Pattern,
+				Messages:    messages,
+			},
+		})
+	}
+	var batch claudeBatch
+	if err := doJSON(ctx, ant.httpClient(), http.MethodPost, ant.batchURL(""), ant.apiHeader(), body, &batch); err != nil {
+		return "", err
+	}
+	return batch.ID, nil
+}
+
+// BatchStatus returns how far anthropic is with the batch
+func (ant *Anthropic) BatchStatus(ctx context.Context, id string) (chat.BatchStatus, error) {
+	batch, err := ant.retrieveBatch(ctx, id)
+	if err != nil {
+		return chat.BatchStatus{}, err
+	}
+	counts := batch.RequestCounts
+	status := chat.BatchStatus{
+		State:     chat.BatchRunning,
+		Total:     counts.Processing + counts.Succeeded + counts.Errored + counts.Canceled + counts.Expired,
+		Succeeded: counts.Succeeded,
+		Failed:    counts.Errored + counts.Canceled + counts.Expired,
+	}
+	if batch.ProcessingStatus == "ended" {
+		// anthropic ends every batch the same way. how it ended shows in the requests that didn't succeed
+		switch {
+		case batch.CancelInitiatedAt != nil:
+			status.State = chat.BatchCancelled
+		case counts.Expired > 0:
+			status.State = chat.BatchExpired
+		default:
+			status.State = chat.BatchEnded
+		}
+	}
+	return status, nil
+}
+
+// BatchResults reads the results of every request of a batch that has ended
+func (ant *Anthropic) BatchResults(ctx context.Context, id string) ([]chat.BatchResult, error) {
+	batch, err := ant.retrieveBatch(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if batch.ResultsURL == "" {
+		return nil, fmt.Errorf("batch %s has no results yet", id)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, batch.ResultsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = ant.apiHeader()
+	resp, err := ant.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, &chat.StatusError{StatusCode: resp.StatusCode, RetryAfter: retryAfter(resp.StatusCode, resp.Header), Err: apiError(resp)}
+	}
+	var results []chat.BatchResult
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var line claudeBatchLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("could not read the results of the batch: %v", err)
+		}
+		results = append(results, claudeBatchResult(line))
+	}
+	return results, scanner.Err()
+}
+
+func claudeBatchResult(line claudeBatchLine) chat.BatchResult {
+	result := chat.BatchResult{ID: line.CustomID}
+	switch line.Result.Type {
+	case "succeeded":
+		message := line.Result.Message
+		result.Response = chat.Response{
+			FinishReason: claudeFinishReason(message.StopReason),
+			Usage:        chat.Usage{InputTokens: int(message.Usage.InputTokens), OutputTokens: int(message.Usage.OutputTokens)},
+			Model:        message.Model,
+			RequestID:    message.Id,
+		}
+		for _, content := range message.Content {
+			result.Response.Text += content.Text
+		}
+	case "errored":
+		result.Err = errors.New(line.Result.Error.Error.Message)
+	default:
+		result.Err = fmt.Errorf("the request was %s", line.Result.Type)
+	}
+	return result
+}
+
+func (ant *Anthropic) retrieveBatch(ctx context.Context, id string) (claudeBatch, error) {
+	var batch claudeBatch
+	err := doJSON(ctx, ant.httpClient(), http.MethodGet, ant.batchURL(id), ant.apiHeader(), nil, &batch)
+	return batch, err
+}
+
+// url of the message batches, or of one batch when an id is given
+func (ant *Anthropic) batchURL(id string) string {
+	link := ant.baseURL() + "v1/messages/batches"
+	if id != "" {
+		link += "/" + url.PathEscape(id)
+	}
+	return link
+}
+
+// headers of the requests that are made without the sdk
+func (ant *Anthropic) apiHeader() http.Header {
+	return http.Header{
+		"X-Api-Key":         {ant.ApiKey},
+		"Anthropic-Version": {claudeAPIVersion},
+	}
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// a stand-in for anthropic's message batches api. the batch is described by batch and its results are results
+func claudeBatchStandIn(t *testing.T, batch map[string]interface{}, results string) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Api-Key"); got != "ak" {
+			t.Errorf("X-Api-Key header is %q, want ak", got)
+		}
+		if got := r.Header.Get("Anthropic-Version"); got != claudeAPIVersion {
+			t.Errorf("Anthropic-Version header is %q, want %s", got, claudeAPIVersion)
+		}
+		switch r.URL.Path {
+		case "/v1/messages/batches/msgbatch_1":
+			if batch["processing_status"] == "ended" {
+				batch["results_url"] = server.URL + "/v1/messages/batches/msgbatch_1/results"
+			}
+			writeJSON(t, w, batch)
+		case "/v1/messages/batches/msgbatch_1/results":
+			w.Write([]byte(results))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestClaude(server *httptest.Server) *Anthropic {
+	ant := NewClaude("ak", "", "", "", "claude-3-5-sonnet-20240620", 0.7, 0.9, nil, nil)
+	ant.Url = server.URL
+	ant.HTTPClient = server.Client()
+	return ant
+}
+
+func TestClaudeSubmitBatch(t *testing.T) {
+	var body struct {
+		Requests []struct {
+			CustomID string `json:"custom_id"`
+			Params   struct {
+				Model    string `json:"model"`
+				System   string `json:"system"`
+				Messages []struct {
+					Role    string `json:"role"`
+					Content string `json:"content"`
+				} `json:"messages"`
+			} `json:"params"`
+		} `json:"requests"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/messages/batches" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		readJSON(t, r, &body)
+		writeJSON(t, w, map[string]string{"id": "msgbatch_1", "type": "message_batch", "processing_status": "in_progress"})
+	}))
+	defer server.Close()
+
+	id, err := newTestClaude(server).SubmitBatch(context.Background(), []chat.BatchRequest{
+		{ID: "request-0", Pattern: "summarize", Message: "first"},
+		{ID: "request-1", Pattern: "extract", Message: "second"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "msgbatch_1" {
+		t.Errorf("the batch id is %s, want msgbatch_1", id)
+	}
+	if len(body.Requests) != 2 {
+		t.Fatalf("the batch has %d requests, want 2", len(body.Requests))
+	}
+	for i, want := range []struct{ id, pattern, message string }{{"request-0", "summarize", "first"}, {"request-1", "extract", "second"}} {
+		request := body.Requests[i]
+		if request.CustomID != want.id || request.Params.Model != "claude-3-5-sonnet-20240620" || request.Params.System != want.pattern {
+			t.Errorf("request %d is %+v", i, request)
+		}
+		if len(request.Params.Messages) != 1 || request.Params.Messages[0].Role != "user" || request.Params.Messages[0].Content != want.message {
+			t.Errorf("request %d has the messages %+v, want the message %s", i, request.Params.Messages, want.message)
+		}
+	}
+}
+
+func TestClaudeBatchStatus(t *testing.T) {
+	cancelled := "2024-10-01T00:00:00Z"
+	for _, test := range []struct {
+		name   string
+		batch  map[string]interface{}
+		status chat.BatchStatus
+	}{
+		{"in progress", map[string]interface{}{"processing_status": "in_progress", "request_counts": map[string]int{"processing": 2, "succeeded": 1}},
+			chat.BatchStatus{State: chat.BatchRunning, Total: 3, Succeeded: 1}},
+		{"ended", map[string]interface{}{"processing_status": "ended", "request_counts": map[string]int{"succeeded": 2, "errored": 1}},
+			chat.BatchStatus{State: chat.BatchEnded, Total: 3, Succeeded: 2, Failed: 1}},
+		{"expired", map[string]interface{}{"processing_status": "ended", "request_counts": map[string]int{"succeeded": 1, "expired": 2}},
+			chat.BatchStatus{State: chat.BatchExpired, Total: 3, Succeeded: 1, Failed: 2}},
+		{"cancelled", map[string]interface{}{"processing_status": "ended", "cancel_initiated_at": cancelled, "request_counts": map[string]int{"succeeded": 1, "canceled": 1, "expired": 1}},
+			chat.BatchStatus{State: chat.BatchCancelled, Total: 3, Succeeded: 1, Failed: 2}},
+	} {
+		server := claudeBatchStandIn(t, test.batch, "")
+		status, err := newTestClaude(server).BatchStatus(context.Background(), "msgbatch_1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != test.status {
+			t.Errorf("%s: the status is %+v, want %+v", test.name, status, test.status)
+		}
+	}
+}
+
+func TestClaudeBatchResults(t *testing.T) {
+	results := `{"custom_id": "request-1", "result": {"type": "succeeded", "message": {"id": "msg_1", "model": "claude-3-5-sonnet-20240620", "content": [{"type": "text", "text": "two"}], "stop_reason": "end_turn", "usage": {"input_tokens": 5, "output_tokens": 1}}}}
+
+{"custom_id": "request-0", "result": {"type": "errored", "error": {"type": "error", "error": {"type": "invalid_request_error", "message": "prompt is too long"}}}}
+{"custom_id": "request-2", "result": {"type": "canceled"}}
+{"custom_id": "request-3", "result": {"type": "expired"}}
+`
+	server := claudeBatchStandIn(t, map[string]interface{}{"id": "msgbatch_1", "processing_status": "ended"}, results)
+	got, err := newTestClaude(server).BatchResults(context.Background(), "msgbatch_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d results, want 4", len(got))
+	}
+	want := chat.Response{Text: "two", FinishReason: chat.FinishStop, Usage: chat.Usage{InputTokens: 5, OutputTokens: 1}, Model: "claude-3-5-sonnet-20240620", RequestID: "msg_1"}
+	if got[0].ID != "request-1" || got[0].Err != nil || !reflect.DeepEqual(got[0].Response, want) {
+		t.Errorf("the first result is %+v, want %+v", got[0], want)
+	}
+	for i, want := range []struct{ id, err string }{{"request-0", "prompt is too long"}, {"request-2", "the request was canceled"}, {"request-3", "the request was expired"}} {
+		result := got[i+1]
+		if result.ID != want.id || result.Err == nil || result.Err.Error() != want.err {
+			t.Errorf("result %d is %+v, want %s to fail with %s", i+1, result, want.id, want.err)
+		}
+	}
+}
+
+func TestClaudeBatchResultsBeforeTheEnd(t *testing.T) {
+	server := claudeBatchStandIn(t, map[string]interface{}{"id": "msgbatch_1", "processing_status": "in_progress"}, "")
+	if _, err := newTestClaude(server).BatchResults(context.Background(), "msgbatch_1"); err == nil {
+		t.Error("a batch that is still running returned results")
+	}
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// the claude sdk can't send tools or read tool calls, so requests with tools, or with a schema, are written here
+type claudeToolRequest struct {
+	Model       string         `json:"model"`
+	MaxTokens   int            `json:"max_tokens"`
+	System      string         `json:"system,omitempty"`
+	Messages    []claudeBlocks `json:"messages"`
+	Tools       []claudeTool   `json:"tools"`
+	ToolChoice  interface{}    `json:"tool_choice,omitempty"`
+	Temperature float64        `json:"temperature"`
+	TopP        float64        `json:"top_p"`
+}
+
+type claudeBlocks struct {
+	Role    string        `json:"role"`
+	Content []interface{} `json:"content"`
+}
+
+type claudeTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type claudeToolUse struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+type claudeToolResult struct {
+	Type      string `json:"type"`
+	ToolUseID string `json:"tool_use_id"`
+	Content   string `json:"content"`
+	IsError   bool   `json:"is_error,omitempty"`
+}
+
+type claudeToolResponse struct {
+	ID         string `json:"id"`
+	Model      string `json:"model"`
+	StopReason string `json:"stop_reason"`
+	Content    []struct {
+		Type  string          `json:"type"`
+		Text  string          `json:"text"`
+		ID    string          `json:"id"`
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
+	} `json:"content"`
+	Usage struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+// claude has no json mode. a reply that must match a schema of an object is asked for as a call of this tool, whose input is the reply
+const claudeReplyTool = "json_reply"
+
+// sends the message with the tools and returns the calls claude wants to make along with its text
+func (ant *Anthropic) sendWithTools(ctx context.Context) (chat.Response, error) {
+	request := claudeToolRequest{
+		Model:       ant.Model,
+		MaxTokens:   4096,
+		System:      ant.Context + ant.Pattern,
+		Temperature: ant.Temperature,
+		TopP:        ant.TopP,
+	}
+	for _, tool := range ant.Tools {
+		request.Tools = append(request.Tools, claudeTool{Name: tool.Name, Description: tool.Description, InputSchema: tool.Parameters})
+	}
+	if schemaType(ant.Schema) == "object" {
+		request.Tools = append(request.Tools, claudeTool{Name: claudeReplyTool, Description: "Gives the reply as JSON. Call it to answer", InputSchema: ant.Schema})
+		// without other tools there is nothing to call first, so claude has to answer with it
+		if len(ant.Tools) == 0 {
+			request.ToolChoice = map[string]string{"type": "tool", "name": claudeReplyTool}
+		}
+	}
+	for _, message := range ant.messages() {
+		request.Messages = append(request.Messages, claudeToolMessage(message))
+	}
+	var res claudeToolResponse
+	if err := doJSON(ctx, ant.httpClient(), http.MethodPost, ant.baseURL()+"v1/messages", ant.apiHeader(), request, &res); err != nil {
+		return chat.Response{}, err
+	}
+	response := chat.Response{
+		FinishReason: claudeFinishReason(res.StopReason),
+		Usage:        chat.Usage{InputTokens: res.Usage.InputTokens, OutputTokens: res.Usage.OutputTokens},
+		Model:        res.Model,
+		RequestID:    res.ID,
+	}
+	var reply json.RawMessage
+	for _, content := range res.Content {
+		switch content.Type {
+		case "text":
+			response.Text += content.Text
+		case "tool_use":
+			if content.Name == claudeReplyTool && len(ant.Schema) > 0 {
+				reply = content.Input
+				continue
+			}
+			response.ToolCalls = append(response.ToolCalls, chat.ToolCall{ID: content.ID, Name: content.Name, Arguments: content.Input})
+		}
+	}
+	// the reply replaces whatever claude said before calling the tool
+	if reply != nil {
+		response.Text = string(reply)
+		response.FinishReason = chat.FinishStop
+	}
+	return response, nil
+}
+
+// builds the content blocks of a message. tool calls are tool_use blocks of the reply, and their results are tool_result blocks of the user's next message
+func claudeToolMessage(message chat.Message) claudeBlocks {
+	switch message.Role {
+	case chat.RoleTool:
+		result := claudeBlocks{Role: "user"}
+		for _, toolResult := range message.ToolResults {
+			result.Content = append(result.Content, claudeToolResult{Type: "tool_result", ToolUseID: toolResult.CallID, Content: toolResult.Content, IsError: toolResult.IsError})
+		}
+		return result
+	case chat.RoleAssistant:
+		result := claudeBlocks{Role: "assistant"}
+		if message.Content != "" {
+			result.Content = append(result.Content, map[string]string{"type": "text", "text": message.Content})
+		}
+		for _, call := range message.ToolCalls {
+			result.Content = append(result.Content, claudeToolUse{Type: "tool_use", ID: call.ID, Name: call.Name, Input: call.Arguments})
+		}
+		return result
+	}
+	// the blocks of user messages, with their images, are the ones sent without tools
+	base := claudeMessage(message.Role, message)
+	if blocks, ok := base.ContentRaw.([]interface{}); ok {
+		return claudeBlocks{Role: "user", Content: blocks}
+	}
+	return claudeBlocks{Role: "user", Content: []interface{}{map[string]string{"type": "text", "text": base.Content}}}
+}
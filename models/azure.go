@@ -0,0 +1,182 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// Azure sends openai models to the deployments of an azure openai resource. models are chosen by the name of the model they deploy, e.g. azure/gpt-4o, so prices and context windows are known, and sent to the deployment of that model
+type Azure struct {
+	DefaultModel
+	APIVersion  string
+	Models      []string          // the models of AZURE_OPENAI_DEPLOYMENTS, in the order they were given
+	Deployments map[string]string // model name to deployment name
+}
+
+// registers azure openai with the chat package. its models are only found by their listing or as azure/model, because they have the same names as openai's
+func init() {
+	chat.Register(chat.Provider{
+		Name: "azure",
+		Keys: []chat.ConfigKey{
+			{Name: "AZURE_OPENAI_API_KEY", Prompt: "Enter your Azure OpenAI API key: (Leave blank if you don't have one)"},
+			{Name: "AZURE_OPENAI_ENDPOINT", Prompt: "Enter your Azure OpenAI endpoint, e.g. https://mycompany.openai.azure.com: (Leave blank if you don't have one)"},
+			{Name: "AZURE_OPENAI_DEPLOYMENTS", Prompt: "Enter your Azure OpenAI deployments as model=deployment, separated by commas, e.g. gpt-4o=prod-gpt4o,gpt-4o-mini: (a deployment named after its model needs no =)"},
+			{Name: "AZURE_OPENAI_API_VERSION", Default: "2024-10-21"}, // the first stable version that streams the usage
+		},
+		New: func(c chat.Chat) chat.Model {
+			model := NewAzure(c.ConfigValue("AZURE_OPENAI_API_KEY"), c.Message, c.Pattern, c.Context, c.Model, c.Temperature, c.TopP, c.PresencePenalty, c.FrequencyPenalty, c.Session, c.ResponseChan)
+			model.Attachments = c.Attachments
+			model.Tools = c.Tools
+			model.Schema = c.Schema
+			model.Url = c.ConfigValue("AZURE_OPENAI_ENDPOINT")
+			model.APIVersion = c.ConfigValue("AZURE_OPENAI_API_VERSION")
+			model.Models, model.Deployments = azureDeployments(c.ConfigValue("AZURE_OPENAI_DEPLOYMENTS"))
+			return model
+		},
+		Tools: true,
+	})
+}
+
+func NewAzure(apiKey string, message string, pattern string, context string, model string, temperature float64, topP float64, presencePenalty float64, frequencyPenalty float64, session []chat.Message, responseChan chan chat.StreamEvent) *Azure {
+	return &Azure{
+		DefaultModel: DefaultModel{
+			Message:          message,
+			Pattern:          pattern,
+			Context:          context,
+			Model:            model,
+			ApiKey:           apiKey,
+			Temperature:      temperature,
+			TopP:             topP,
+			PresencePenalty:  presencePenalty,
+			FrequencyPenalty: frequencyPenalty,
+			Session:          session,
+			ResponseChan:     responseChan,
+		},
+	}
+}
+
+// sends the message and returns the answer. azure names the model that answered, so the response is priced as that model whatever the deployment is called
+func (az *Azure) SendMessage(ctx context.Context) (chat.Response, error) {
+	if az.Context != "" {
+		az.Context = "CONTEXT:\n" + az.Context + "\n" // set context to "CONTEXT:\n[context]"
+	}
+	recorder := &statusRecorder{}
+	client, err := az.buildClient(recorder)
+	if err != nil {
+		return chat.Response{}, err
+	}
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:            az.Model,
+		Temperature:      float32(az.Temperature),
+		TopP:             float32(az.TopP),
+		PresencePenalty:  float32(az.PresencePenalty),
+		FrequencyPenalty: float32(az.FrequencyPenalty),
+		Messages:         createOpenaiMessages(az.DefaultModel),
+		Tools:            openaiTools(az.Tools),
+		ResponseFormat:   openaiResponseFormat(az.Schema),
+	})
+	if err != nil {
+		return chat.Response{}, recorder.wrap(err)
+	}
+	return openaiResponse(resp), nil
+}
+
+// streams the answer to the response channel. the first chunk of an azure stream only has the results of the content filter, and no choices
+func (az *Azure) StreamMessage(ctx context.Context) (chat.Response, error) {
+	if az.Context != "" {
+		az.Context = "CONTEXT:\n" + az.Context + "\n" // set context to CONTEXT\n[context]
+	}
+	recorder := &statusRecorder{}
+	client, err := az.buildClient(recorder)
+	if err != nil {
+		return chat.Response{}, err
+	}
+	stream, err := client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model:            az.Model,
+		Temperature:      float32(az.Temperature),
+		TopP:             float32(az.TopP),
+		PresencePenalty:  float32(az.PresencePenalty),
+		FrequencyPenalty: float32(az.FrequencyPenalty),
+		Messages:         createOpenaiMessages(az.DefaultModel),
+		Stream:           true,
+		StreamOptions:    &openai.StreamOptions{IncludeUsage: true},
+	})
+	if err != nil {
+		return chat.Response{}, recorder.wrap(fmt.Errorf("ChatCompletionStream error: %w", err))
+	}
+	defer stream.Close()
+	result := chat.Response{RequestID: stream.Header().Get("x-request-id")}
+	for {
+		response, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			az.ResponseChan <- chat.StreamEvent{Text: "\n"}
+			return result, nil
+		}
+		if err != nil {
+			return result, recorder.wrap(fmt.Errorf("stream error: %w", err))
+		}
+		addOpenaiStreamResponse(&result, response)
+		if len(response.Choices) > 0 {
+			az.ResponseChan <- chat.StreamEvent{Text: response.Choices[0].Delta.Content}
+		}
+	}
+}
+
+// returns the models of AZURE_OPENAI_DEPLOYMENTS. listing the deployments themselves needs the management api, which the api key can't use. there are none when azure isn't set up
+func (az *Azure) ListModels(ctx context.Context) ([]string, error) {
+	if az.Url == "" {
+		return []string{}, nil
+	}
+	return append([]string{}, az.Models...), nil
+}
+
+// builds a client that sends the api key in the api-key header and every model to its deployment
+func (az *Azure) buildClient(recorder *statusRecorder) (*openai.Client, error) {
+	if az.Url == "" {
+		return nil, errors.New("no azure openai endpoint. Set AZURE_OPENAI_ENDPOINT with the setup")
+	}
+	config := openai.DefaultAzureConfig(az.ApiKey, strings.TrimSuffix(az.Url, "/"))
+	if az.APIVersion != "" {
+		config.APIVersion = az.APIVersion
+	}
+	config.AzureModelMapperFunc = az.deployment
+	if recorder != nil {
+		config.HTTPClient = recorder.client()
+	}
+	return openai.NewClientWithConfig(config), nil
+}
+
+// returns the deployment of the model. a model that isn't in AZURE_OPENAI_DEPLOYMENTS is taken to be the name of a deployment
+func (az *Azure) deployment(model string) string {
+	if deployment, ok := az.Deployments[model]; ok {
+		return deployment
+	}
+	return model
+}
+
+// reads AZURE_OPENAI_DEPLOYMENTS, e.g. gpt-4o=prod-gpt4o,gpt-4o-mini
+func azureDeployments(value string) ([]string, map[string]string) {
+	var models []string
+	deployments := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		model, deployment, found := strings.Cut(strings.TrimSpace(entry), "=")
+		model, deployment = strings.TrimSpace(model), strings.TrimSpace(deployment)
+		if !found {
+			deployment = model
+		}
+		if model == "" || deployment == "" {
+			continue
+		}
+		if _, ok := deployments[model]; !ok {
+			models = append(models, model)
+		}
+		deployments[model] = deployment
+	}
+	return models, deployments
+}
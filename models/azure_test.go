@@ -0,0 +1,133 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// a stand-in for an azure openai resource. it checks what every request must have and hands the request to handle
+func azureStandIn(t *testing.T, deployment string, handle func(w http.ResponseWriter, request openaiTestRequest)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want := "/openai/deployments/" + deployment + "/chat/completions"; r.URL.Path != want {
+			t.Errorf("path is %s, want %s", r.URL.Path, want)
+		}
+		if got := r.URL.Query().Get("api-version"); got != "2024-10-21" {
+			t.Errorf("api-version is %q, want 2024-10-21", got)
+		}
+		if got := r.Header.Get("api-key"); got != "azkey" {
+			t.Errorf("api-key header is %q, want azkey", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("azure was sent an Authorization header %q", got)
+		}
+		var request openaiTestRequest
+		readJSON(t, r, &request)
+		handle(w, request)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestAzure(url string, model string, responseChan chan chat.StreamEvent) *Azure {
+	azure := NewAzure("azkey", "and 3+3?", "be brief", "", model, 0.7, 0.9, 0, 0, testSession, responseChan)
+	azure.Url = url
+	azure.APIVersion = "2024-10-21"
+	azure.Models, azure.Deployments = azureDeployments("gpt-4o=prod-gpt4o, gpt-4o-mini")
+	return azure
+}
+
+func TestAzureDeployments(t *testing.T) {
+	models, deployments := azureDeployments(" gpt-4o = prod-gpt4o,gpt-4o-mini,,=nameless,gpt-4o=prod-gpt4o-2")
+	if want := []string{"gpt-4o", "gpt-4o-mini"}; !reflect.DeepEqual(models, want) {
+		t.Errorf("models are %v, want %v", models, want)
+	}
+	if want := map[string]string{"gpt-4o": "prod-gpt4o-2", "gpt-4o-mini": "gpt-4o-mini"}; !reflect.DeepEqual(deployments, want) {
+		t.Errorf("deployments are %v, want %v", deployments, want)
+	}
+	azure := &Azure{Deployments: deployments}
+	if got := azure.deployment("gpt-35-turbo"); got != "gpt-35-turbo" {
+		t.Errorf("a model without a deployment is sent to %s, want its own name", got)
+	}
+}
+
+func TestAzureSendMessage(t *testing.T) {
+	server := azureStandIn(t, "prod-gpt4o", func(w http.ResponseWriter, request openaiTestRequest) {
+		request.checkReplayed(t)
+		w.Header().Set("x-request-id", "req-1")
+		writeJSON(t, w, map[string]interface{}{
+			"id":      "az-1",
+			"object":  "chat.completion",
+			"model":   "gpt-4o-2024-08-06",
+			"choices": []interface{}{map[string]interface{}{"index": 0, "message": map[string]string{"role": "assistant", "content": "6"}, "finish_reason": "stop"}},
+			"usage":   map[string]int{"prompt_tokens": 20, "completion_tokens": 1},
+		})
+	})
+	response, err := newTestAzure(server.URL+"/", "gpt-4o", nil).SendMessage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := chat.Response{Text: "6", FinishReason: chat.FinishStop, Usage: chat.Usage{InputTokens: 20, OutputTokens: 1}, Model: "gpt-4o-2024-08-06", RequestID: "req-1"}
+	if !reflect.DeepEqual(response, want) {
+		t.Errorf("response is %+v, want %+v", response, want)
+	}
+}
+
+func TestAzureStreamMessage(t *testing.T) {
+	server := azureStandIn(t, "gpt-4o-mini", func(w http.ResponseWriter, request openaiTestRequest) {
+		if !request.Stream {
+			t.Error("the request doesn't ask for a stream")
+		}
+		request.checkReplayed(t)
+		writeEvents(t, w,
+			// azure starts with the results of its content filter, which have no choices
+			map[string]interface{}{"id": "", "model": "", "choices": []interface{}{}, "prompt_filter_results": []interface{}{map[string]int{"prompt_index": 0}}},
+			map[string]interface{}{"id": "az-2", "model": "gpt-4o-mini-2024-07-18", "choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]string{"content": "six"}}}},
+			map[string]interface{}{"id": "az-2", "model": "gpt-4o-mini-2024-07-18", "choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]string{}, "finish_reason": "stop"}}},
+			map[string]interface{}{"id": "az-2", "model": "gpt-4o-mini-2024-07-18", "choices": []interface{}{}, "usage": map[string]int{"prompt_tokens": 20, "completion_tokens": 1}},
+		)
+		w.Write([]byte("data: [DONE]\n\n"))
+	})
+	responseChan := make(chan chat.StreamEvent)
+	text := collectStream(responseChan)
+	response, err := newTestAzure(server.URL, "gpt-4o-mini", responseChan).StreamMessage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := text(); got != "six\n" {
+		t.Errorf("streamed %q, want %q", got, "six\n")
+	}
+	if response.Model != "gpt-4o-mini-2024-07-18" || response.FinishReason != chat.FinishStop || response.Usage != (chat.Usage{InputTokens: 20, OutputTokens: 1}) {
+		t.Errorf("response is %+v", response)
+	}
+}
+
+func TestAzureError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error": {"code": "429", "message": "Rate limit is exceeded."}}`))
+	}))
+	defer server.Close()
+	_, err := newTestAzure(server.URL, "gpt-4o", nil).SendMessage(context.Background())
+	var status *chat.StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("error is %v, want a status error with 429", err)
+	}
+}
+
+func TestAzureListModels(t *testing.T) {
+	models, err := newTestAzure("", "", nil).ListModels(context.Background())
+	if err != nil || len(models) != 0 {
+		t.Errorf("without an endpoint ListModels returned %v, %v, want no models and no error", models, err)
+	}
+	models, err = newTestAzure("https://example.openai.azure.com", "", nil).ListModels(context.Background())
+	if want := []string{"gpt-4o", "gpt-4o-mini"}; err != nil || !reflect.DeepEqual(models, want) {
+		t.Errorf("ListModels returned %v, %v, want %v", models, err, want)
+	}
+}
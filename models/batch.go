@@ -0,0 +1,72 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// sends a request to a batch api with the client and decodes the json answer into out. the sdks don't cover every batch endpoint, so they are called directly. error statuses are returned as a chat.StatusError with the provider's message
+func doJSON(ctx context.Context, client *http.Client, method string, url string, header http.Header, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		contents, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(contents)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return &chat.StatusError{StatusCode: resp.StatusCode, RetryAfter: retryAfter(resp.StatusCode, resp.Header), Err: apiError(resp)}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("could not read the answer of %s: %v", url, err)
+	}
+	return nil
+}
+
+// openai and anthropic both put the message of an error under error.message. cohere puts it in message
+func apiError(resp *http.Response) error {
+	contents, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(contents, &body) == nil && body.Error.Message != "" {
+		return fmt.Errorf("%s: %s", resp.Status, body.Error.Message)
+	}
+	if body.Message != "" {
+		return fmt.Errorf("%s: %s", resp.Status, body.Message)
+	}
+	if text := strings.TrimSpace(string(contents)); text != "" {
+		return fmt.Errorf("%s: %s", resp.Status, text)
+	}
+	return errors.New(resp.Status)
+}
@@ -0,0 +1,276 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+type Cohere struct {
+	DefaultModel
+}
+
+// registers cohere with the chat package. its chat models are named command, command-r and so on
+func init() {
+	chat.Register(chat.Provider{
+		Name: "cohere",
+		Keys: []chat.ConfigKey{
+			{Name: "COHERE_API_KEY", Prompt: "Enter your Cohere API key: (Leave blank if you don't have one)"},
+			{Name: "COHERE_BASE_URL", Default: "https://api.cohere.com"}, // only needs changing for a proxy or a local stand-in
+		},
+		Matches: func(model string) bool {
+			return !strings.Contains(model, ":") && (model == "command" || strings.HasPrefix(model, "command-"))
+		},
+		New: func(c chat.Chat) chat.Model {
+			model := NewCohere(c.ConfigValue("COHERE_API_KEY"), c.Message, c.Pattern, c.Context, c.Model, c.Temperature, c.TopP, c.PresencePenalty, c.FrequencyPenalty, c.Session, c.ResponseChan)
+			model.Schema = c.Schema
+			model.Url = c.ConfigValue("COHERE_BASE_URL")
+			return model
+		},
+		Priced: true,
+	})
+}
+
+func NewCohere(apiKey string, message string, pattern string, context string, model string, temperature float64, topP float64, presencePenalty float64, frequencyPenalty float64, session []chat.Message, responseChan chan chat.StreamEvent) *Cohere {
+	return &Cohere{
+		DefaultModel{
+			Message:          message,
+			Pattern:          pattern,
+			Context:          context,
+			Model:            model,
+			ApiKey:           apiKey,
+			Temperature:      temperature,
+			TopP:             topP,
+			PresencePenalty:  presencePenalty,
+			FrequencyPenalty: frequencyPenalty,
+			Session:          session,
+			ResponseChan:     responseChan,
+		},
+	}
+}
+
+// a request to cohere's v2 chat api
+type cohereRequest struct {
+	Model            string          `json:"model"`
+	Messages         []cohereMessage `json:"messages"`
+	Temperature      float64         `json:"temperature"`
+	P                float64         `json:"p,omitempty"` // cohere only takes values between 0.01 and 0.99
+	PresencePenalty  float64         `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"`
+	ResponseFormat   interface{}     `json:"response_format,omitempty"`
+	Stream           bool            `json:"stream,omitempty"`
+}
+
+type cohereMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type cohereUsage struct {
+	BilledUnits struct {
+		InputTokens  float64 `json:"input_tokens"`
+		OutputTokens float64 `json:"output_tokens"`
+	} `json:"billed_units"`
+}
+
+type cohereResponse struct {
+	ID           string `json:"id"`
+	FinishReason string `json:"finish_reason"`
+	Message      struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"message"`
+	Usage cohereUsage `json:"usage"`
+}
+
+// an event of a stream. the text comes in content-delta events and the finish reason and the usage in the message-end event
+type cohereStreamEvent struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Delta struct {
+		Message struct {
+			Content struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+		Usage        cohereUsage `json:"usage"`
+	} `json:"delta"`
+}
+
+// sends the message and returns the answer
+func (co *Cohere) SendMessage(ctx context.Context) (chat.Response, error) {
+	if co.Context != "" {
+		co.Context = "CONTEXT:\n" + co.Context + "\n" // set context to "CONTEXT:\n[context]"
+	}
+	var res cohereResponse
+	if err := doJSON(ctx, co.httpClient(), http.MethodPost, co.baseURL()+"/v2/chat", co.header(), co.request(false), &res); err != nil {
+		return chat.Response{}, err
+	}
+	response := chat.Response{
+		FinishReason: cohereFinishReason(res.FinishReason),
+		Usage:        res.Usage.toUsage(),
+		Model:        co.Model,
+		RequestID:    res.ID,
+	}
+	for _, content := range res.Message.Content {
+		if content.Type == "text" {
+			response.Text += content.Text
+		}
+	}
+	return response, nil
+}
+
+// streams the answer to the response channel
+func (co *Cohere) StreamMessage(ctx context.Context) (chat.Response, error) {
+	if co.Context != "" {
+		co.Context = "CONTEXT:\n" + co.Context + "\n" // set context to CONTEXT\n[context]
+	}
+	body, err := json.Marshal(co.request(true))
+	if err != nil {
+		return chat.Response{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, co.baseURL()+"/v2/chat", bytes.NewReader(body))
+	if err != nil {
+		return chat.Response{}, err
+	}
+	req.Header = co.header()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := co.httpClient().Do(req)
+	if err != nil {
+		return chat.Response{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return chat.Response{}, &chat.StatusError{StatusCode: resp.StatusCode, RetryAfter: retryAfter(resp.StatusCode, resp.Header), Err: apiError(resp)}
+	}
+	result := chat.Response{Model: co.Model}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event cohereStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return result, fmt.Errorf("cohere: could not read the stream: %v", err)
+		}
+		switch event.Type {
+		case "message-start":
+			result.RequestID = event.ID
+		case "content-delta":
+			co.ResponseChan <- chat.StreamEvent{Text: event.Delta.Message.Content.Text}
+		case "message-end":
+			result.FinishReason = cohereFinishReason(event.Delta.FinishReason)
+			result.Usage = event.Delta.Usage.toUsage()
+			co.ResponseChan <- chat.StreamEvent{Text: "\n"}
+			return result, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	return result, errors.New("cohere: the stream ended before the response was complete")
+}
+
+// returns the models that can chat
+func (co *Cohere) ListModels(ctx context.Context) ([]string, error) {
+	if co.ApiKey == "" {
+		return []string{}, errors.New("no cohere api key")
+	}
+	var modelList []string
+	pageToken := ""
+	for {
+		query := url.Values{"endpoint": {"chat"}, "page_size": {"1000"}}
+		if pageToken != "" {
+			query.Set("page_token", pageToken)
+		}
+		var page struct {
+			Models []struct {
+				Name string `json:"name"`
+			} `json:"models"`
+			NextPageToken string `json:"next_page_token"`
+		}
+		if err := doJSON(ctx, co.httpClient(), http.MethodGet, co.baseURL()+"/v1/models?"+query.Encode(), co.header(), nil, &page); err != nil {
+			return []string{}, err
+		}
+		for _, model := range page.Models {
+			modelList = append(modelList, model.Name)
+		}
+		if page.NextPageToken == "" {
+			return modelList, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// builds the request from the pattern, the session and the new message. cohere can't read images, so only the text is sent
+func (co *Cohere) request(stream bool) cohereRequest {
+	request := cohereRequest{
+		Model:            co.Model,
+		Temperature:      co.Temperature,
+		PresencePenalty:  co.PresencePenalty,
+		FrequencyPenalty: co.FrequencyPenalty,
+		Stream:           stream,
+	}
+	if co.TopP >= 0.01 && co.TopP <= 0.99 {
+		request.P = co.TopP
+	}
+	if schemaType(co.Schema) == "object" {
+		request.ResponseFormat = map[string]interface{}{"type": "json_object", "json_schema": co.Schema}
+	}
+	if co.Context+co.Pattern != "" {
+		request.Messages = append(request.Messages, cohereMessage{Role: "system", Content: co.Context + co.Pattern})
+	}
+	for _, message := range co.messages() {
+		role := "user"
+		if message.Role == chat.RoleAssistant {
+			role = "assistant"
+		}
+		request.Messages = append(request.Messages, cohereMessage{Role: role, Content: message.Content})
+	}
+	return request
+}
+
+func (co *Cohere) baseURL() string {
+	if co.Url == "" {
+		return "https://api.cohere.com"
+	}
+	return strings.TrimSuffix(co.Url, "/")
+}
+
+func (co *Cohere) header() http.Header {
+	return http.Header{
+		"Authorization": {"Bearer " + co.ApiKey},
+		"Accept":        {"application/json"},
+	}
+}
+
+// cohere reports the tokens as floating point numbers
+func (usage cohereUsage) toUsage() chat.Usage {
+	return chat.Usage{InputTokens: int(usage.BilledUnits.InputTokens), OutputTokens: int(usage.BilledUnits.OutputTokens)}
+}
+
+// maps cohere's finish reasons to the chat package's
+func cohereFinishReason(reason string) string {
+	switch reason {
+	case "COMPLETE", "STOP_SEQUENCE":
+		return chat.FinishStop
+	case "MAX_TOKENS":
+		return chat.FinishLength
+	case "TOOL_CALL":
+		return chat.FinishToolCalls
+	}
+	return strings.ToLower(reason)
+}
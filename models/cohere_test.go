@@ -0,0 +1,131 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// a stand-in for cohere's api. the models are listed one per page, to check that every page is read
+func cohereStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer ck" {
+			t.Errorf("Authorization header is %q, want Bearer ck", got)
+		}
+		switch r.URL.Path {
+		case "/v1/models":
+			if got := r.URL.Query().Get("endpoint"); got != "chat" {
+				t.Errorf("models are listed for the endpoint %q, want chat", got)
+			}
+			if r.URL.Query().Get("page_token") == "" {
+				writeJSON(t, w, map[string]interface{}{"models": []interface{}{map[string]string{"name": "command-r-plus"}}, "next_page_token": "2"})
+				return
+			}
+			writeJSON(t, w, map[string]interface{}{"models": []interface{}{map[string]string{"name": "command-r"}}})
+		case "/v2/chat":
+			var request cohereRequest
+			readJSON(t, r, &request)
+			var roles, contents []string
+			for _, message := range request.Messages {
+				roles = append(roles, message.Role)
+				contents = append(contents, message.Content)
+			}
+			checkReplayed(t, roles, contents)
+			if request.P != 0 {
+				t.Errorf("p is %v, but 1 is outside what cohere takes and must not be sent", request.P)
+			}
+			if request.Model == "missing" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message": "model 'missing' not found"}`))
+				return
+			}
+			if request.Stream {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for _, event := range []string{
+					`{"type": "message-start", "id": "co-2"}`,
+					`{"type": "content-start", "index": 0}`,
+					`{"type": "content-delta", "index": 0, "delta": {"message": {"content": {"text": "si"}}}}`,
+					`{"type": "content-delta", "index": 0, "delta": {"message": {"content": {"text": "x"}}}}`,
+					`{"type": "message-end", "delta": {"finish_reason": "MAX_TOKENS", "usage": {"billed_units": {"input_tokens": 9.0, "output_tokens": 2.0}}}}`,
+				} {
+					var typed struct{ Type string }
+					json.Unmarshal([]byte(event), &typed)
+					fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typed.Type, event)
+				}
+				return
+			}
+			writeJSON(t, w, map[string]interface{}{
+				"id":            "co-1",
+				"finish_reason": "COMPLETE",
+				"message":       map[string]interface{}{"role": "assistant", "content": []interface{}{map[string]string{"type": "text", "text": "6"}}},
+				"usage":         map[string]interface{}{"billed_units": map[string]float64{"input_tokens": 9, "output_tokens": 1}},
+			})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestCohere(url string, model string, responseChan chan chat.StreamEvent) *Cohere {
+	cohere := NewCohere("ck", "and 3+3?", "be brief", "", model, 0.3, 1, 0, 0, testSession, responseChan)
+	cohere.Url = url
+	return cohere
+}
+
+func TestCohereSendMessage(t *testing.T) {
+	server := cohereStandIn(t)
+	response, err := newTestCohere(server.URL+"/", "command-r", nil).SendMessage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := chat.Response{Text: "6", FinishReason: chat.FinishStop, Usage: chat.Usage{InputTokens: 9, OutputTokens: 1}, Model: "command-r", RequestID: "co-1"}
+	if !reflect.DeepEqual(response, want) {
+		t.Errorf("response is %+v, want %+v", response, want)
+	}
+}
+
+func TestCohereStreamMessage(t *testing.T) {
+	server := cohereStandIn(t)
+	responseChan := make(chan chat.StreamEvent)
+	text := collectStream(responseChan)
+	response, err := newTestCohere(server.URL, "command-r", responseChan).StreamMessage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := text(); got != "six\n" {
+		t.Errorf("streamed %q, want %q", got, "six\n")
+	}
+	want := chat.Response{FinishReason: chat.FinishLength, Usage: chat.Usage{InputTokens: 9, OutputTokens: 2}, Model: "command-r", RequestID: "co-2"}
+	if !reflect.DeepEqual(response, want) {
+		t.Errorf("response is %+v, want %+v", response, want)
+	}
+}
+
+func TestCohereError(t *testing.T) {
+	server := cohereStandIn(t)
+	_, err := newTestCohere(server.URL, "missing", nil).SendMessage(context.Background())
+	var status *chat.StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusNotFound || !strings.Contains(err.Error(), "model 'missing' not found") {
+		t.Errorf("error is %v, want a 404 with cohere's message", err)
+	}
+}
+
+func TestCohereListModels(t *testing.T) {
+	server := cohereStandIn(t)
+	models, err := newTestCohere(server.URL, "", nil).ListModels(context.Background())
+	if want := []string{"command-r-plus", "command-r"}; err != nil || !reflect.DeepEqual(models, want) {
+		t.Errorf("ListModels returned %v, %v, want %v", models, err, want)
+	}
+}
@@ -1,17 +1,36 @@
 package models
 
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
 // the default struct that the models will be based on
 type DefaultModel struct {
 	Message string
+	Attachments []chat.Attachment // images sent along with the message
+	Tools []chat.Tool // tools the model may call
+	Schema json.RawMessage // json schema the reply must match. providers with a json mode use it, the chat package checks the reply either way
 	Pattern string
     ApiKey string
 	Context string
-	Session []map[string]string
+	Session []chat.Message
 	Model   string
 	Url     string
     Temperature float64
 	TopP float64
 	PresencePenalty float64
 	FrequencyPenalty float64
-	ResponseChan chan string
+	ResponseChan chan chat.StreamEvent
+	HTTPClient *http.Client // client of the requests made without an sdk. nil uses http.DefaultClient, tests set it to reach a stand-in server
+}
+
+// returns the client of the requests made without an sdk
+func (model DefaultModel) httpClient() *http.Client {
+	if model.HTTPClient != nil {
+		return model.HTTPClient
+	}
+	return http.DefaultClient
 }
\ No newline at end of file
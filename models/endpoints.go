@@ -0,0 +1,145 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// Endpoint is a server that speaks the openai api, such as vllm, llama.cpp, lm studio, openrouter or a company gateway. Endpoints are kept in ~/.config/fabric/endpoints.json, keyed by their name, e.g.
+//
+//	{"office-vllm": {"base_url": "http://10.0.0.5:8000/v1", "models": ["llama3-70b"]},
+//	 "openrouter": {"base_url": "https://openrouter.ai/api/v1", "headers": {"X-Title": "fabric"}}}
+//
+// Every endpoint is a provider of its own, so its models are chosen as office-vllm/llama3-70b. The api key can be given here, or in the .env file as OFFICE_VLLM_API_KEY
+type Endpoint struct {
+	Name    string            `json:"-"`
+	BaseURL string            `json:"base_url"`
+	APIKey  string            `json:"api_key,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"` // sent with every request
+	Models  []string          `json:"models,omitempty"`  // listed instead of asking the server, for servers that can't list their models
+}
+
+// endpoint names become provider names and .env keys, so they are kept simple
+var endpointName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// name of the .env key that holds the api key of the endpoint, e.g. OFFICE_VLLM_API_KEY
+func (endpoint Endpoint) keyName() string {
+	return chat.KeyPrefix(endpoint.Name) + "_API_KEY"
+}
+
+// RegisterEndpoints registers every endpoint of ~/.config/fabric/endpoints.json as a provider. It runs after the built in providers are registered, so an endpoint can't take their names
+func RegisterEndpoints() error {
+	endpoints, err := LoadEndpoints()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, endpoint := range endpoints {
+		if err := endpointClash(endpoint); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		registerEndpoint(endpoint)
+	}
+	return errors.Join(errs...)
+}
+
+// returns an error when the endpoint would take the name or a .env key of a provider that is already registered. the keys of an endpoint are its own, so writing them can't change another provider's
+func endpointClash(endpoint Endpoint) error {
+	if _, ok := chat.GetProvider(endpoint.Name); ok {
+		return fmt.Errorf("endpoints.json: %s is already the name of a provider, choose another", endpoint.Name)
+	}
+	prefix := chat.KeyPrefix(endpoint.Name)
+	names := []string{endpoint.keyName(), prefix + "_TIMEOUT", prefix + "_MAX_RETRIES"}
+	for _, key := range chat.ConfigKeys() {
+		for _, name := range names {
+			if key.Name == name {
+				return fmt.Errorf("endpoints.json: endpoint %s would use the setting %s, which is already taken, choose another name", endpoint.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+func registerEndpoint(endpoint Endpoint) {
+	chat.Register(chat.Provider{
+		Name: endpoint.Name,
+		Keys: []chat.ConfigKey{
+			{Name: endpoint.keyName()}, // overrides the api_key of endpoints.json, so it doesn't have to be kept there
+		},
+		New: func(c chat.Chat) chat.Model {
+			apiKey := c.ConfigValue(endpoint.keyName())
+			if apiKey == "" {
+				apiKey = endpoint.APIKey
+			}
+			model := NewOpenai(apiKey, c.Message, c.Pattern, c.Context, c.Model, c.Temperature, c.TopP, c.PresencePenalty, c.FrequencyPenalty, c.Session, c.ResponseChan)
+			model.Attachments = c.Attachments
+			model.Tools = c.Tools
+			model.Schema = c.Schema
+			model.Url = endpoint.BaseURL
+			model.Headers = endpoint.Headers
+			model.Models = endpoint.Models
+			return model
+		},
+		Tools: true,
+	})
+}
+
+// LoadEndpoints reads the endpoints of ~/.config/fabric/endpoints.json, sorted by name. There are none when the file doesn't exist
+func LoadEndpoints() ([]Endpoint, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	contents, err := os.ReadFile(filepath.Join(homeDir, ".config/fabric/endpoints.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseEndpoints(contents)
+}
+
+// reads the endpoints of the contents of endpoints.json, sorted by name
+func parseEndpoints(contents []byte) ([]Endpoint, error) {
+	var byName map[string]Endpoint
+	if err := json.Unmarshal(contents, &byName); err != nil {
+		return nil, fmt.Errorf("could not read endpoints.json: %v", err)
+	}
+	endpoints := make([]Endpoint, 0, len(byName))
+	for name, endpoint := range byName {
+		if !endpointName.MatchString(name) {
+			return nil, fmt.Errorf("endpoints.json: %q is not a valid endpoint name. Use letters, digits, _ and -", name)
+		}
+		if endpoint.BaseURL == "" {
+			return nil, fmt.Errorf("endpoints.json: endpoint %s has no base_url", name)
+		}
+		endpoint.Name = name
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Name < endpoints[j].Name })
+	return endpoints, nil
+}
+
+// headerTransport adds the headers of an endpoint to every request
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+func TestParseEndpoints(t *testing.T) {
+	endpoints, err := parseEndpoints([]byte(`{
+		"office-vllm": {"base_url": "http://10.0.0.5:8000/v1", "models": ["llama3-70b"]},
+		"gateway": {"base_url": "https://gw.example.com/v1", "api_key": "k", "headers": {"X-Team": "infra"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Endpoint{
+		{Name: "gateway", BaseURL: "https://gw.example.com/v1", APIKey: "k", Headers: map[string]string{"X-Team": "infra"}},
+		{Name: "office-vllm", BaseURL: "http://10.0.0.5:8000/v1", Models: []string{"llama3-70b"}},
+	}
+	if !reflect.DeepEqual(endpoints, want) {
+		t.Errorf("endpoints are %+v, want %+v", endpoints, want)
+	}
+
+	for _, test := range []struct {
+		contents string
+		problem  string
+	}{
+		{`{"a/b": {"base_url": "http://x"}}`, "not a valid endpoint name"},
+		{`{"a b": {"base_url": "http://x"}}`, "not a valid endpoint name"},
+		{`{"nourl": {"api_key": "k"}}`, "has no base_url"},
+		{`["http://x"]`, "could not read endpoints.json"},
+	} {
+		_, err := parseEndpoints([]byte(test.contents))
+		if err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("%s gave the error %v, want one saying %q", test.contents, err, test.problem)
+		}
+	}
+}
+
+func TestEndpointClash(t *testing.T) {
+	for name, clashes := range map[string]bool{
+		"openai":       true, // the name of a provider
+		"azure_openai": true, // its key would be AZURE_OPENAI_API_KEY
+		"x_openai":     false,
+		"office-vllm":  false,
+		"azure-openai": true, // the - becomes a _ in its keys
+	} {
+		if err := endpointClash(Endpoint{Name: name}); (err != nil) != clashes {
+			t.Errorf("endpointClash(%s) = %v, want a clash: %v", name, err, clashes)
+		}
+	}
+}
+
+func TestEndpointProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path is %s, want /v1/chat/completions", r.URL.Path)
+		}
+		if got := r.Header.Get("X-Team"); got != "infra" {
+			t.Errorf("X-Team header is %q, want infra", got)
+		}
+		// the key in the .env file wins over the one in endpoints.json
+		if got := r.Header.Get("Authorization"); got != "Bearer envkey" {
+			t.Errorf("Authorization header is %q, want Bearer envkey", got)
+		}
+		var request openaiTestRequest
+		readJSON(t, r, &request)
+		request.checkReplayed(t)
+		writeJSON(t, w, map[string]interface{}{
+			"id":      "cmpl-1",
+			"model":   request.Model,
+			"choices": []interface{}{map[string]interface{}{"index": 0, "message": map[string]string{"role": "assistant", "content": "6"}, "finish_reason": "stop"}},
+		})
+	}))
+	defer server.Close()
+
+	registerEndpoint(Endpoint{
+		Name:    "test-endpoint",
+		BaseURL: server.URL + "/v1/",
+		APIKey:  "filekey",
+		Headers: map[string]string{"X-Team": "infra"},
+		Models:  []string{"llama3-70b", "qwen2-72b"},
+	})
+	provider, ok := chat.GetProvider("test-endpoint")
+	if !ok {
+		t.Fatal("the endpoint was not registered")
+	}
+	model := provider.New(chat.Chat{
+		Config:  map[string]string{"TEST_ENDPOINT_API_KEY": "envkey"},
+		Model:   "llama3-70b",
+		Pattern: "be brief",
+		Message: "and 3+3?",
+		Session: testSession,
+	})
+	response, err := model.SendMessage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Text != "6" || response.Model != "llama3-70b" {
+		t.Errorf("response is %+v", response)
+	}
+	models, err := model.ListModels(context.Background())
+	if want := []string{"llama3-70b", "qwen2-72b"}; err != nil || !reflect.DeepEqual(models, want) {
+		t.Errorf("ListModels returned %v, %v, want the models of endpoints.json %v", models, err, want)
+	}
+}
+
+// the keys of an endpoint with a - in its name are written to the .env file, which must still load
+func TestEndpointKeys(t *testing.T) {
+	registerEndpoint(Endpoint{Name: "keys-endpoint", BaseURL: "http://localhost:8000/v1"})
+	var env strings.Builder
+	var names []string
+	for _, key := range chat.ConfigKeys() {
+		if strings.HasPrefix(key.Name, "KEYS_ENDPOINT_") || key.Name == "OPENAI_API_KEY" {
+			env.WriteString(key.Name + "=value\n")
+			names = append(names, key.Name)
+		}
+	}
+	if len(names) != 4 {
+		t.Fatalf("the keys are %v, want KEYS_ENDPOINT_API_KEY, KEYS_ENDPOINT_TIMEOUT, KEYS_ENDPOINT_MAX_RETRIES and OPENAI_API_KEY", names)
+	}
+	values, err := godotenv.Unmarshal(env.String())
+	if err != nil {
+		t.Fatalf("the .env file doesn't load: %v\n%s", err, env.String())
+	}
+	for _, name := range names {
+		if values[name] != "value" {
+			t.Errorf("%s is %q after loading the .env file", name, values[name])
+		}
+	}
+}
@@ -2,8 +2,10 @@ package models
 
 import (
 	"context"
+	"strings"
 
 	"github.com/google/generative-ai-go/genai"
+	"github.com/xssdoctor/gofabric/chat"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
@@ -12,7 +14,29 @@ type Gemini struct {
 	DefaultModel
 }
 
-func NewGemini(apiKey string, message string, pattern string, context string, model string, temperature float64, topP float64, session []map[string]string, responseChan chan string) *Gemini {
+// registers google with the chat package
+func init() {
+	chat.Register(chat.Provider{
+		Name: "google",
+		Keys: []chat.ConfigKey{
+			{Name: "GOOGLE_API_KEY", Prompt: "Enter your Google API key: (Leave blank if you don't have one)"},
+		},
+		Matches: func(model string) bool {
+			return strings.HasPrefix(model, "models/gemini") || strings.HasPrefix(model, "gemini-")
+		},
+		New: func(c chat.Chat) chat.Model {
+			model := NewGemini(c.ConfigValue("GOOGLE_API_KEY"), c.Message, c.Pattern, c.Context, c.Model, c.Temperature, c.TopP, c.Session, c.ResponseChan)
+			model.Attachments = c.Attachments
+			model.Tools = c.Tools
+			model.Schema = c.Schema
+			return model
+		},
+		Tools:  true,
+		Priced: true,
+	})
+}
+
+func NewGemini(apiKey string, message string, pattern string, context string, model string, temperature float64, topP float64, session []chat.Message, responseChan chan chat.StreamEvent) *Gemini {
 	if pattern == "" {
 		pattern = " "
 	}
@@ -32,12 +56,11 @@ func NewGemini(apiKey string, message string, pattern string, context string, mo
 	}
 }
 
-func (gem *Gemini) SendMessage() (string, error) {
+func (gem *Gemini) SendMessage(ctx context.Context) (chat.Response, error) {
 	finalResponse := ""
-	ctx := context.Background()
 	client, err := genai.NewClient(ctx, option.WithAPIKey(gem.ApiKey))
 	if err != nil {
-		return "", err
+		return chat.Response{}, err
 	}
 	defer client.Close()
 	model := client.GenerativeModel(gem.Model)
@@ -46,9 +69,18 @@ func (gem *Gemini) SendMessage() (string, error) {
 			genai.Part(genai.Text(gem.Context + gem.Pattern)),
 		},
 	}
-	response, err := model.GenerateContent(ctx, genai.Text(gem.Message))
+	model.Tools = geminiTools(gem.Tools)
+	// gemini can't call functions in json mode, so with tools the schema is only in the instructions
+	if len(gem.Schema) > 0 && len(gem.Tools) == 0 {
+		model.ResponseMIMEType = "application/json"
+		model.ResponseSchema = geminiResponseSchema(gem.Schema)
+	}
+	cs := model.StartChat()
+	history, parts := CreateGeminiHistory(gem) // replays the session before the new message
+	cs.History = history
+	response, err := cs.SendMessage(ctx, parts...)
 	if err != nil {
-		return "", err
+		return geminiBlocked(err), wrapGeminiError(err)
 	}
 	for _, cand := range response.Candidates {
 		if cand.Content != nil {
@@ -60,14 +92,19 @@ func (gem *Gemini) SendMessage() (string, error) {
 			}
 		}
 	}
-	return finalResponse, nil
+	result := geminiResponse(response)
+	result.Text = finalResponse
+	result.ToolCalls = geminiToolCalls(response)
+	if len(result.ToolCalls) > 0 {
+		result.FinishReason = chat.FinishToolCalls
+	}
+	return result, nil
 }
 
-func (gem *Gemini) StreamMessage() (error) {
-	ctx := context.Background()
+func (gem *Gemini) StreamMessage(ctx context.Context) (chat.Response, error) {
 	client, err := genai.NewClient(ctx, option.WithAPIKey(gem.ApiKey))
 	if err != nil {
-		return err
+		return chat.Response{}, err
 	}
 	defer client.Close()
 	model := client.GenerativeModel(gem.Model)
@@ -76,22 +113,36 @@ func (gem *Gemini) StreamMessage() (error) {
 			genai.Part(genai.Text(gem.Context + gem.Pattern)),
 		},
 	}
-	iter := model.GenerateContentStream(ctx, genai.Text(gem.Message))
+	cs := model.StartChat()
+	history, parts := CreateGeminiHistory(gem)
+	cs.History = history
+	iter := cs.SendMessageStream(ctx, parts...)
+	var result chat.Response
 	for {
 		resp, err := iter.Next()
 		if err == iterator.Done {
-			gem.ResponseChan <- "\n"
-			close(gem.ResponseChan)
-			return nil
+			gem.ResponseChan <- chat.StreamEvent{Text: "\n"}
+			return result, nil
 		}
 		if err != nil {
-			return err
+			if blocked := geminiBlocked(err); blocked.Refusal != "" {
+				return blocked, err
+			}
+			return result, wrapGeminiError(err)
+		}
+		// the usage and finish reason of a chunk cover the response so far
+		chunk := geminiResponse(resp)
+		if !chunk.Usage.IsZero() {
+			result.Usage = chunk.Usage
+		}
+		if chunk.FinishReason != "" {
+			result.FinishReason = chunk.FinishReason
 		}
 		for _, cand := range resp.Candidates {
 			if cand.Content != nil {
 				for _, part := range cand.Content.Parts {
 					if text, ok := part.(genai.Text); ok {
-						gem.ResponseChan <- string(text)
+						gem.ResponseChan <- chat.StreamEvent{Text: string(text)}
 					}
 				}
 			}
@@ -99,9 +150,31 @@ func (gem *Gemini) StreamMessage() (error) {
 	}
 }
 
-func (gem *Gemini) ListModels() ([]string, error) {
+// returns the embeddings of the texts
+func (gem *Gemini) Embed(ctx context.Context, texts []string) (chat.Embeddings, error) {
+	client, err := genai.NewClient(ctx, option.WithAPIKey(gem.ApiKey))
+	if err != nil {
+		return chat.Embeddings{}, err
+	}
+	defer client.Close()
+	model := client.EmbeddingModel(gem.Model)
+	batch := model.NewBatch()
+	for _, text := range texts {
+		batch.AddContent(genai.Text(text))
+	}
+	resp, err := model.BatchEmbedContents(ctx, batch)
+	if err != nil {
+		return chat.Embeddings{}, wrapGeminiError(err)
+	}
+	var embeddings chat.Embeddings
+	for _, embedding := range resp.Embeddings {
+		embeddings.Vectors = append(embeddings.Vectors, embedding.Values)
+	}
+	return embeddings, nil
+}
+
+func (gem *Gemini) ListModels(ctx context.Context) ([]string, error) {
 	var finalList []string
-	ctx := context.Background()
 	client, err := genai.NewClient(ctx, option.WithAPIKey(gem.ApiKey))
 	if err != nil {
 		return []string{}, err
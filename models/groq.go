@@ -7,6 +7,7 @@ import (
 	"io"
 
 	openai "github.com/sashabaranov/go-openai"
+	"github.com/xssdoctor/gofabric/chat"
 )
 
 // create Openai struct
@@ -14,7 +15,26 @@ type Groq struct {
 	DefaultModel
 }
 
-func NewGroq(apiKey string, message string, pattern string, context string, model string, temperature float64, topP float64, presencePenalty float64, FrequencyPenalty float64, session []map[string]string, responseChan chan string) *Groq {
+// registers groq with the chat package. groq hosts open models, so there is no naming rule and its models are only found through the listing
+func init() {
+	chat.Register(chat.Provider{
+		Name: "groq",
+		Keys: []chat.ConfigKey{
+			{Name: "GROQ_API_KEY", Prompt: "Enter your Groq API key: (Leave blank if you don't have one)"},
+		},
+		New: func(c chat.Chat) chat.Model {
+			model := NewGroq(c.ConfigValue("GROQ_API_KEY"), c.Message, c.Pattern, c.Context, c.Model, c.Temperature, c.TopP, c.PresencePenalty, c.FrequencyPenalty, c.Session, c.ResponseChan)
+			model.Attachments = c.Attachments
+			model.Tools = c.Tools
+			model.Schema = c.Schema
+			return model
+		},
+		Tools:  true,
+		Priced: true,
+	})
+}
+
+func NewGroq(apiKey string, message string, pattern string, context string, model string, temperature float64, topP float64, presencePenalty float64, FrequencyPenalty float64, session []chat.Message, responseChan chan chat.StreamEvent) *Groq {
 	return &Groq{
 		DefaultModel: DefaultModel{
 			Message: message,
@@ -34,7 +54,7 @@ func NewGroq(apiKey string, message string, pattern string, context string, mode
 }
 
 // creates a Sendmessage method which yields the message or an error
-func (Groq *Groq) SendMessage() (string, error){
+func (Groq *Groq) SendMessage(ctx context.Context) (chat.Response, error){
 	// If context is int the Openai struct, contextMessage will be CONTEXT:\n[context], otherwise contextMessage will be ""
     if Groq.Context != "" {
         Groq.Context = "CONTEXT:\n" + Groq.Context + "\n" // set context to "CONTEXT:\n[context]"
@@ -42,10 +62,12 @@ func (Groq *Groq) SendMessage() (string, error){
 	// gives default values for Temperature, TopP, PresencePenalty and FrequencyPenalty if not mentioned
     config := openai.DefaultConfig(Groq.ApiKey)
     config.BaseURL = "https://api.groq.com/openai/v1"
+	recorder := &statusRecorder{}
+	config.HTTPClient = recorder.client()
 	client := openai.NewClientWithConfig(config)
 	messages := CreateGroqMessage(Groq)
 	resp, err := client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
 			Model: Groq.Model,
 			Temperature: float32(Groq.Temperature),
@@ -53,70 +75,66 @@ func (Groq *Groq) SendMessage() (string, error){
 			PresencePenalty: float32(Groq.PresencePenalty),
 			FrequencyPenalty:float32(Groq.FrequencyPenalty),
 			Messages: messages,
+			Tools: openaiTools(Groq.Tools),
+			ResponseFormat: openaiResponseFormat(Groq.Schema),
 		},
 	)
 	if err != nil {
-		return "", err
+		return chat.Response{}, recorder.wrap(err)
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return openaiResponse(resp), nil
 }
 
 // streams message AND yields a message and an error for futher processing if necessary
-func (Groq Groq) StreamMessage() (error) {
+// groq only reports the usage of a stream outside of the openai format, so it is left to the chat package to estimate
+func (Groq Groq) StreamMessage(ctx context.Context) (chat.Response, error) {
 	// If context is int the Openai struct, contextMessage will be CONTEXT:\n[context], otherwise contextMessage will be ""
     if Groq.Context != "" {
         Groq.Context = "CONTEXT:\n" + Groq.Context + "\n" // set context to CONTEXT\n[context]
     }
 	config := openai.DefaultConfig(Groq.ApiKey)
     config.BaseURL = "https://api.groq.com/openai/v1"
+	recorder := &statusRecorder{}
+	config.HTTPClient = recorder.client()
 	c := openai.NewClientWithConfig(config)
-	ctx := context.Background()
+	messages := CreateGroqMessage(&Groq)
 	req := openai.ChatCompletionRequest{
 		Model:     Groq.Model,
 		Temperature: float32(Groq.Temperature),
 		TopP: float32(Groq.TopP),
 		PresencePenalty: float32(Groq.PresencePenalty),
 		FrequencyPenalty: float32(Groq.FrequencyPenalty),
-		Messages: []openai.ChatCompletionMessage{
-			{
-				Role: openai.ChatMessageRoleSystem,
-				Content: Groq.Context + Groq.Pattern, // if no context or pattern are passed as arguments, this will evaluate to ""
-			},
-			{
-				Role:    openai.ChatMessageRoleUser,
-				Content: Groq.Message,
-			},
-		},
+		Messages: messages,
 		Stream: true,
 	}
 	stream, err := c.CreateChatCompletionStream(ctx, req)
 	if err != nil {
-		fmt.Printf("ChatCompletionStream error: %v\n", err)
-		return err
+		return chat.Response{}, recorder.wrap(fmt.Errorf("ChatCompletionStream error: %w", err))
 	}
 	defer stream.Close()
+	result := chat.Response{RequestID: stream.Header().Get("x-request-id")}
 	for {
 		response, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
-			Groq.ResponseChan <- "\n"
-			close(Groq.ResponseChan)
-			return nil
+			Groq.ResponseChan <- chat.StreamEvent{Text: "\n"}
+			return result, nil
 			
 		}
 
 		if err != nil {
-			fmt.Printf("\nStream error: %v\n", err)
-			return err
+			return result, recorder.wrap(fmt.Errorf("stream error: %w", err))
+		}
+		addOpenaiStreamResponse(&result, response)
+		if len(response.Choices) > 0 {
+			Groq.ResponseChan <- chat.StreamEvent{Text: response.Choices[0].Delta.Content}
 		}
-		Groq.ResponseChan <- response.Choices[0].Delta.Content
 	}
 }
 
 	// returns a list of all available openai models
-func (Groq Groq)ListModels() ([]string, error) {
+func (Groq Groq)ListModels(ctx context.Context) ([]string, error) {
 	var modelList []string
-	ctx := context.Background()
     config := openai.DefaultConfig(Groq.ApiKey)
     config.BaseURL = "https://api.groq.com/openai/v1"
 	client := openai.NewClientWithConfig(config)
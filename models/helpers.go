@@ -1,18 +1,44 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/generative-ai-go/genai"
 	claude "github.com/potproject/claude-sdk-go"
 	openai "github.com/sashabaranov/go-openai"
+	"github.com/xssdoctor/gofabric/chat"
 )
 
-func CreateOllamaMessages(model *Ollama) []map[string]string {
-	// Initialize a slice of map[string]string
-	messageList := []map[string]string{}
+// the new message as it is added to the end of the session
+func (model DefaultModel) userMessage() chat.Message {
+	return chat.Message{Role: chat.RoleUser, Content: model.Message, Attachments: model.Attachments}
+}
+
+// the session followed by the new message. the session is copied, since it can be shared with other requests
+func (model DefaultModel) messages() []chat.Message {
+	messages := make([]chat.Message, 0, len(model.Session)+1)
+	messages = append(messages, model.Session...)
+	if model.answeringTools() {
+		return messages
+	}
+	return append(messages, model.userMessage())
+}
+
+// reports whether the model is being sent the results of its tool calls, which end the session, instead of a new message
+func (model DefaultModel) answeringTools() bool {
+	return model.Message == "" && len(model.Attachments) == 0 && len(model.Session) > 0 && model.Session[len(model.Session)-1].Role == chat.RoleTool
+}
+
+func CreateOllamaMessages(model *Ollama) []map[string]interface{} {
+	// Initialize a slice of map[string]interface{}
+	messageList := []map[string]interface{}{}
 
 	// Check if Pattern or Context is not empty
 	if model.Pattern != "" || model.Context != "" {
 		// Construct the map for the system role
-		systemMap := map[string]string{
+		systemMap := map[string]interface{}{
 			"role":    "system",
 			"content": model.Context + model.Pattern,
 		}
@@ -20,112 +46,419 @@ func CreateOllamaMessages(model *Ollama) []map[string]string {
 		messageList = append(messageList, systemMap)
 	}
 
-	// Check if Session is not nil
-	if model.Session != nil {
-		// Append the Session map directly as it matches the expected type
-		messageList = append(messageList, model.Session...)
-	}
-	userMessage := map[string]string{
-		"role":    "user",
-		"content": model.Message,
+	// replays the whole session before the new message. ollama takes the images of a message as base64
+	for _, message := range model.messages() {
+		messageMap := map[string]interface{}{
+			"role":    message.Role,
+			"content": message.Content,
+		}
+		if len(message.Attachments) > 0 {
+			images := make([]string, 0, len(message.Attachments))
+			for _, attachment := range message.Attachments {
+				images = append(images, attachment.Base64())
+			}
+			messageMap["images"] = images
+		}
+		messageList = append(messageList, messageMap)
 	}
-	messageList = append(messageList, userMessage)
 
 	return messageList
 }
 
+// groq uses the openai api, so the messages are built the same way
 func CreateGroqMessage(grok *Groq) []openai.ChatCompletionMessage {
+	return createOpenaiMessages(grok.DefaultModel)
+}
+
+func CreateOaiMessage(oai *Openai) []openai.ChatCompletionMessage {
+	return createOpenaiMessages(oai.DefaultModel)
+}
+
+// builds the system prompt, the session and the new message in the format used by openai compatible apis
+func createOpenaiMessages(model DefaultModel) []openai.ChatCompletionMessage {
 	messageList := []openai.ChatCompletionMessage{}
 
-	if grok.Pattern != "" || grok.Context != "" {
+	if model.Pattern != "" || model.Context != "" {
 		systemMap := openai.ChatCompletionMessage{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: grok.Context + grok.Pattern,
+			Content: model.Context + model.Pattern,
 		}
 		messageList = append(messageList, systemMap)
 	}
 
-	if grok.Session != nil {
-		sessionAsCompletionMessage := []openai.ChatCompletionMessage{}
-		for _, sess := range grok.Session{
-		for role, content := range sess {
-			sessionAsCompletionMessage = append(sessionAsCompletionMessage, openai.ChatCompletionMessage{
-				Role:    role,
-				Content: content,
-			})
-		}}
-		messageList = append(messageList, sessionAsCompletionMessage...)
-		messageList = append(messageList, openai.ChatCompletionMessage{
-			Role: openai.ChatMessageRoleSystem,
-			Content: grok.Context + grok.Pattern,
+	for _, message := range model.messages() {
+		switch message.Role {
+		case chat.RoleAssistant:
+			messageList = append(messageList, openaiAssistantMessage(message))
+		case chat.RoleTool:
+			// every result is a message of its own
+			for _, result := range message.ToolResults {
+				messageList = append(messageList, openai.ChatCompletionMessage{
+					Role:       openai.ChatMessageRoleTool,
+					Content:    result.Content,
+					Name:       result.Name,
+					ToolCallID: result.CallID,
+				})
+			}
+		default:
+			messageList = append(messageList, openaiMessage(openai.ChatMessageRoleUser, message))
+		}
+	}
+
+	return messageList
+}
+
+// replies that called tools carry the calls
+func openaiAssistantMessage(message chat.Message) openai.ChatCompletionMessage {
+	result := openaiMessage(openai.ChatMessageRoleAssistant, message)
+	for _, call := range message.ToolCalls {
+		result.ToolCalls = append(result.ToolCalls, openai.ToolCall{
+			ID:       call.ID,
+			Type:     openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: call.Name, Arguments: string(call.Arguments)},
 		})
 	}
+	return result
+}
 
-	userMessage := openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleUser,
-		Content: grok.Message,
+// declares the tools as functions, the only kind of tool openai compatible apis take
+func openaiTools(tools []chat.Tool) []openai.Tool {
+	var list []openai.Tool
+	for _, tool := range tools {
+		list = append(list, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
 	}
-	messageList = append(messageList, userMessage)
+	return list
+}
 
-	return messageList
+// asks for a json object when the reply must match a schema of one. json mode can't return anything else, so other schemas are only in the instructions
+func openaiResponseFormat(schema json.RawMessage) *openai.ChatCompletionResponseFormat {
+	if schemaType(schema) != "object" {
+		return nil
+	}
+	return &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+}
+
+// returns the type at the top of a json schema, or "" when it has none
+func schemaType(schema json.RawMessage) string {
+	var top struct {
+		Type interface{} `json:"type"`
+	}
+	json.Unmarshal(schema, &top)
+	name, _ := top.Type.(string)
+	return name
 }
 
+// messages with images are sent as parts, the images first and the text after them
+func openaiMessage(role string, message chat.Message) openai.ChatCompletionMessage {
+	if len(message.Attachments) == 0 {
+		return openai.ChatCompletionMessage{Role: role, Content: message.Content}
+	}
+	parts := make([]openai.ChatMessagePart, 0, len(message.Attachments)+1)
+	for _, attachment := range message.Attachments {
+		parts = append(parts, openai.ChatMessagePart{
+			Type:     openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{URL: attachment.DataURL(), Detail: openai.ImageURLDetailAuto},
+		})
+	}
+	if message.Content != "" {
+		parts = append(parts, openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: message.Content})
+	}
+	return openai.ChatCompletionMessage{Role: role, MultiContent: parts}
+}
 
-func CreateOaiMessage(oai *Openai) []openai.ChatCompletionMessage {
-	messageList := []openai.ChatCompletionMessage{}
+// claude takes the system prompt separately, so only the session and the new message are built here
+func CreateClaudeMessage(ant *Anthropic) []claude.RequestBodyMessagesMessages {
+	messageList := []claude.RequestBodyMessagesMessages{}
 
-	if oai.Pattern != "" || oai.Context != "" {
-		systemMap := openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleSystem,
-			Content: oai.Context + oai.Pattern,
+	for _, message := range ant.messages() {
+		role := claude.MessagesRoleUser
+		if message.Role == chat.RoleAssistant {
+			role = claude.MessagesRoleAssistant
 		}
-		messageList = append(messageList, systemMap)
+		messageList = append(messageList, claudeMessage(role, message))
 	}
 
-	if oai.Session != nil {
-		sessionAsCompletionMessage := []openai.ChatCompletionMessage{}
-		for _, sess := range oai.Session{
-		for range sess {
-			sessionAsCompletionMessage = append(sessionAsCompletionMessage, openai.ChatCompletionMessage{
-				Role:    sess["Role"],
-				Content: sess["Content"],
-			})
-		
-		}}
-		messageList = append(messageList, sessionAsCompletionMessage...)
-		messageList = append(messageList, openai.ChatCompletionMessage{
-			Role: openai.ChatMessageRoleSystem,
-			Content: oai.Context + oai.Pattern,
+	return messageList
+}
+
+// messages with images are sent as image blocks followed by a text block. the sdk can only send one kind of block in a message, so the blocks are built here and sent as they are
+func claudeMessage(role string, message chat.Message) claude.RequestBodyMessagesMessages {
+	if len(message.Attachments) == 0 {
+		return claude.RequestBodyMessagesMessages{Role: role, Content: message.Content}
+	}
+	blocks := make([]interface{}, 0, len(message.Attachments)+1)
+	for _, attachment := range message.Attachments {
+		blocks = append(blocks, claude.RequestBodyMessagesMessagesContentTypeImage{
+			Type: claude.RequestBodyMessagesMessagesContentTypeImageType,
+			Source: claude.RequestBodyMessagesMessagesContentTypeImageSource{
+				Type:      "base64",
+				MediaType: attachment.MimeType,
+				Data:      attachment.Base64(),
+			},
 		})
 	}
+	if message.Content != "" {
+		blocks = append(blocks, claude.RequestBodyMessagesMessagesContentTypeText{
+			Type: claude.RequestBodyMessagesMessagesContentTypeTextType,
+			Text: message.Content,
+		})
+	}
+	return claude.RequestBodyMessagesMessages{Role: role, ContentRaw: blocks}
+}
 
-	userMessage := openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleUser,
-		Content: oai.Message,
+// gemini replays the session as the chat history. the last message, which is the new message or the results of the tool calls, is sent separately. the replies use the model role
+func CreateGeminiHistory(gem *Gemini) ([]*genai.Content, []genai.Part) {
+	messages := gem.messages()
+	history := []*genai.Content{}
+	for _, message := range messages[:len(messages)-1] {
+		role := "user"
+		if message.Role == chat.RoleAssistant {
+			role = "model"
+		}
+		history = append(history, &genai.Content{
+			Role:  role,
+			Parts: geminiParts(message),
+		})
 	}
-	messageList = append(messageList, userMessage)
+	return history, geminiParts(messages[len(messages)-1])
+}
 
-	return messageList
+// gemini takes images as blobs next to the text. tool calls and their results are parts of their own
+func geminiParts(message chat.Message) []genai.Part {
+	parts := make([]genai.Part, 0, len(message.Attachments)+1)
+	for _, attachment := range message.Attachments {
+		parts = append(parts, genai.Blob{MIMEType: attachment.MimeType, Data: attachment.Data})
+	}
+	for _, result := range message.ToolResults {
+		key := "content"
+		if result.IsError {
+			key = "error"
+		}
+		parts = append(parts, genai.FunctionResponse{Name: result.Name, Response: map[string]any{key: result.Content}})
+	}
+	if message.Content != "" || (len(parts) == 0 && len(message.ToolCalls) == 0) {
+		parts = append(parts, genai.Text(message.Content))
+	}
+	for _, call := range message.ToolCalls {
+		parts = append(parts, genai.FunctionCall{Name: call.Name, Args: call.ParseArguments()})
+	}
+	return parts
 }
 
-func CreateClaudeMessage(ant *Anthropic) []claude.RequestBodyMessagesMessages {
-	messageList := []claude.RequestBodyMessagesMessages{}
+// declares the tools as functions. gemini takes a subset of json schema, so the parameters are converted
+func geminiTools(tools []chat.Tool) []*genai.Tool {
+	if len(tools) == 0 {
+		return nil
+	}
+	declarations := make([]*genai.FunctionDeclaration, 0, len(tools))
+	for _, tool := range tools {
+		var schema map[string]any
+		json.Unmarshal(tool.Parameters, &schema)
+		declaration := &genai.FunctionDeclaration{Name: tool.Name, Description: tool.Description}
+		// gemini rejects an object without properties, so tools without arguments have no parameters
+		if properties, _ := schema["properties"].(map[string]any); len(properties) > 0 {
+			declaration.Parameters = geminiSchema(schema)
+		}
+		declarations = append(declarations, declaration)
+	}
+	return []*genai.Tool{{FunctionDeclarations: declarations}}
+}
 
-	if ant.Session != nil {
-		for _, ses := range ant.Session {
-		for role, content := range ses {
-			messageList = append(messageList, claude.RequestBodyMessagesMessages{
-				Role:    role,
-				Content: content,
-			})
+// converts the schema of the reply for gemini's json mode. schemas gemini can't express return nil, and gemini is then only asked for json
+func geminiResponseSchema(contents json.RawMessage) *genai.Schema {
+	var schema map[string]any
+	if json.Unmarshal(contents, &schema) != nil || !geminiCanExpress(schema) {
+		return nil
+	}
+	return geminiSchema(schema)
+}
+
+// whether every part of the schema has a single type and no references or combinations, and every object has properties
+func geminiCanExpress(schema map[string]any) bool {
+	for _, keyword := range []string{"$ref", "anyOf", "oneOf", "allOf", "not"} {
+		if _, ok := schema[keyword]; ok {
+			return false
+		}
+	}
+	name, ok := schema["type"].(string)
+	if !ok {
+		return false
+	}
+	items, _ := schema["items"].(map[string]any)
+	if name == "array" && (items == nil || !geminiCanExpress(items)) {
+		return false
+	}
+	properties, _ := schema["properties"].(map[string]any)
+	if name == "object" && len(properties) == 0 {
+		return false
+	}
+	for _, property := range properties {
+		if property, ok := property.(map[string]any); !ok || !geminiCanExpress(property) {
+			return false
 		}
-	}}
+	}
+	return true
+}
 
-	userMessage := claude.RequestBodyMessagesMessages{
-		Role:    claude.MessagesRoleUser,
-		Content: ant.Message,
+// converts a json schema to gemini's. what gemini can't express is left out
+func geminiSchema(schema map[string]any) *genai.Schema {
+	result := &genai.Schema{}
+	switch schema["type"] {
+	case "string":
+		result.Type = genai.TypeString
+	case "number":
+		result.Type = genai.TypeNumber
+	case "integer":
+		result.Type = genai.TypeInteger
+	case "boolean":
+		result.Type = genai.TypeBoolean
+	case "array":
+		result.Type = genai.TypeArray
+	default:
+		result.Type = genai.TypeObject
+	}
+	result.Description, _ = schema["description"].(string)
+	if enum, ok := schema["enum"].([]any); ok {
+		for _, value := range enum {
+			result.Enum = append(result.Enum, fmt.Sprint(value))
+		}
+		result.Format = "enum"
+	}
+	if items, ok := schema["items"].(map[string]any); ok {
+		result.Items = geminiSchema(items)
+	}
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		result.Properties = map[string]*genai.Schema{}
+		for name, property := range properties {
+			if property, ok := property.(map[string]any); ok {
+				result.Properties[name] = geminiSchema(property)
+			}
+		}
 	}
-	messageList = append(messageList, userMessage)
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				result.Required = append(result.Required, name)
+			}
+		}
+	}
+	return result
+}
 
-	return messageList
-}
\ No newline at end of file
+// reads the calls gemini wants to make. gemini doesn't give them ids, so they are numbered
+func geminiToolCalls(resp *genai.GenerateContentResponse) []chat.ToolCall {
+	var calls []chat.ToolCall
+	if resp == nil {
+		return nil
+	}
+	for _, cand := range resp.Candidates {
+		if cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			if call, ok := part.(genai.FunctionCall); ok {
+				arguments, _ := json.Marshal(call.Args)
+				calls = append(calls, chat.ToolCall{ID: fmt.Sprintf("call-%d", len(calls)), Name: call.Name, Arguments: arguments})
+			}
+		}
+	}
+	return calls
+}
+
+// converts the usage reported by openai compatible apis
+func openaiUsage(usage openai.Usage) chat.Usage {
+	return chat.Usage{InputTokens: usage.PromptTokens, OutputTokens: usage.CompletionTokens}
+}
+
+// converts the response of an openai compatible api. the finish reasons already match the chat package
+func openaiResponse(resp openai.ChatCompletionResponse) chat.Response {
+	response := chat.Response{
+		Usage:     openaiUsage(resp.Usage),
+		Model:     resp.Model,
+		RequestID: resp.Header().Get("x-request-id"),
+	}
+	if len(resp.Choices) > 0 {
+		response.Text = resp.Choices[0].Message.Content
+		response.FinishReason = string(resp.Choices[0].FinishReason)
+		for _, call := range resp.Choices[0].Message.ToolCalls {
+			arguments := call.Function.Arguments
+			if arguments == "" {
+				arguments = "{}"
+			}
+			response.ToolCalls = append(response.ToolCalls, chat.ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: json.RawMessage(arguments)})
+		}
+	}
+	if response.FinishReason == chat.FinishContentFilter {
+		response.Refusal = "the answer was blocked by the provider's content filter"
+	}
+	return response
+}
+
+// adds what a chunk of an openai compatible stream says about the response. the text is streamed separately
+func addOpenaiStreamResponse(response *chat.Response, chunk openai.ChatCompletionStreamResponse) {
+	if chunk.Model != "" {
+		response.Model = chunk.Model
+	}
+	if chunk.Usage != nil {
+		response.Usage = openaiUsage(*chunk.Usage)
+	}
+	for _, choice := range chunk.Choices {
+		if choice.FinishReason != "" && choice.FinishReason != openai.FinishReasonNull {
+			response.FinishReason = string(choice.FinishReason)
+		}
+	}
+	if response.FinishReason == chat.FinishContentFilter {
+		response.Refusal = "the answer was blocked by the provider's content filter"
+	}
+}
+
+// maps claude's stop reasons to the chat package's
+func claudeFinishReason(stopReason string) string {
+	switch stopReason {
+	case "end_turn", "stop_sequence":
+		return chat.FinishStop
+	case "max_tokens":
+		return chat.FinishLength
+	case "tool_use":
+		return chat.FinishToolCalls
+	}
+	return stopReason
+}
+
+// converts the metadata of a gemini response. the text is read by the caller
+func geminiResponse(resp *genai.GenerateContentResponse) chat.Response {
+	var response chat.Response
+	if resp == nil {
+		return response
+	}
+	if resp.UsageMetadata != nil {
+		response.Usage = chat.Usage{InputTokens: int(resp.UsageMetadata.PromptTokenCount), OutputTokens: int(resp.UsageMetadata.CandidatesTokenCount)}
+	}
+	if len(resp.Candidates) > 0 {
+		switch resp.Candidates[0].FinishReason {
+		case genai.FinishReasonStop:
+			response.FinishReason = chat.FinishStop
+		case genai.FinishReasonMaxTokens:
+			response.FinishReason = chat.FinishLength
+		case genai.FinishReasonSafety, genai.FinishReasonRecitation:
+			response.FinishReason = chat.FinishContentFilter
+		}
+	}
+	return response
+}
+
+// gemini reports blocked prompts and answers as errors. this turns them into a refusal, and returns an empty response for other errors
+func geminiBlocked(err error) chat.Response {
+	var blocked *genai.BlockedError
+	if !errors.As(err, &blocked) {
+		return chat.Response{}
+	}
+	return chat.Response{FinishReason: chat.FinishContentFilter, Refusal: blocked.Error()}
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// collects the text sent to a response channel. the returned function waits for the channel to be closed and returns the text
+func collectStream(ch chan chat.StreamEvent) func() string {
+	done := make(chan string)
+	go func() {
+		var text strings.Builder
+		for event := range ch {
+			text.WriteString(event.Text)
+		}
+		done <- text.String()
+	}()
+	return func() string {
+		close(ch)
+		return <-done
+	}
+}
+
+// the helpers run in the handlers of stand-in servers, so they report problems with t.Error, which can be called from any goroutine
+
+// writes events as server sent events, each as one line of json after data:
+func writeEvents(t *testing.T, w http.ResponseWriter, events ...interface{}) {
+	t.Helper()
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+}
+
+// writes v as the json body of a response
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	t.Helper()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Error(err)
+	}
+}
+
+// reads the json body of a request the stand-in server was sent
+func readJSON(t *testing.T, r *http.Request, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		t.Errorf("could not read the request: %v", err)
+	}
+}
+
+// the session of an earlier turn, replayed before the new message
+var testSession = []chat.Message{
+	{Role: chat.RoleUser, Content: "what is 2+2?"},
+	{Role: chat.RoleAssistant, Content: "4"},
+}
+
+// a request as an openai compatible api is sent it
+type openaiTestRequest struct {
+	Model    string `json:"model"`
+	Stream   bool   `json:"stream"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+}
+
+// checks that the messages are the pattern, the session and the new message, in that order
+func checkReplayed(t *testing.T, roles []string, contents []string) {
+	t.Helper()
+	wantRoles := []string{"system", "user", "assistant", "user"}
+	wantContents := []string{"be brief", "what is 2+2?", "4", "and 3+3?"}
+	if strings.Join(roles, ",") != strings.Join(wantRoles, ",") {
+		t.Errorf("roles are %v, want %v", roles, wantRoles)
+		return
+	}
+	for i, want := range wantContents {
+		if contents[i] != want {
+			t.Errorf("message %d is %q, want %q", i, contents[i], want)
+		}
+	}
+}
+
+// checks the session replay of a request to an openai compatible api
+func (request openaiTestRequest) checkReplayed(t *testing.T) {
+	t.Helper()
+	var roles, contents []string
+	for _, message := range request.Messages {
+		roles = append(roles, message.Role)
+		contents = append(contents, message.Content)
+	}
+	checkReplayed(t, roles, contents)
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+type Mistral struct {
+	DefaultModel
+}
+
+// registers mistral with the chat package. names with a tag, such as mistral:latest, are models pulled into ollama
+func init() {
+	chat.Register(chat.Provider{
+		Name: "mistral",
+		Keys: []chat.ConfigKey{
+			{Name: "MISTRAL_API_KEY", Prompt: "Enter your Mistral API key: (Leave blank if you don't have one)"},
+			{Name: "MISTRAL_BASE_URL", Default: "https://api.mistral.ai/v1"}, // only needs changing for a proxy or a local stand-in
+		},
+		Matches: func(model string) bool {
+			if strings.Contains(model, ":") {
+				return false
+			}
+			for _, prefix := range []string{"mistral-", "open-mistral-", "open-mixtral-", "codestral-", "pixtral-"} {
+				if strings.HasPrefix(model, prefix) {
+					return true
+				}
+			}
+			return false
+		},
+		New: func(c chat.Chat) chat.Model {
+			model := NewMistral(c.ConfigValue("MISTRAL_API_KEY"), c.Message, c.Pattern, c.Context, c.Model, c.Temperature, c.TopP, c.Session, c.ResponseChan)
+			model.Attachments = c.Attachments
+			model.Tools = c.Tools
+			model.Schema = c.Schema
+			model.Url = c.ConfigValue("MISTRAL_BASE_URL")
+			return model
+		},
+		Tools:  true,
+		Priced: true,
+	})
+}
+
+// mistral's api doesn't take the presence and frequency penalties, so they are not sent
+func NewMistral(apiKey string, message string, pattern string, context string, model string, temperature float64, topP float64, session []chat.Message, responseChan chan chat.StreamEvent) *Mistral {
+	return &Mistral{
+		DefaultModel{
+			Message:      message,
+			Pattern:      pattern,
+			Context:      context,
+			Model:        model,
+			ApiKey:       apiKey,
+			Temperature:  temperature,
+			TopP:         topP,
+			Session:      session,
+			ResponseChan: responseChan,
+		},
+	}
+}
+
+// sends the message and returns the answer
+func (mis *Mistral) SendMessage(ctx context.Context) (chat.Response, error) {
+	if mis.Context != "" {
+		mis.Context = "CONTEXT:\n" + mis.Context + "\n" // set context to "CONTEXT:\n[context]"
+	}
+	recorder := &statusRecorder{}
+	client := mis.buildClient(recorder)
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:          mis.Model,
+		Temperature:    float32(mis.Temperature),
+		TopP:           float32(mis.TopP),
+		Messages:       createOpenaiMessages(mis.DefaultModel),
+		Tools:          openaiTools(mis.Tools),
+		ResponseFormat: openaiResponseFormat(mis.Schema),
+	})
+	if err != nil {
+		return chat.Response{}, recorder.wrap(err)
+	}
+	return openaiResponse(resp), nil
+}
+
+// streams the answer to the response channel. mistral sends the usage with the last chunk without being asked
+func (mis *Mistral) StreamMessage(ctx context.Context) (chat.Response, error) {
+	if mis.Context != "" {
+		mis.Context = "CONTEXT:\n" + mis.Context + "\n" // set context to CONTEXT\n[context]
+	}
+	recorder := &statusRecorder{}
+	client := mis.buildClient(recorder)
+	stream, err := client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model:       mis.Model,
+		Temperature: float32(mis.Temperature),
+		TopP:        float32(mis.TopP),
+		Messages:    createOpenaiMessages(mis.DefaultModel),
+		Stream:      true,
+	})
+	if err != nil {
+		return chat.Response{}, recorder.wrap(fmt.Errorf("ChatCompletionStream error: %w", err))
+	}
+	defer stream.Close()
+	var result chat.Response
+	for {
+		response, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			mis.ResponseChan <- chat.StreamEvent{Text: "\n"}
+			return result, nil
+		}
+		if err != nil {
+			return result, recorder.wrap(fmt.Errorf("stream error: %w", err))
+		}
+		if response.ID != "" {
+			result.RequestID = response.ID
+		}
+		addOpenaiStreamResponse(&result, response)
+		if len(response.Choices) > 0 {
+			mis.ResponseChan <- chat.StreamEvent{Text: response.Choices[0].Delta.Content}
+		}
+	}
+}
+
+// returns the models of the account
+func (mis *Mistral) ListModels(ctx context.Context) ([]string, error) {
+	if mis.ApiKey == "" {
+		return []string{}, errors.New("no mistral api key")
+	}
+	models, err := mis.buildClient(nil).ListModels(ctx)
+	if err != nil {
+		return []string{}, err
+	}
+	var modelList []string
+	for _, model := range models.Models {
+		modelList = append(modelList, model.ID)
+	}
+	return modelList, nil
+}
+
+// mistral speaks the openai api at its own url
+func (mis *Mistral) buildClient(recorder *statusRecorder) *openai.Client {
+	config := openai.DefaultConfig(mis.ApiKey)
+	config.BaseURL = "https://api.mistral.ai/v1"
+	if mis.Url != "" {
+		config.BaseURL = strings.TrimSuffix(mis.Url, "/")
+	}
+	if recorder != nil {
+		config.HTTPClient = recorder.client()
+	}
+	return openai.NewClientWithConfig(config)
+}
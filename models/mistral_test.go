@@ -0,0 +1,107 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// a stand-in for mistral's api at /v1
+func mistralStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer mk" {
+			t.Errorf("Authorization header is %q, want Bearer mk", got)
+		}
+		switch r.URL.Path {
+		case "/v1/models":
+			writeJSON(t, w, map[string]interface{}{"object": "list", "data": []interface{}{
+				map[string]string{"id": "mistral-large-latest", "object": "model"},
+				map[string]string{"id": "open-mistral-nemo", "object": "model"},
+			}})
+		case "/v1/chat/completions":
+			var body map[string]json.RawMessage
+			readJSON(t, r, &body)
+			// mistral rejects the parameters it doesn't know
+			for _, name := range []string{"presence_penalty", "frequency_penalty", "stream_options"} {
+				if _, ok := body[name]; ok {
+					t.Errorf("mistral was sent %s", name)
+				}
+			}
+			var request openaiTestRequest
+			data, _ := json.Marshal(body)
+			json.Unmarshal(data, &request)
+			request.checkReplayed(t)
+			if request.Stream {
+				writeEvents(t, w,
+					map[string]interface{}{"id": "ms-2", "model": request.Model, "choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]string{"role": "assistant", "content": "si"}}}},
+					map[string]interface{}{"id": "ms-2", "model": request.Model, "choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]string{"content": "x"}, "finish_reason": "stop"}}, "usage": map[string]int{"prompt_tokens": 12, "completion_tokens": 2}},
+				)
+				w.Write([]byte("data: [DONE]\n\n"))
+				return
+			}
+			writeJSON(t, w, map[string]interface{}{
+				"id":      "ms-1",
+				"model":   request.Model,
+				"choices": []interface{}{map[string]interface{}{"index": 0, "message": map[string]string{"role": "assistant", "content": "6"}, "finish_reason": "stop"}},
+				"usage":   map[string]int{"prompt_tokens": 12, "completion_tokens": 1},
+			})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestMistral(url string, responseChan chan chat.StreamEvent) *Mistral {
+	mistral := NewMistral("mk", "and 3+3?", "be brief", "", "mistral-large-latest", 0.7, 0.9, testSession, responseChan)
+	mistral.Url = url
+	return mistral
+}
+
+func TestMistralSendMessage(t *testing.T) {
+	server := mistralStandIn(t)
+	response, err := newTestMistral(server.URL+"/v1/", nil).SendMessage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Text != "6" || response.FinishReason != chat.FinishStop || response.Usage != (chat.Usage{InputTokens: 12, OutputTokens: 1}) || response.Model != "mistral-large-latest" {
+		t.Errorf("response is %+v", response)
+	}
+}
+
+func TestMistralStreamMessage(t *testing.T) {
+	server := mistralStandIn(t)
+	responseChan := make(chan chat.StreamEvent)
+	text := collectStream(responseChan)
+	response, err := newTestMistral(server.URL+"/v1", responseChan).StreamMessage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := text(); got != "six\n" {
+		t.Errorf("streamed %q, want %q", got, "six\n")
+	}
+	if response.RequestID != "ms-2" || response.FinishReason != chat.FinishStop || response.Usage != (chat.Usage{InputTokens: 12, OutputTokens: 2}) {
+		t.Errorf("response is %+v", response)
+	}
+}
+
+func TestMistralListModels(t *testing.T) {
+	server := mistralStandIn(t)
+	models, err := newTestMistral(server.URL+"/v1", nil).ListModels(context.Background())
+	if want := []string{"mistral-large-latest", "open-mistral-nemo"}; err != nil || !reflect.DeepEqual(models, want) {
+		t.Errorf("ListModels returned %v, %v, want %v", models, err, want)
+	}
+	mistral := newTestMistral(server.URL+"/v1", nil)
+	mistral.ApiKey = ""
+	if _, err := mistral.ListModels(context.Background()); err == nil {
+		t.Error("ListModels without an api key returned no error")
+	}
+}
@@ -3,22 +3,58 @@ package models
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/xssdoctor/gofabric/chat"
 )
 
 type Ollama struct {
 	DefaultModel
 }
 
+// registers ollama with the chat package. local models are tagged name:tag, which is the rule used when the server can't be listed. local generation can be slow, so it gets a longer timeout
+func init() {
+	chat.Register(chat.Provider{
+		Name: "ollama",
+		Keys: []chat.ConfigKey{
+			{Name: "OLLAMA_URL", Prompt: "Enter your Ollama URL: (leave blank if you don't have one or if you want the default of localhost:11434)", Default: "http://127.0.0.1:11434"},
+		},
+		Matches: func(model string) bool {
+			return strings.Contains(model, ":")
+		},
+		New: func(c chat.Chat) chat.Model {
+			model := NewOllama(c.ConfigValue("OLLAMA_URL"), c.Message, c.Pattern, c.Context, c.Model, c.Temperature, c.TopP, c.PresencePenalty, c.FrequencyPenalty, c.Session, c.ResponseChan)
+			model.Attachments = c.Attachments
+			model.Schema = c.Schema
+			return model
+		},
+		Timeout: 10 * time.Minute,
+	})
+}
+
 type ResponseData struct {
 	Model      string `json:"model"`
 	CreatedAt  string `json:"created_at"`
 	Message    MessageData `json:"message"`
 	Done       bool `json:"done"`
+	PromptEvalCount int `json:"prompt_eval_count"` // tokens in the prompt. only set on the last response
+	EvalCount  int `json:"eval_count"` // tokens in the reply. only set on the last response
+	DoneReason string `json:"done_reason"` // stop, or length when the reply hit the token limit. only set on the last response
+}
+
+// fills in the metadata of the last response
+func (data ResponseData) toResponse() chat.Response {
+    return chat.Response{
+        FinishReason: data.DoneReason,
+        Usage:        chat.Usage{InputTokens: data.PromptEvalCount, OutputTokens: data.EvalCount},
+        Model:        data.Model,
+    }
 }
 
 type MessageData struct {
@@ -48,7 +84,7 @@ type OllamaDetails struct {
     Quantization_level string `json:"quantization_level"`
 }
 
-func NewOllama(url string, message string, pattern string, context string, model string, temperature float64, topP float64, presencePenalty float64, FrequencyPenalty float64, session []map[string]string, responseChan chan string) *Ollama{
+func NewOllama(url string, message string, pattern string, context string, model string, temperature float64, topP float64, presencePenalty float64, FrequencyPenalty float64, session []chat.Message, responseChan chan chat.StreamEvent) *Ollama{
     return &Ollama{
         DefaultModel{
             Message: message,
@@ -67,8 +103,21 @@ func NewOllama(url string, message string, pattern string, context string, model
     }
 }
 
+// turns an error status from the ollama server into an error the chat package can tell apart. ollama explains the error in the body
+func ollamaStatusError(resp *http.Response) error {
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+    message := strings.TrimSpace(string(body))
+    var apiErr struct {
+        Error string `json:"error"`
+    }
+    if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+        message = apiErr.Error
+    }
+    return &chat.StatusError{StatusCode: resp.StatusCode, RetryAfter: retryAfter(resp.StatusCode, resp.Header), Err: fmt.Errorf("ollama: %s: %s", resp.Status, message)}
+}
+
 // returns the message or an error
-func (ollama *Ollama) SendMessage() (string, error) {
+func (ollama *Ollama) SendMessage(ctx context.Context) (chat.Response, error) {
     if ollama.Context != "" {
         ollama.Context = "CONTEXT:\n" + ollama.Context + "\n" // sets context to CONTEXT:\n[context]
     }
@@ -86,28 +135,35 @@ func (ollama *Ollama) SendMessage() (string, error) {
             "top_p":             ollama.TopP,
         },
     }
+    // ollama's json mode only makes sure the reply is json. the schema is in the instructions
+    if len(ollama.Schema) > 0 {
+        payload["format"] = "json"
+    }
 
     requestBody, err := json.Marshal(payload)
     if err != nil {
-        return "", err
+        return chat.Response{}, err
     }
 
     client := &http.Client{}
-    req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+    req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
     if err != nil {
-        return "", err
+        return chat.Response{}, err
     }
 
     req.Header.Add("Content-Type", "application/json")
     resp, err := client.Do(req)
     if err != nil {
-        return "", err
+        return chat.Response{}, err
     }
     defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return chat.Response{}, ollamaStatusError(resp)
+    }
 
     responseBody, err := io.ReadAll(resp.Body)
     if err != nil {
-        return "", err
+        return chat.Response{}, err
     }
 
     // Preprocess response body to form a valid JSON array
@@ -116,17 +172,22 @@ func (ollama *Ollama) SendMessage() (string, error) {
     var responses []ResponseData
     err = json.Unmarshal([]byte(validJson), &responses)
     if err != nil {
-        return "", fmt.Errorf("json unmarshaling error: %v", err)
+        return chat.Response{}, fmt.Errorf("json unmarshaling error: %v", err)
     }
 
+    var result chat.Response
     for _, response := range responses {
         finalMessage += response.Message.Content
+        if response.Done {
+            result = response.toResponse()
+        }
     }
+    result.Text = finalMessage
 
-    return finalMessage, nil
+    return result, nil
 }
 
-func (ollama *Ollama) StreamMessage() (error) {
+func (ollama *Ollama) StreamMessage(ctx context.Context) (chat.Response, error) {
 
     if ollama.Context!= "" {
         ollama.Context = "CONTEXT:\n" + ollama.Context + "\n"
@@ -143,36 +204,47 @@ func (ollama *Ollama) StreamMessage() (error) {
             "top_p":             ollama.TopP,
         },
     }
+    // ollama's json mode only makes sure the reply is json. the schema is in the instructions
+    if len(ollama.Schema) > 0 {
+        payload["format"] = "json"
+    }
     requestBody, err := json.Marshal(payload)
     if err != nil {
-        return err
+        return chat.Response{}, err
     }
 
     client := &http.Client{}
-    req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(requestBody)))
+    req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer([]byte(requestBody)))
     if err != nil {
-        return err
+        return chat.Response{}, err
     }
 
     req.Header.Add("Content-Type", "application/json")
     resp, err := client.Do(req)
     if err != nil {
-        return err
+        return chat.Response{}, err
     }
     defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return chat.Response{}, ollamaStatusError(resp)
+    }
     reader := bufio.NewReader(resp.Body)
     var buffer bytes.Buffer
     var responses []ResponseData
+    var result chat.Response
+    finished := false
 
     for {
         line, err := reader.ReadBytes('\n') // Assumes that each JSON object ends with a newline
         if err == io.EOF {
-            ollama.ResponseChan <- "\n"
-            close(ollama.ResponseChan)
-            return nil
+            if !finished {
+                return result, fmt.Errorf("ollama: the stream ended before the response was complete: %w", io.ErrUnexpectedEOF)
+            }
+            ollama.ResponseChan <- chat.StreamEvent{Text: "\n"}
+            return result, nil
         }
         if err != nil {
-            return err // Handle other errors
+            return result, err // Handle other errors
         }
 
         // Attempt to unmarshal each line as a JSON object
@@ -184,16 +256,24 @@ func (ollama *Ollama) StreamMessage() (error) {
 
         // Process the successfully unmarshaled messages
         for _, response := range responses {
-           ollama.ResponseChan <- response.Message.Content
+           ollama.ResponseChan <- chat.StreamEvent{Text: response.Message.Content}
+           if response.Done {
+               result = response.toResponse()
+               finished = true
+           }
         }
         buffer.Reset() // Clear the buffer once the data is processed
     }
 }
 
-func (ollama Ollama) ListModels()([]string, error) {
+func (ollama Ollama) ListModels(ctx context.Context)([]string, error) {
     var finalModels []string
 	url := ollama.Url + "/api/tags"
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return []string{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return []string{}, err
 	}
@@ -214,4 +294,41 @@ func (ollama Ollama) ListModels()([]string, error) {
 		fmt.Printf("Error reading the response body: %s\n", err)
 	}
 	return finalModels, nil
-}
\ No newline at end of file
+}
+// returns the embeddings of the texts. /api/embeddings takes one text at a time
+func (ollama *Ollama) Embed(ctx context.Context, texts []string) (chat.Embeddings, error) {
+	var embeddings chat.Embeddings
+	for _, text := range texts {
+		requestBody, err := json.Marshal(map[string]string{"model": ollama.Model, "prompt": text})
+		if err != nil {
+			return chat.Embeddings{}, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", ollama.Url+"/api/embeddings", bytes.NewBuffer(requestBody))
+		if err != nil {
+			return chat.Embeddings{}, err
+		}
+		req.Header.Add("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return chat.Embeddings{}, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := ollamaStatusError(resp)
+			resp.Body.Close()
+			return chat.Embeddings{}, err
+		}
+		var result struct {
+			Embedding []float32 `json:"embedding"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return chat.Embeddings{}, fmt.Errorf("json unmarshaling error: %v", err)
+		}
+		if len(result.Embedding) == 0 {
+			return chat.Embeddings{}, fmt.Errorf("ollama: %s returned no embedding. Is it an embedding model?", ollama.Model)
+		}
+		embeddings.Vectors = append(embeddings.Vectors, result.Embedding)
+	}
+	return embeddings, nil
+}
@@ -5,19 +5,46 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"strings"
 
 	openai "github.com/sashabaranov/go-openai"
+	"github.com/xssdoctor/gofabric/chat"
 )
 
-// create Openai struct
+// create Openai struct. endpoints that speak the openai api use it too, with their own url and headers
 type Openai struct {
 	DefaultModel
+	Headers map[string]string // sent with every request
+	Models  []string          // listed instead of asking the server
 }
 
-func NewOpenai(apiKey string, message string, pattern string, context string, model string, temperature float64, topP float64, presencePenalty float64, FrequencyPenalty float64, session []map[string]string, responseChan chan string) *Openai {
+// registers openai with the chat package
+func init() {
+	chat.Register(chat.Provider{
+		Name: "openai",
+		Keys: []chat.ConfigKey{
+			{Name: "OPENAI_API_KEY", Prompt: "Enter your OpenAI API key: (Leave blank if you don't have one)"},
+		},
+		Matches: func(model string) bool {
+			return strings.HasPrefix(model, "gpt-") || strings.HasPrefix(model, "chatgpt-")
+		},
+		New: func(c chat.Chat) chat.Model {
+			model := NewOpenai(c.ConfigValue("OPENAI_API_KEY"), c.Message, c.Pattern, c.Context, c.Model, c.Temperature, c.TopP, c.PresencePenalty, c.FrequencyPenalty, c.Session, c.ResponseChan)
+			model.Attachments = c.Attachments
+			model.Tools = c.Tools
+			model.Schema = c.Schema
+			return model
+		},
+		Tools:  true,
+		Priced: true,
+	})
+}
+
+func NewOpenai(apiKey string, message string, pattern string, context string, model string, temperature float64, topP float64, presencePenalty float64, FrequencyPenalty float64, session []chat.Message, responseChan chan chat.StreamEvent) *Openai {
 	return &Openai{
-		DefaultModel{
+		DefaultModel: DefaultModel{
 			Message:          message,
 			Pattern:          pattern,
 			Context:          context,
@@ -34,16 +61,17 @@ func NewOpenai(apiKey string, message string, pattern string, context string, mo
 }
 
 // creates a Sendmessage method which yields the message or an error
-func (oai *Openai) SendMessage() (string, error) {
+func (oai *Openai) SendMessage(ctx context.Context) (chat.Response, error) {
 	// If context is int the Openai struct, contextMessage will be CONTEXT:\n[context], otherwise contextMessage will be ""
 	if oai.Context != "" {
 		oai.Context = "CONTEXT:\n" + oai.Context + "\n" // set context to "CONTEXT:\n[context]"
 	}
 	// gives default values for Temperature, TopP, PresencePenalty and FrequencyPenalty if not mentioned
-	client := oai.buildClient()
+	recorder := &statusRecorder{}
+	client := oai.buildClient(recorder)
 	messages := CreateOaiMessage(oai)
 	resp, err := client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
 			Model:            oai.Model,
 			Temperature:      float32(oai.Temperature),
@@ -51,24 +79,26 @@ func (oai *Openai) SendMessage() (string, error) {
 			PresencePenalty:  float32(oai.PresencePenalty),
 			FrequencyPenalty: float32(oai.FrequencyPenalty),
 			Messages:         messages,
+			Tools:            openaiTools(oai.Tools),
+			ResponseFormat:   openaiResponseFormat(oai.Schema),
 		},
 	)
 	if err != nil {
-		return "", err
+		return chat.Response{}, recorder.wrap(err)
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return openaiResponse(resp), nil
 }
 
 // streams message AND yields a message and an error for futher processing if necessary
-func (oai *Openai) StreamMessage() error {
+func (oai *Openai) StreamMessage(ctx context.Context) (chat.Response, error) {
 	// If context is int the Openai struct, contextMessage will be CONTEXT:\n[context], otherwise contextMessage will be ""
 	if oai.Context != "" {
 		oai.Context = "CONTEXT:\n" + oai.Context + "\n" // set context to CONTEXT\n[context]
 	}
-	c := oai.buildClient()
+	recorder := &statusRecorder{}
+	c := oai.buildClient(recorder)
 	messages := CreateOaiMessage(oai)
-	ctx := context.Background()
 	req := openai.ChatCompletionRequest{
 		Model:            oai.Model,
 		Temperature:      float32(oai.Temperature),
@@ -77,35 +107,39 @@ func (oai *Openai) StreamMessage() error {
 		FrequencyPenalty: float32(oai.FrequencyPenalty),
 		Messages:         messages,
 		Stream:           true,
+		StreamOptions:    &openai.StreamOptions{IncludeUsage: true}, // the last chunk carries the usage and no choices
 	}
 	stream, err := c.CreateChatCompletionStream(ctx, req)
 	if err != nil {
-		fmt.Printf("ChatCompletionStream error: %v\n", err)
-		return err
+		return chat.Response{}, recorder.wrap(fmt.Errorf("ChatCompletionStream error: %w", err))
 	}
 	defer stream.Close()
+	result := chat.Response{RequestID: stream.Header().Get("x-request-id")}
 	for {
 		response, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
-			oai.ResponseChan <- "\n"
-			close(oai.ResponseChan)
-			return nil
+			oai.ResponseChan <- chat.StreamEvent{Text: "\n"}
+			return result, nil
 
 		}
 
 		if err != nil {
-			fmt.Printf("\nStream error: %v\n", err)
-			return err
+			return result, recorder.wrap(fmt.Errorf("stream error: %w", err))
+		}
+		addOpenaiStreamResponse(&result, response)
+		if len(response.Choices) > 0 {
+			oai.ResponseChan <- chat.StreamEvent{Text: response.Choices[0].Delta.Content}
 		}
-		oai.ResponseChan <- response.Choices[0].Delta.Content
 	}
 }
 
 // returns a list of all available openai models
-func (oai *Openai) ListModels() ([]string, error) {
+func (oai *Openai) ListModels(ctx context.Context) ([]string, error) {
+	if len(oai.Models) > 0 {
+		return append([]string{}, oai.Models...), nil
+	}
 	var modelList []string
-	ctx := context.Background()
-	client := oai.buildClient()
+	client := oai.buildClient(nil)
 	modelsTemp, err := client.ListModels(ctx)
 	if err != nil {
 		return []string{}, err
@@ -117,13 +151,60 @@ func (oai *Openai) ListModels() ([]string, error) {
 	return modelList, nil
 }
 
-func (oai *Openai) buildClient() *openai.Client {
+// returns the embeddings of the texts
+func (oai *Openai) Embed(ctx context.Context, texts []string) (chat.Embeddings, error) {
+	recorder := &statusRecorder{}
+	client := oai.buildClient(recorder)
+	resp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: texts,
+		Model: openai.EmbeddingModel(oai.Model),
+	})
+	if err != nil {
+		return chat.Embeddings{}, recorder.wrap(err)
+	}
+	embeddings := chat.Embeddings{
+		Vectors: make([][]float32, len(texts)),
+		Usage:   chat.Usage{InputTokens: resp.Usage.PromptTokens},
+	}
+	// every embedding says which text it belongs to
+	for _, data := range resp.Data {
+		if data.Index >= 0 && data.Index < len(texts) {
+			embeddings.Vectors[data.Index] = data.Embedding
+		}
+	}
+	for i, vector := range embeddings.Vectors {
+		if vector == nil {
+			return chat.Embeddings{}, fmt.Errorf("openai returned no embedding for text %d", i+1)
+		}
+	}
+	return embeddings, nil
+}
+
+// builds the client. the recorder, when there is one, keeps the status of failed requests so they can be retried
+func (oai *Openai) buildClient(recorder *statusRecorder) *openai.Client {
 	config := openai.DefaultConfig(oai.ApiKey)
-	// get the base url for the openai api with env variable named OPENAI_BASE_URL in case user needs to change it
-	baseUrl := os.Getenv("OPENAI_BASE_URL")
-	if baseUrl != "" {
-		config.BaseURL = baseUrl
+	if recorder != nil {
+		config.HTTPClient = recorder.client()
 	}
+	if len(oai.Headers) > 0 {
+		transport := config.HTTPClient.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		config.HTTPClient = &http.Client{Transport: headerTransport{headers: oai.Headers, base: transport}}
+	}
+	config.BaseURL = oai.baseURL()
 	client := openai.NewClientWithConfig(config)
 	return client
 }
+
+// get the base url for the openai api with env variable named OPENAI_BASE_URL in case user needs to change it. endpoints have their own
+func (oai *Openai) baseURL() string {
+	if oai.Url != "" {
+		return strings.TrimSuffix(oai.Url, "/")
+	}
+	if baseUrl := os.Getenv("OPENAI_BASE_URL"); baseUrl != "" {
+		return strings.TrimSuffix(baseUrl, "/")
+	}
+	return openai.DefaultConfig("").BaseURL
+}
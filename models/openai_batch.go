@@ -0,0 +1,194 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// SubmitBatch uploads the requests as a jsonl file and starts a batch on it. openai has 24 hours to finish it
+func (oai *Openai) SubmitBatch(ctx context.Context, requests []chat.BatchRequest) (string, error) {
+	if oai.Context != "" {
+		oai.Context = "CONTEXT:\n" + oai.Context + "\n" // set context to CONTEXT:\n[context]
+	}
+	upload := openai.UploadBatchFileRequest{FileName: "fabric-batch.jsonl"}
+	for _, request := range requests {
+		model := oai.DefaultModel
+		model.Pattern = request.Pattern
+		model.Message = request.Message
+		upload.AddChatCompletion(request.ID, openai.ChatCompletionRequest{
+			Model:            oai.Model,
+			Temperature:      float32(oai.Temperature),
+			TopP:             float32(oai.TopP),
+			PresencePenalty:  float32(oai.PresencePenalty),
+			FrequencyPenalty: float32(oai.FrequencyPenalty),
+			Messages:         createOpenaiMessages(model),
+			ResponseFormat:   openaiResponseFormat(oai.Schema),
+		})
+	}
+	recorder := &statusRecorder{}
+	client := oai.buildClient(recorder)
+	batch, err := client.CreateBatchWithUploadFile(ctx, openai.CreateBatchWithUploadFileRequest{
+		Endpoint:               openai.BatchEndpointChatCompletions,
+		UploadBatchFileRequest: upload,
+	})
+	if err != nil {
+		return "", recorder.wrap(err)
+	}
+	return batch.ID, nil
+}
+
+// BatchStatus returns how far openai is with the batch
+func (oai *Openai) BatchStatus(ctx context.Context, id string) (chat.BatchStatus, error) {
+	batch, err := oai.retrieveBatch(ctx, id)
+	if err != nil {
+		return chat.BatchStatus{}, err
+	}
+	return batch.status(), nil
+}
+
+// BatchResults reads the results of the requests that succeeded from the output file and the failures from the error file
+func (oai *Openai) BatchResults(ctx context.Context, id string) ([]chat.BatchResult, error) {
+	batch, err := oai.retrieveBatch(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	recorder := &statusRecorder{}
+	client := oai.buildClient(recorder)
+	var results []chat.BatchResult
+	for _, fileID := range []string{batch.OutputFileID, batch.ErrorFileID} {
+		if fileID == "" {
+			continue
+		}
+		fileResults, err := openaiBatchFileResults(ctx, client, fileID)
+		if err != nil {
+			return nil, recorder.wrap(err)
+		}
+		results = append(results, fileResults...)
+	}
+	return results, nil
+}
+
+// a batch as openai describes it. the sdk's version can't read the errors of a failed batch, which come as a list
+type openaiBatch struct {
+	Status        string                    `json:"status"`
+	OutputFileID  string                    `json:"output_file_id"`
+	ErrorFileID   string                    `json:"error_file_id"`
+	RequestCounts openai.BatchRequestCounts `json:"request_counts"`
+	Errors        *struct {
+		Data []struct {
+			Message string `json:"message"`
+		} `json:"data"`
+	} `json:"errors"`
+}
+
+func (oai *Openai) retrieveBatch(ctx context.Context, id string) (openaiBatch, error) {
+	var batch openaiBatch
+	header := http.Header{"Authorization": {"Bearer " + oai.ApiKey}}
+	err := doJSON(ctx, oai.httpClient(), http.MethodGet, oai.baseURL()+"/batches/"+url.PathEscape(id), header, nil, &batch)
+	return batch, err
+}
+
+// maps openai's batch states to the chat package's
+func (batch openaiBatch) status() chat.BatchStatus {
+	status := chat.BatchStatus{
+		Total:     batch.RequestCounts.Total,
+		Succeeded: batch.RequestCounts.Completed,
+		Failed:    batch.RequestCounts.Failed,
+	}
+	switch batch.Status {
+	case "completed":
+		status.State = chat.BatchEnded
+	case "failed":
+		status.State = chat.BatchFailed
+		if batch.Errors != nil {
+			var messages []string
+			for _, e := range batch.Errors.Data {
+				messages = append(messages, e.Message)
+			}
+			status.Error = strings.Join(messages, "; ")
+		}
+	case "expired":
+		status.State = chat.BatchExpired
+	case "cancelled":
+		status.State = chat.BatchCancelled
+	default:
+		// validating, in_progress, finalizing and cancelling
+		status.State = chat.BatchRunning
+	}
+	return status
+}
+
+// a line of the output and error files of a batch
+type openaiBatchLine struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		RequestID  string          `json:"request_id"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func openaiBatchFileResults(ctx context.Context, client *openai.Client, fileID string) ([]chat.BatchResult, error) {
+	content, err := client.GetFileContent(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	var results []chat.BatchResult
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var line openaiBatchLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("could not read the results of the batch: %v", err)
+		}
+		results = append(results, openaiBatchResult(line))
+	}
+	return results, scanner.Err()
+}
+
+func openaiBatchResult(line openaiBatchLine) chat.BatchResult {
+	result := chat.BatchResult{ID: line.CustomID}
+	if line.Error != nil {
+		result.Err = errors.New(line.Error.Message)
+		return result
+	}
+	if line.Response == nil {
+		result.Err = errors.New("no response")
+		return result
+	}
+	if line.Response.StatusCode != 200 {
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(line.Response.Body, &body)
+		result.Err = &chat.StatusError{StatusCode: line.Response.StatusCode, Err: errors.New(body.Error.Message)}
+		return result
+	}
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(line.Response.Body, &resp); err != nil {
+		result.Err = err
+		return result
+	}
+	result.Response = openaiResponse(resp)
+	result.Response.RequestID = line.Response.RequestID
+	return result
+}
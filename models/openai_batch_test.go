@@ -0,0 +1,163 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+// a line of the jsonl file a batch is uploaded as
+type openaiTestBatchLine struct {
+	CustomID string            `json:"custom_id"`
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Body     openaiTestRequest `json:"body"`
+}
+
+func newTestOpenai(url string) *Openai {
+	oai := NewOpenai("ok", "", "", "", "gpt-4o-mini", 0.7, 0.9, 0, 0, nil, nil)
+	oai.Url = url
+	return oai
+}
+
+func TestOpenaiSubmitBatch(t *testing.T) {
+	var lines []openaiTestBatchLine
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/files":
+			if got := r.FormValue("purpose"); got != "batch" {
+				t.Errorf("the file was uploaded for %q, want batch", got)
+			}
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			contents, _ := io.ReadAll(file)
+			for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+				var batchLine openaiTestBatchLine
+				if err := json.Unmarshal([]byte(line), &batchLine); err != nil {
+					t.Errorf("could not read the line %s: %v", line, err)
+				}
+				lines = append(lines, batchLine)
+			}
+			writeJSON(t, w, map[string]string{"id": "file-1", "object": "file", "purpose": "batch"})
+		case "/v1/batches":
+			var body struct {
+				InputFileID string `json:"input_file_id"`
+				Endpoint    string `json:"endpoint"`
+			}
+			readJSON(t, r, &body)
+			if body.InputFileID != "file-1" || body.Endpoint != "/v1/chat/completions" {
+				t.Errorf("the batch was created with %+v", body)
+			}
+			writeJSON(t, w, map[string]string{"id": "batch_1", "object": "batch", "status": "validating"})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	id, err := newTestOpenai(server.URL+"/v1").SubmitBatch(context.Background(), []chat.BatchRequest{
+		{ID: "request-0", Pattern: "summarize", Message: "first"},
+		{ID: "request-1", Pattern: "extract", Message: "second"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "batch_1" {
+		t.Errorf("the batch id is %s, want batch_1", id)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("the file has %d requests, want 2", len(lines))
+	}
+	for i, want := range []struct{ id, pattern, message string }{{"request-0", "summarize", "first"}, {"request-1", "extract", "second"}} {
+		line := lines[i]
+		if line.CustomID != want.id || line.Method != "POST" || line.URL != "/v1/chat/completions" || line.Body.Model != "gpt-4o-mini" {
+			t.Errorf("line %d is %+v", i, line)
+		}
+		if len(line.Body.Messages) != 2 || line.Body.Messages[0].Content != want.pattern || line.Body.Messages[1].Content != want.message {
+			t.Errorf("line %d has the messages %+v, want the pattern %s and the message %s", i, line.Body.Messages, want.pattern, want.message)
+		}
+	}
+}
+
+func TestOpenaiBatchStatus(t *testing.T) {
+	for _, test := range []struct {
+		status string
+		state  string
+	}{
+		{"validating", chat.BatchRunning},
+		{"in_progress", chat.BatchRunning},
+		{"finalizing", chat.BatchRunning},
+		{"cancelling", chat.BatchRunning},
+		{"completed", chat.BatchEnded},
+		{"failed", chat.BatchFailed},
+		{"expired", chat.BatchExpired},
+		{"cancelled", chat.BatchCancelled},
+	} {
+		batch := openaiBatch{Status: test.status}
+		batch.RequestCounts.Total, batch.RequestCounts.Completed, batch.RequestCounts.Failed = 3, 2, 1
+		want := chat.BatchStatus{State: test.state, Total: 3, Succeeded: 2, Failed: 1}
+		if got := batch.status(); got != want {
+			t.Errorf("%s is %+v, want %+v", test.status, got, want)
+		}
+	}
+
+	var batch openaiBatch
+	json.Unmarshal([]byte(`{"status": "failed", "errors": {"data": [{"message": "bad line 1"}, {"message": "bad line 2"}]}}`), &batch)
+	if got := batch.status().Error; got != "bad line 1; bad line 2" {
+		t.Errorf("the error of a failed batch is %q", got)
+	}
+}
+
+func TestOpenaiBatchResults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer ok" {
+			t.Errorf("Authorization header is %q, want Bearer ok", got)
+		}
+		switch r.URL.Path {
+		case "/v1/batches/batch_1":
+			writeJSON(t, w, map[string]interface{}{"id": "batch_1", "status": "completed", "output_file_id": "file-out", "error_file_id": "file-err"})
+		case "/v1/files/file-out/content":
+			w.Write([]byte(`{"custom_id": "request-1", "response": {"status_code": 200, "request_id": "req-1", "body": {"model": "gpt-4o-mini-2024-07-18", "choices": [{"index": 0, "message": {"role": "assistant", "content": "two"}, "finish_reason": "stop"}], "usage": {"prompt_tokens": 5, "completion_tokens": 1}}}}` + "\n\n" +
+				`{"custom_id": "request-0", "response": {"status_code": 400, "body": {"error": {"message": "too long"}}}}` + "\n"))
+		case "/v1/files/file-err/content":
+			w.Write([]byte(`{"custom_id": "request-2", "error": {"code": "batch_expired", "message": "the request expired"}}` + "\n"))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	results, err := newTestOpenai(server.URL+"/v1").BatchResults(context.Background(), "batch_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	want := chat.Response{Text: "two", FinishReason: chat.FinishStop, Usage: chat.Usage{InputTokens: 5, OutputTokens: 1}, Model: "gpt-4o-mini-2024-07-18", RequestID: "req-1"}
+	if results[0].ID != "request-1" || results[0].Err != nil || !reflect.DeepEqual(results[0].Response, want) {
+		t.Errorf("the first result is %+v, want %+v", results[0], want)
+	}
+	var status *chat.StatusError
+	if results[1].ID != "request-0" || results[1].Err == nil || results[1].Err.Error() != "too long" {
+		t.Errorf("the second result is %+v, want the error too long", results[1])
+	} else if !errors.As(results[1].Err, &status) || status.StatusCode != 400 {
+		t.Errorf("the error of the second result is %v, want a status error with 400", results[1].Err)
+	}
+	if results[2].ID != "request-2" || results[2].Err == nil || results[2].Err.Error() != "the request expired" {
+		t.Errorf("the third result is %+v, want the error the request expired", results[2])
+	}
+}
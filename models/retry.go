@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xssdoctor/gofabric/chat"
+	"google.golang.org/api/googleapi"
+)
+
+// statusRecorder is an http transport that remembers the last error response of a request, so the model can tell the chat package its status and how long the provider wants us to wait. the sdks only pass on the error message
+type statusRecorder struct {
+	mu     sync.Mutex
+	status int
+	header http.Header
+}
+
+func (r *statusRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil && resp.StatusCode >= 400 {
+		r.mu.Lock()
+		r.status = resp.StatusCode
+		r.header = resp.Header.Clone()
+		r.mu.Unlock()
+	}
+	return resp, err
+}
+
+// returns an http client that records its error responses
+func (r *statusRecorder) client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// wraps err in a chat.StatusError when the provider answered with an error status
+func (r *statusRecorder) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status == 0 {
+		return err
+	}
+	return &chat.StatusError{StatusCode: r.status, RetryAfter: retryAfter(r.status, r.header), Err: err}
+}
+
+// gemini's errors already carry the status and the headers
+func wrapGeminiError(err error) error {
+	var apiErr *googleapi.Error
+	if err == nil || !errors.As(err, &apiErr) {
+		return err
+	}
+	return &chat.StatusError{StatusCode: apiErr.Code, RetryAfter: retryAfter(apiErr.Code, apiErr.Header), Err: err}
+}
+
+// rate limit headers of the providers. the reset tells when the limit is lifted, and only matters when nothing remains
+var rateLimitHeaders = []struct {
+	remaining string
+	reset     string
+}{
+	// openai and groq give the reset as a duration, e.g. 6m0s
+	{"x-ratelimit-remaining-requests", "x-ratelimit-reset-requests"},
+	{"x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens"},
+	// anthropic gives it as a time
+	{"anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-reset"},
+	{"anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-tokens-reset"},
+}
+
+// returns how long the provider wants us to wait before trying again, from Retry-After or, when it is rate limited, the reset of the exhausted limit
+func retryAfter(status int, header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+	wait := parseRetryAfter(header.Get("Retry-After"))
+	if status != http.StatusTooManyRequests {
+		return wait
+	}
+	for _, limit := range rateLimitHeaders {
+		if header.Get(limit.remaining) != "0" {
+			continue
+		}
+		reset := header.Get(limit.reset)
+		if d, err := time.ParseDuration(reset); err == nil && d > wait {
+			wait = d
+		} else if t, err := time.Parse(time.RFC3339, reset); err == nil && time.Until(t) > wait {
+			wait = time.Until(t)
+		}
+	}
+	return wait
+}
+
+// Retry-After is either a number of seconds or a date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if t, err := http.ParseTime(value); err == nil && time.Until(t) > 0 {
+		return time.Until(t)
+	}
+	return 0
+}
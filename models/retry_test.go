@@ -0,0 +1,168 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xssdoctor/gofabric/chat"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"", 0, 0},
+		{"5", 5 * time.Second, 5 * time.Second},
+		{"1.5", 1500 * time.Millisecond, 1500 * time.Millisecond},
+		{"0", 0, 0},
+		{"-3", 0, 0},
+		{"soon", 0, 0},
+		// the date form is counted from now, which moves while the test runs
+		{time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat), 80 * time.Second, 90 * time.Second},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+	} {
+		if got := parseRetryAfter(test.value); got < test.min || got > test.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", test.value, got, test.min, test.max)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	reset := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	for _, test := range []struct {
+		name   string
+		status int
+		header http.Header
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"no headers", http.StatusTooManyRequests, nil, 0, 0},
+		{"retry after", http.StatusServiceUnavailable, http.Header{"Retry-After": {"7"}}, 7 * time.Second, 7 * time.Second},
+		{"openai limit", http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}, "X-Ratelimit-Remaining-Tokens": {"0"}, "X-Ratelimit-Reset-Tokens": {"6m0s"}}, 6 * time.Minute, 6 * time.Minute},
+		{"limit left", http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}, "X-Ratelimit-Remaining-Requests": {"5"}, "X-Ratelimit-Reset-Requests": {"6m0s"}}, time.Second, time.Second},
+		{"anthropic limit", http.StatusTooManyRequests, http.Header{"Anthropic-Ratelimit-Requests-Remaining": {"0"}, "Anthropic-Ratelimit-Requests-Reset": {reset}}, 50 * time.Second, time.Minute},
+		// the reset only matters when the provider is rate limited
+		{"not rate limited", http.StatusServiceUnavailable, http.Header{"X-Ratelimit-Remaining-Tokens": {"0"}, "X-Ratelimit-Reset-Tokens": {"6m0s"}}, 0, 0},
+	} {
+		if got := retryAfter(test.status, test.header); got < test.min || got > test.max {
+			t.Errorf("%s: retryAfter = %v, want between %v and %v", test.name, got, test.min, test.max)
+		}
+	}
+}
+
+// registers a provider that sends its requests to the url in the RETRY_TEST_URL setting, so every test can use its own stand-in server
+func retryTestProvider() {
+	if _, ok := chat.GetProvider("retrytest"); ok {
+		return
+	}
+	chat.Register(chat.Provider{Name: "retrytest", New: func(c chat.Chat) chat.Model {
+		model := NewOpenai("ok", c.Message, c.Pattern, c.Context, c.Model, c.Temperature, c.TopP, c.PresencePenalty, c.FrequencyPenalty, c.Session, c.ResponseChan)
+		model.Url = c.ConfigValue("RETRY_TEST_URL")
+		return model
+	}})
+}
+
+// a stand-in server that answers with the statuses in turn, the last one for every request after them, and counts the requests
+func statusStandIn(t *testing.T, retryAfter string, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		status := statuses[len(statuses)-1]
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		if status != http.StatusOK {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error": {"message": "try again"}}`))
+			return
+		}
+		writeJSON(t, w, map[string]interface{}{
+			"id":      "cmpl-1",
+			"model":   "m",
+			"choices": []interface{}{map[string]interface{}{"index": 0, "message": map[string]string{"role": "assistant", "content": "hi"}, "finish_reason": "stop"}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func retryTestChat(url string) chat.Chat {
+	return chat.Chat{Model: "retrytest/m", Message: "hello", NoFallback: true, Config: map[string]string{"RETRY_TEST_URL": url + "/v1"}}
+}
+
+func TestWithRetries(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.MkdirAll(filepath.Join(home, ".config", "fabric"), 0755)
+	retryTestProvider()
+
+	// a rate limit is waited out and the request sent again
+	server, requests := statusStandIn(t, "0.1", http.StatusTooManyRequests, http.StatusOK)
+	response, err := retryTestChat(server.URL).SendMessageToModel(context.Background())
+	if err != nil || response.Text != "hi" {
+		t.Fatalf("the response is %+v, %v, want hi after a retry", response, err)
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("the server was sent %d requests, want 2", n)
+	}
+
+	for _, test := range []struct {
+		name       string
+		retryAfter string
+		status     int
+		retries    string
+		requests   int32
+	}{
+		{"not retryable", "", http.StatusBadRequest, "", 1},
+		{"waits too long", "600", http.StatusTooManyRequests, "", 1},
+		{"retries run out", "0.01", http.StatusServiceUnavailable, "1", 2},
+		{"no retries", "0.01", http.StatusServiceUnavailable, "0", 1},
+	} {
+		server, requests := statusStandIn(t, test.retryAfter, test.status)
+		c := retryTestChat(server.URL)
+		if test.retries != "" {
+			c.Config["RETRYTEST_MAX_RETRIES"] = test.retries
+		}
+		_, err := c.SendMessageToModel(context.Background())
+		var status *chat.StatusError
+		if !errors.As(err, &status) || status.StatusCode != test.status {
+			t.Errorf("%s: the error is %v, want a status error with %d", test.name, err, test.status)
+		}
+		if n := atomic.LoadInt32(requests); n != test.requests {
+			t.Errorf("%s: the server was sent %d requests, want %d", test.name, n, test.requests)
+		}
+	}
+}
+
+func TestWithRetriesStopsWhenCancelled(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.MkdirAll(filepath.Join(home, ".config", "fabric"), 0755)
+	retryTestProvider()
+
+	server, requests := statusStandIn(t, "60", http.StatusServiceUnavailable)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := retryTestChat(server.URL).SendMessageToModel(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("the error is %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the cancelled request waited %v for its retry", elapsed)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("the server was sent %d requests, want 1", n)
+	}
+}
@@ -25,4 +25,9 @@ func LogWarning(err error) {
 
 func Log(info string) {
 	fmt.Println(color.Green.Render(info))
-}
\ No newline at end of file
+}
+
+// LogProgress reports what a long task is doing on stderr, so it stays out of output that is piped on
+func LogProgress(info string) {
+	fmt.Fprintln(os.Stderr, color.Cyan.Render(info))
+}
@@ -48,7 +48,8 @@ func InsertIntoConfiguration(keyname string, apiKey string, createTables func())
 	if err != nil {
 		return err
 	}
-	openaiPattern := fmt.Sprintf("%s=(.*)\n", keyname)
+	// the key has to start its line, or OPENAI_API_KEY would also match AZURE_OPENAI_API_KEY
+	openaiPattern := fmt.Sprintf("(?m)^%s=(.*)\n", regexp.QuoteMeta(keyname))
 	regex, err := regexp.Compile(openaiPattern)
 	if err != nil {
 		return err
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a key that ends another key, like OPENAI_API_KEY ends AZURE_OPENAI_API_KEY, must not read or overwrite the longer one
+func TestConfigurationKeysMatchWholeNames(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	fileName := filepath.Join(home, ".config/fabric/.env")
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileName, []byte("AZURE_OPENAI_API_KEY=azure\nOPENAI_API_KEY=openai\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := FindRegex(`(?m)^OPENAI_API_KEY=(.*)\n`, fileName)
+	if err != nil || value != "openai" {
+		t.Errorf("OPENAI_API_KEY is %q, %v, want openai", value, err)
+	}
+	if err := InsertIntoConfiguration("OPENAI_API_KEY", "new", func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertIntoConfiguration("DEFAULT_MODEL", "gpt-4o", func() {}); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "AZURE_OPENAI_API_KEY=azure\nOPENAI_API_KEY=new\n\nDEFAULT_MODEL=gpt-4o\n"; string(contents) != want {
+		t.Errorf(".env is\n%s\nwant\n%s", contents, want)
+	}
+}